@@ -6,7 +6,9 @@ import (
 	"fmt"
 	"net/http"
 	"os"
+	"os/signal"
 	"syscall"
+	"time"
 
 	"github.com/cyverse/irods-csi-driver/pkg/common"
 	"github.com/cyverse/irods-csi-driver/pkg/driver"
@@ -26,6 +28,10 @@ func main() {
 	flag.StringVar(&conf.PoolServiceEndpoint, "poolservice", "unix:///tmp/poolsock", "iRODS FUSE Lite Pool Service endpoint")
 	flag.IntVar(&conf.PrometheusExporterPort, "prometheus_exporter_port", 12022, "Prometheus Exporter Service port")
 	flag.StringVar(&conf.StoragePath, "storagepath", "/storage", "Storage path for driver internal data")
+	flag.BoolVar(&conf.SweepOnStartup, "sweep_on_startup", false, "Unmount stale mounts and delete leftover data under storage path on startup (node service only)")
+	flag.StringVar(&conf.AdminEndpoint, "admin_endpoint", "", "Admin service endpoint for node operations like drain, use a unix domain socket (disabled if empty)")
+	flag.DurationVar(&conf.DrainTimeout, "drain_timeout", 2*time.Minute, "Time to wait for unmounts and syncs on drain")
+	flag.StringVar(&conf.Mode, "mode", common.AllMode, "Services to run, one of controller, node, or all")
 	flag.BoolVar(&version, "version", false, "Print driver version information")
 
 	klog.InitFlags(nil)
@@ -50,6 +56,11 @@ func main() {
 		klog.Fatalln("Node ID is not given")
 	}
 
+	if !common.IsValidMode(conf.Mode) {
+		// exit automatically
+		klog.Fatalf("Unknown mode %q", conf.Mode)
+	}
+
 	if conf.StoragePath != "" {
 		_, err := os.Stat(conf.StoragePath)
 		if err != nil {
@@ -93,6 +104,25 @@ func main() {
 		klog.Fatalln(drvErr)
 	}
 
+	// drain on termination, so overlayfs data is flushed before the node plugin exits
+	signalChan := make(chan os.Signal, 1)
+	signal.Notify(signalChan, syscall.SIGTERM, syscall.SIGINT)
+	go func() {
+		sig := <-signalChan
+
+		if conf.IsNodeServiceEnabled() {
+			klog.Infof("Received signal %q, draining", sig)
+
+			ctx, cancel := context.WithTimeout(context.Background(), conf.DrainTimeout)
+			drv.Drain(ctx)
+			cancel()
+		} else {
+			klog.Infof("Received signal %q, stopping", sig)
+		}
+
+		drv.Stop()
+	}()
+
 	// driver is created
 	err := drv.Run()
 	if err != nil {
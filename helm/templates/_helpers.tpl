@@ -62,6 +62,17 @@ Create the name of the service account to use
 {{- end -}}
 {{- end -}}
 
+{{/*
+Create the name of the service account to use for the node plugin
+*/}}
+{{- define "helm.nodeServiceAccountName" -}}
+{{- if .Values.nodeServiceAccount.create -}}
+    {{ default (printf "%s-node" (include "helm.fullname" .)) .Values.nodeServiceAccount.name }}
+{{- else -}}
+    {{ default "default" .Values.nodeServiceAccount.name }}
+{{- end -}}
+{{- end -}}
+
 {{/*
 Return the appropriate apiVersion for CSIDriver.
 */}}
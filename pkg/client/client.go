@@ -1,6 +1,7 @@
 package client
 
 import (
+	"context"
 	"os"
 
 	"google.golang.org/grpc/codes"
@@ -16,13 +17,14 @@ import (
 )
 
 // MountClient mounts a fs client
-func MountClient(mounter mounter.Mounter, volID string, configs map[string]string, mountOptions []string, targetPath string) error {
+// shared is true for staging mounts shared by pods via bind mounts, write access is checked at publish with CheckClientPermissions
+func MountClient(ctx context.Context, mounter mounter.Mounter, volID string, configs map[string]string, mountOptions []string, targetPath string, shared bool) error {
 	irodsClientType := common.GetClientType(configs)
 	switch irodsClientType {
 	case common.IrodsFuseClientType:
 		klog.V(5).Infof("mounting %q", irodsClientType)
 
-		if err := irods.Mount(mounter, volID, configs, mountOptions, targetPath); err != nil {
+		if err := irods.Mount(ctx, mounter, volID, configs, mountOptions, targetPath, shared); err != nil {
 			os.Remove(targetPath)
 			metrics.IncreaseCounterForVolumeMountFailures()
 			return err
@@ -34,7 +36,7 @@ func MountClient(mounter mounter.Mounter, volID string, configs map[string]strin
 	case common.WebdavClientType:
 		klog.V(5).Infof("mounting %q", irodsClientType)
 
-		if err := webdav.Mount(mounter, volID, configs, mountOptions, targetPath); err != nil {
+		if err := webdav.Mount(ctx, mounter, volID, configs, mountOptions, targetPath); err != nil {
 			os.Remove(targetPath)
 			metrics.IncreaseCounterForVolumeMountFailures()
 			return err
@@ -46,7 +48,7 @@ func MountClient(mounter mounter.Mounter, volID string, configs map[string]strin
 	case common.NfsClientType:
 		klog.V(5).Infof("mounting %q", irodsClientType)
 
-		if err := nfs.Mount(mounter, volID, configs, mountOptions, targetPath); err != nil {
+		if err := nfs.Mount(ctx, mounter, volID, configs, mountOptions, targetPath); err != nil {
 			os.Remove(targetPath)
 			metrics.IncreaseCounterForVolumeMountFailures()
 			return err
@@ -102,3 +104,55 @@ func UnmountClient(mounter mounter.Mounter, volID string, irodsClientType common
 		return status.Errorf(codes.Internal, "unknown driver type '%v'", irodsClientType)
 	}
 }
+
+// CheckClientPermissions checks that the user can access the volume as the access mode requires
+// only iRODS FUSE client checks permissions, other clients are checked by their servers at access
+func CheckClientPermissions(ctx context.Context, configs map[string]string, readOnly bool) error {
+	if common.GetClientType(configs) != common.IrodsFuseClientType {
+		return nil
+	}
+	return irods.CheckVolumePermissions(ctx, configs, readOnly)
+}
+
+// CleanupClient deletes leftover data of a fs client whose mount is already gone
+func CleanupClient(volID string, irodsClientType common.ClientType, configs map[string]string) error {
+	klog.V(5).Infof("cleaning up %q", irodsClientType)
+
+	switch irodsClientType {
+	case common.IrodsFuseClientType:
+		return irods.Cleanup(volID, configs)
+	case common.WebdavClientType:
+		return webdav.Cleanup(volID, configs)
+	case common.NfsClientType:
+		return nfs.Cleanup(volID, configs)
+	default:
+		return status.Errorf(codes.Internal, "unknown driver type '%v'", irodsClientType)
+	}
+}
+
+// WaitForSyncs waits for in-flight syncs of fs clients until ctx is done
+// returns paths of data that are not flushed to iRODS
+func WaitForSyncs(ctx context.Context) []string {
+	return irods.WaitForOverlayFSSyncs(ctx)
+}
+
+// SyncVolume syncs changes of the mounted volume to iRODS now, for fs clients caching changes locally
+// returns NotFound if the volume has no local changes to sync on this node
+func SyncVolume(ctx context.Context, volID string) error {
+	return irods.SyncMountedOverlayFS(ctx, volID)
+}
+
+// ListSyncableVolumes returns IDs of mounted volumes having local changes to sync
+func ListSyncableVolumes() []string {
+	return irods.ListMountedOverlayFSVolumes()
+}
+
+// PlanVolumeSync lists changes a sync of the volume would make to iRODS, without changing iRODS
+func PlanVolumeSync(ctx context.Context, volID string) (*irods.OverlayFSSyncPlan, error) {
+	return irods.PlanOverlayFSSync(ctx, volID)
+}
+
+// ApproveVolumeSync approves the next sync of the volume that requires approval, deleting up to destructive entries
+func ApproveVolumeSync(volID string, destructive int) error {
+	return irods.ApproveOverlayFSSync(volID, destructive)
+}
@@ -82,29 +82,34 @@ func GetConfigMountPathWhitelist(configs map[string]string) []string {
 	return []string{"/"}
 }
 
+// GetConfigStoragePath returns a storage path for driver internal data
+func GetConfigStoragePath(configs map[string]string) string {
+	return configs[common.NormalizeConfigKey("storage_path")]
+}
+
 // GetConfigDataRootPath returns a data root path
 func GetConfigDataRootPath(configs map[string]string, volID string) string {
 	irodsClientType := GetClientType(configs)
-	return filepath.Join(common.NormalizeConfigKey(configs["storage_path"]), string(irodsClientType), volID)
+	return filepath.Join(GetConfigStoragePath(configs), string(irodsClientType), volID)
 }
 
 // GetConfigOverlayFSLowerPath returns a lower path for overlayfs
 func GetConfigOverlayFSLowerPath(configs map[string]string, volID string) string {
 	irodsClientType := GetClientType(configs)
 	name := fmt.Sprintf("%s-overlayfs-lower", volID)
-	return filepath.Join(common.NormalizeConfigKey(configs["storage_path"]), string(irodsClientType), name)
+	return filepath.Join(GetConfigStoragePath(configs), string(irodsClientType), name)
 }
 
 // GetConfigOverlayFSUpperPath returns a upper path for overlayfs
 func GetConfigOverlayFSUpperPath(configs map[string]string, volID string) string {
 	irodsClientType := GetClientType(configs)
 	name := fmt.Sprintf("%s-overlayfs-upper", volID)
-	return filepath.Join(common.NormalizeConfigKey(configs["storage_path"]), string(irodsClientType), name)
+	return filepath.Join(GetConfigStoragePath(configs), string(irodsClientType), name)
 }
 
 // GetConfigOverlayFSWorkDirPath returns a work dir path for overlayfs
 func GetConfigOverlayFSWorkDirPath(configs map[string]string, volID string) string {
 	irodsClientType := GetClientType(configs)
 	name := fmt.Sprintf("%s-overlayfs-workdir", volID)
-	return filepath.Join(common.NormalizeConfigKey(configs["storage_path"]), string(irodsClientType), name)
+	return filepath.Join(GetConfigStoragePath(configs), string(irodsClientType), name)
 }
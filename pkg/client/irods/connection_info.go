@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"path/filepath"
 	"strconv"
+	"time"
 
 	irodsclient_fs "github.com/cyverse/go-irodsclient/fs"
 	client_common "github.com/cyverse/irods-csi-driver/pkg/client/common"
@@ -28,6 +29,22 @@ type IRODSFSConnectionInfo struct {
 	MountTimeout    int
 	OverlayFS       bool
 	OverlayFSDriver OverlayFSDriverType
+	// interval to sync the upper while mounted, 0 to disable
+	OverlayFSSyncInterval time.Duration
+	// size of changes in the upper in bytes to sync while mounted, 0 to disable
+	OverlayFSSyncThreshold int64
+	// policy to apply when iRODS data was changed by others while mounted
+	OverlayFSConflictPolicy OverlayFSConflictPolicy
+	// max number of deletes in a sync without approval, 0 to disable
+	OverlayFSSyncApprovalThreshold int
+	// store permission bits and modify times of synced entries as AVUs
+	OverlayFSPreserveAttrs bool
+	// mount overlay with redirect_dir to rename dirs without copying them up, renames are synced as moves
+	OverlayFSRedirectDir bool
+	// gitignore-style patterns of entries in the upper not to sync
+	OverlayFSSyncExclude []string
+	// size of files in bytes to upload in bundles extracted by iRODS instead of one by one, 0 to disable
+	OverlayFSSyncBulkThreshold int64
 }
 
 // NewIRODSFSConnectionInfo creates a new IRODSFSConnectionInfo with default
@@ -38,6 +55,7 @@ func NewIRODSFSConnectionInfo() IRODSFSConnectionInfo {
 	connInfo.MountTimeout = irodsfsDefaultMountTimeout
 	connInfo.OverlayFS = false
 	connInfo.OverlayFSDriver = OverlayDriverType
+	connInfo.OverlayFSConflictPolicy = OverlayFSConflictOverwrite
 
 	return connInfo
 }
@@ -116,6 +134,8 @@ func getConnectionInfoFromMap(params map[string]string, connInfo *IRODSFSConnect
 			connInfo.SSLVerifyServer = v
 		case common.NormalizeConfigKey("irods_user_password"), common.NormalizeConfigKey("user_password"), common.NormalizeConfigKey("password"):
 			connInfo.Password = v
+		case common.NormalizeConfigKey("irods_ticket"), common.NormalizeConfigKey("ticket"):
+			connInfo.Ticket = v
 		case common.NormalizeConfigKey("irods_ssl_server_name"), common.NormalizeConfigKey("ssl_server_name"):
 			connInfo.SSLServerName = v
 		case common.NormalizeConfigKey("path_mappings"), common.NormalizeConfigKey("path_mapping_json"):
@@ -209,6 +229,50 @@ func getConnectionInfoFromMap(params map[string]string, connInfo *IRODSFSConnect
 			connInfo.OverlayFS = ob
 		case common.NormalizeConfigKey("overlayfs_driver"):
 			connInfo.OverlayFSDriver = GetOverlayFSDriverType(v)
+		case common.NormalizeConfigKey("overlayfs_sync_interval"):
+			interval, err := time.ParseDuration(v)
+			if err != nil {
+				return status.Errorf(codes.InvalidArgument, "Argument %q must be a valid duration string - %v", k, err)
+			}
+			connInfo.OverlayFSSyncInterval = interval
+		case common.NormalizeConfigKey("overlayfs_sync_threshold"):
+			threshold, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				return status.Errorf(codes.InvalidArgument, "Argument %q must be a valid number - %v", k, err)
+			}
+			connInfo.OverlayFSSyncThreshold = threshold
+		case common.NormalizeConfigKey("overlayfs_conflict_policy"):
+			policy, err := GetOverlayFSConflictPolicy(v)
+			if err != nil {
+				return status.Errorf(codes.InvalidArgument, "Argument %q must be a valid conflict policy - %v", k, err)
+			}
+			connInfo.OverlayFSConflictPolicy = policy
+		case common.NormalizeConfigKey("overlayfs_sync_approval_threshold"):
+			threshold, err := strconv.Atoi(v)
+			if err != nil {
+				return status.Errorf(codes.InvalidArgument, "Argument %q must be a valid number - %v", k, err)
+			}
+			connInfo.OverlayFSSyncApprovalThreshold = threshold
+		case common.NormalizeConfigKey("overlayfs_preserve_attrs"):
+			pb, err := strconv.ParseBool(v)
+			if err != nil {
+				return status.Errorf(codes.InvalidArgument, "Argument %q must be a valid boolean string - %v", k, err)
+			}
+			connInfo.OverlayFSPreserveAttrs = pb
+		case common.NormalizeConfigKey("overlayfs_redirect_dir"):
+			rb, err := strconv.ParseBool(v)
+			if err != nil {
+				return status.Errorf(codes.InvalidArgument, "Argument %q must be a valid boolean string - %v", k, err)
+			}
+			connInfo.OverlayFSRedirectDir = rb
+		case common.NormalizeConfigKey("overlayfs_sync_exclude"):
+			connInfo.OverlayFSSyncExclude = parseOverlayFSSyncPatterns(v)
+		case common.NormalizeConfigKey("overlayfs_sync_bulk_threshold"):
+			threshold, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				return status.Errorf(codes.InvalidArgument, "Argument %q must be a valid number - %v", k, err)
+			}
+			connInfo.OverlayFSSyncBulkThreshold = threshold
 		case common.NormalizeConfigKey("mount_timeout"):
 			t, err := strconv.Atoi(v)
 			if err != nil {
@@ -260,8 +324,8 @@ func GetConnectionInfo(configs map[string]string) (*IRODSFSConnectionInfo, error
 		connInfo.SetAnonymousUser()
 	}
 
-	// password can be empty for anonymous access
-	if len(connInfo.Password) == 0 && !connInfo.IsAnonymousUser() {
+	// password can be empty for anonymous access or ticket access
+	if len(connInfo.Password) == 0 && !connInfo.IsAnonymousUser() && len(connInfo.Ticket) == 0 {
 		return nil, status.Error(codes.InvalidArgument, "Argument password must be given")
 	}
 
@@ -325,6 +389,26 @@ func GetConnectionInfo(configs map[string]string) (*IRODSFSConnectionInfo, error
 		return nil, status.Error(codes.InvalidArgument, "Argument read_ahead must not be a negative value")
 	}
 
+	if connInfo.OverlayFSSyncInterval < 0 {
+		return nil, status.Error(codes.InvalidArgument, "Argument overlayfs_sync_interval must not be a negative value")
+	}
+
+	if connInfo.OverlayFSSyncThreshold < 0 {
+		return nil, status.Error(codes.InvalidArgument, "Argument overlayfs_sync_threshold must not be a negative value")
+	}
+
+	if connInfo.OverlayFSSyncApprovalThreshold < 0 {
+		return nil, status.Error(codes.InvalidArgument, "Argument overlayfs_sync_approval_threshold must not be a negative value")
+	}
+
+	if connInfo.OverlayFSSyncBulkThreshold < 0 {
+		return nil, status.Error(codes.InvalidArgument, "Argument overlayfs_sync_bulk_threshold must not be a negative value")
+	}
+
+	if _, err := newOverlayFSSyncFilter(connInfo.OverlayFSSyncExclude); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "Argument overlayfs_sync_exclude must be valid patterns - %v", err)
+	}
+
 	if len(connInfo.PoolEndpoint) > 0 {
 		_, _, err := common.ParsePoolServerEndpoint(connInfo.PoolEndpoint)
 		if err != nil {
@@ -0,0 +1,85 @@
+package irods
+
+import (
+	"errors"
+	"fmt"
+	"net"
+
+	irodsclient_common "github.com/cyverse/go-irodsclient/irods/common"
+	irodsclient_types "github.com/cyverse/go-irodsclient/irods/types"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// IsTransientError checks if the error is caused by network failures or server outages, which may succeed on retry
+func IsTransientError(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	if irodsclient_types.IsConnectionError(err) || irodsclient_types.IsConnectionPoolFullError(err) {
+		return true
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+
+	if irodsclient_types.IsIRODSError(err) {
+		switch irodsclient_types.GetIRODSErrorCode(err) {
+		case irodsclient_common.SYS_SOCK_OPEN_ERR, irodsclient_common.SYS_SOCK_CONNECT_ERR, irodsclient_common.SYS_SOCK_READ_ERR, irodsclient_common.SYS_SOCK_READ_TIMEDOUT, irodsclient_common.SYS_SOCK_WRITE_ERR:
+			return true
+		}
+	}
+
+	return false
+}
+
+// GetErrorStatusCode classifies the iRODS error into grpc status code
+// auth failures are InvalidArgument or PermissionDenied, network failures are Unavailable
+func GetErrorStatusCode(err error) codes.Code {
+	if err == nil {
+		return codes.OK
+	}
+
+	if st, ok := status.FromError(err); ok {
+		return st.Code()
+	}
+
+	if IsTransientError(err) {
+		return codes.Unavailable
+	}
+
+	if irodsclient_types.IsAuthError(err) || irodsclient_types.IsConnectionConfigError(err) || irodsclient_types.IsUserNotFoundError(err) || irodsclient_types.IsTicketNotFoundError(err) {
+		return codes.InvalidArgument
+	}
+
+	if irodsclient_types.IsFileNotFoundError(err) {
+		return codes.NotFound
+	}
+
+	if irodsclient_types.IsIRODSError(err) {
+		switch irodsclient_types.GetIRODSErrorCode(err) {
+		case irodsclient_common.CAT_INVALID_AUTHENTICATION, irodsclient_common.CAT_INVALID_USER, irodsclient_common.CAT_INVALID_CLIENT_USER, irodsclient_common.CAT_PASSWORD_EXPIRED, irodsclient_common.PAM_AUTH_PASSWORD_FAILED:
+			return codes.InvalidArgument
+		case irodsclient_common.CAT_NO_ACCESS_PERMISSION:
+			return codes.PermissionDenied
+		}
+	}
+
+	return codes.Internal
+}
+
+// GetStatusError returns grpc status error classified from the iRODS error, grpc status errors are returned as is
+func GetStatusError(err error, format string, args ...interface{}) error {
+	if err == nil {
+		return nil
+	}
+
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+
+	return status.Errorf(GetErrorStatusCode(err), "%s - %v", fmt.Sprintf(format, args...), err)
+}
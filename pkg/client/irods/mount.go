@@ -1,11 +1,14 @@
 package irods
 
 import (
+	"context"
 	"fmt"
 	"os"
+	"slices"
 	"syscall"
 
 	client_common "github.com/cyverse/irods-csi-driver/pkg/client/common"
+	"github.com/cyverse/irods-csi-driver/pkg/common"
 	"github.com/cyverse/irods-csi-driver/pkg/mounter"
 	"golang.org/x/xerrors"
 	"google.golang.org/grpc/codes"
@@ -14,7 +17,9 @@ import (
 	"k8s.io/klog"
 )
 
-func Mount(mounter mounter.Mounter, volID string, configs map[string]string, mntOptions []string, targetPath string) error {
+// Mount mounts irodsfs, with overlayfs if configured
+// shared is true for staging mounts shared by pods via bind mounts, write access is checked when pods publish the volume writable
+func Mount(ctx context.Context, mounter mounter.Mounter, volID string, configs map[string]string, mntOptions []string, targetPath string, shared bool) error {
 	irodsConnectionInfo, err := GetConnectionInfo(configs)
 	if err != nil {
 		return err
@@ -22,9 +27,19 @@ func Mount(mounter mounter.Mounter, volID string, configs map[string]string, mnt
 
 	// test connection creation to check account info is correct
 	klog.V(5).Infof("Testing iRODS connection")
-	err = TestConnection(irodsConnectionInfo)
+	err = TestConnection(ctx, irodsConnectionInfo)
 	if err != nil {
-		return status.Errorf(codes.InvalidArgument, "Could not create iRODS Conenction with given access parameters - %v", err)
+		if ctxErr := common.GetContextStatusError(ctx, "Testing iRODS Connection was interrupted"); ctxErr != nil {
+			return ctxErr
+		}
+		return GetStatusError(err, "Could not create iRODS Conenction with given access parameters")
+	}
+
+	// check the user can access mapped paths as the access mode requires, to fail before mounting
+	readOnly := irodsConnectionInfo.Readonly || slices.Contains(mntOptions, "ro") || shared
+	err = checkPermissions(ctx, irodsConnectionInfo, readOnly)
+	if err != nil {
+		return err
 	}
 
 	fsType := "irodsfs"
@@ -60,10 +75,14 @@ func Mount(mounter mounter.Mounter, volID string, configs map[string]string, mnt
 		mountOptions = append(mountOptions, "config=-")    // read configuration yaml via STDIN
 
 		klog.V(5).Infof("Mounting %q (%q) at %q with options %v", source, fsType, targetPath, mountOptions)
-		if err := mounter.MountSensitive2(source, source, targetPath, fsType, mountOptions, mountSensitiveOptions, stdinArgs); err != nil {
+		if err := mounter.MountSensitive2(ctx, source, source, targetPath, fsType, mountOptions, mountSensitiveOptions, stdinArgs); err != nil {
 			// umount the volume to ensure no leftovers
 			mounter.UnmountLazy(targetPath, true)
+			deleteIrodsFuseLiteData(dataRootPath)
 
+			if ctxErr := common.GetContextStatusError(ctx, "Mounting %q (%q) at %q was interrupted", source, fsType, targetPath); ctxErr != nil {
+				return ctxErr
+			}
 			return status.Errorf(codes.Internal, "Failed to mount %q (%q) at %q: %v", source, fsType, targetPath, err)
 		}
 		return nil
@@ -77,6 +96,16 @@ func Mount(mounter mounter.Mounter, volID string, configs map[string]string, mnt
 		irodsConnectionInfo.OverlayFSDriver = FuseOverlayFSDriverType
 	}
 
+	// record the driver used, configs are saved with the volume and the sync, formats of whiteouts in the upper depend on it
+	configs[common.NormalizeConfigKey("overlayfs_driver")] = string(irodsConnectionInfo.OverlayFSDriver)
+
+	// the upper of the previous mount is synced with progress recorded by entry, writes to it would be lost or mixed
+	overlayFSUpperPath := client_common.GetConfigOverlayFSUpperPath(configs, volID)
+	if overlayFSSyncs.isUnflushed(overlayFSUpperPath) {
+		deleteIrodsFuseLiteData(dataRootPath)
+		return status.Errorf(codes.Unavailable, "Failed to mount overlayfs for volume %q, upper %q of the previous mount is still syncing to iRODS or failed to sync", volID, overlayFSUpperPath)
+	}
+
 	overlayFSLowerPath := client_common.GetConfigOverlayFSLowerPath(configs, volID)
 	err = makeOverlayFSPath(overlayFSLowerPath)
 	if err != nil {
@@ -89,29 +118,36 @@ func Mount(mounter mounter.Mounter, volID string, configs map[string]string, mnt
 	mountOptions = append(mountOptions, "config=-") // read configuration yaml via STDIN
 
 	klog.V(5).Infof("Mounting %q (%q) at %q with options %v", source, fsType, overlayFSLowerPath, mountOptions)
-	if err := mounter.MountSensitive2(source, source, overlayFSLowerPath, fsType, mountOptions, mountSensitiveOptions, stdinArgs); err != nil {
+	if err := mounter.MountSensitive2(ctx, source, source, overlayFSLowerPath, fsType, mountOptions, mountSensitiveOptions, stdinArgs); err != nil {
 		// umount the volume to ensure no leftovers
 		mounter.UnmountLazy(overlayFSLowerPath, true)
+		deleteIrodsFuseLiteData(dataRootPath)
 
+		if ctxErr := common.GetContextStatusError(ctx, "Mounting %q (%q) at %q was interrupted", source, fsType, overlayFSLowerPath); ctxErr != nil {
+			return ctxErr
+		}
 		return status.Errorf(codes.Internal, "Failed to mount %q (%q) at %q: %v", source, fsType, overlayFSLowerPath, err)
 	}
 
 	if irodsConnectionInfo.OverlayFSDriver == FuseOverlayFSDriverType {
-		err = mountFuseOverlayFS(mounter, irodsConnectionInfo, volID, configs, targetPath)
-		if err != nil {
-			return err
-		}
+		err = mountFuseOverlayFS(ctx, mounter, irodsConnectionInfo, volID, configs, targetPath)
 	} else {
-		err = mountOverlay(mounter, irodsConnectionInfo, volID, configs, targetPath)
-		if err != nil {
-			return err
-		}
+		err = mountOverlay(ctx, mounter, irodsConnectionInfo, volID, configs, targetPath)
 	}
 
+	if err != nil {
+		// tear down the lower layer to ensure no leftovers
+		mounter.UnmountLazy(overlayFSLowerPath, true)
+		deleteIrodsFuseLiteData(dataRootPath)
+		deleteOverlayFSData(overlayFSLowerPath)
+		return err
+	}
+
+	startOverlayFSMountedSyncer(volID, irodsConnectionInfo, client_common.GetConfigOverlayFSUpperPath(configs, volID))
 	return nil
 }
 
-func mountOverlay(mounter mounter.Mounter, irodsConnectionInfo *IRODSFSConnectionInfo, volID string, configs map[string]string, mountPath string) error {
+func mountOverlay(ctx context.Context, mounter mounter.Mounter, irodsConnectionInfo *IRODSFSConnectionInfo, volID string, configs map[string]string, mountPath string) error {
 	lowerPath := client_common.GetConfigOverlayFSLowerPath(configs, volID)
 	upperPath := client_common.GetConfigOverlayFSUpperPath(configs, volID)
 	workdirPath := client_common.GetConfigOverlayFSWorkDirPath(configs, volID)
@@ -133,20 +169,28 @@ func mountOverlay(mounter mounter.Mounter, irodsConnectionInfo *IRODSFSConnectio
 	mountOptions = append(mountOptions, fmt.Sprintf("workdir=%s", workdirPath))
 	mountOptions = append(mountOptions, "xino=off")
 
+	if irodsConnectionInfo.OverlayFSRedirectDir {
+		// renamed dirs are synced as moves
+		mountOptions = append(mountOptions, "redirect_dir=on")
+	}
+
 	mountSensitiveOptions := []string{}
 
 	klog.V(5).Infof("Mounting overlay at %q with options %v", mountPath, mountOptions)
-	if err := mounter.MountSensitive("overlay", mountPath, "overlay", mountOptions, mountSensitiveOptions); err != nil {
+	if err := mounter.MountSensitive2(ctx, "overlay", "overlay", mountPath, "overlay", mountOptions, mountSensitiveOptions, nil); err != nil {
 		// umount the volume to ensure no leftovers
 		mounter.Unmount(mountPath)
 
+		if ctxErr := common.GetContextStatusError(ctx, "Mounting overlay at %q was interrupted", mountPath); ctxErr != nil {
+			return ctxErr
+		}
 		return status.Errorf(codes.Internal, "Failed to mount overlay at %q: %v", mountPath, err)
 	}
 
 	return nil
 }
 
-func mountFuseOverlayFS(mounter mounter.Mounter, irodsConnectionInfo *IRODSFSConnectionInfo, volID string, configs map[string]string, mountPath string) error {
+func mountFuseOverlayFS(ctx context.Context, mounter mounter.Mounter, irodsConnectionInfo *IRODSFSConnectionInfo, volID string, configs map[string]string, mountPath string) error {
 	lowerPath := client_common.GetConfigOverlayFSLowerPath(configs, volID)
 	upperPath := client_common.GetConfigOverlayFSUpperPath(configs, volID)
 	workdirPath := client_common.GetConfigOverlayFSWorkDirPath(configs, volID)
@@ -179,10 +223,13 @@ func mountFuseOverlayFS(mounter mounter.Mounter, irodsConnectionInfo *IRODSFSCon
 	stdinArgs := []string{}
 
 	klog.V(5).Infof("Mounting fuse-overlayfs at %q with options %v", mountPath, mountOptions)
-	if err := mounter.MountSensitive2("fuseoverlayfs", "fuseoverlayfs", mountPath, "fuseoverlayfs", mountOptions, mountSensitiveOptions, stdinArgs); err != nil {
+	if err := mounter.MountSensitive2(ctx, "fuseoverlayfs", "fuseoverlayfs", mountPath, "fuseoverlayfs", mountOptions, mountSensitiveOptions, stdinArgs); err != nil {
 		// umount the volume to ensure no leftovers
 		mounter.UnmountLazy(mountPath, true)
 
+		if ctxErr := common.GetContextStatusError(ctx, "Mounting fuse-overlayfs at %q was interrupted", mountPath); ctxErr != nil {
+			return ctxErr
+		}
 		return status.Errorf(codes.Internal, "Failed to mount fuse-overlayfs at %q: %v", mountPath, err)
 	}
 
@@ -195,59 +242,140 @@ func Unmount(mounter mounter.Mounter, volID string, configs map[string]string, t
 		return err
 	}
 
-	dataRootPath := client_common.GetConfigDataRootPath(configs, volID)
-
 	if !irodsConnectionInfo.OverlayFS {
 		// unmount irodsfs
 		klog.V(5).Infof("Unmounting irodsfs at %q", targetPath)
 
-		err = mounter.UnmountLazy(targetPath, true)
+		err = mounter.UnmountWithAbort(targetPath)
 		if err != nil {
 			return status.Errorf(codes.Internal, "Failed to unmount %q: %v", targetPath, err)
 		}
 
-		err = deleteIrodsFuseLiteData(dataRootPath)
-		if err != nil {
-			klog.Errorf("Error deleting iRODS FUSE Lite data at %q, %s, ignoring", dataRootPath, err)
-		}
-		return nil
+		return Cleanup(volID, configs)
 	}
 
+	// stop syncing while mounted, entries synced are skipped in the final sync
+	syncState := stopOverlayFSMountedSyncer(volID)
+
 	// unmount irodsfs and overlayfs
-	err = unmountOverlayFS(mounter, irodsConnectionInfo, volID, configs, targetPath)
+	err = unmountOverlayFS(mounter, targetPath)
 	if err != nil {
 		return err
 	}
 
 	lowerPath := client_common.GetConfigOverlayFSLowerPath(configs, volID)
-	upperPath := client_common.GetConfigOverlayFSUpperPath(configs, volID)
 
 	klog.V(5).Infof("Unmounting irodsfs at %q", lowerPath)
 
-	err = mounter.UnmountLazy(lowerPath, true)
+	err = mounter.UnmountWithAbort(lowerPath)
 	if err != nil {
 		return status.Errorf(codes.Internal, "Failed to unmount %q: %v", lowerPath, err)
 	}
 
+	return cleanup(volID, configs, syncState)
+}
+
+// Cleanup deletes leftover irodsfs and overlayfs data of the volume, mounts must be unmounted before
+func Cleanup(volID string, configs map[string]string) error {
+	syncState := stopOverlayFSMountedSyncer(volID)
+	return cleanup(volID, configs, syncState)
+}
+
+// cleanup deletes leftover data, and syncs the upper skipping entries in syncState synced while mounted
+func cleanup(volID string, configs map[string]string, syncState *overlayFSSyncState) error {
+	irodsConnectionInfo, err := GetConnectionInfo(configs)
+	if err != nil {
+		return err
+	}
+
+	dataRootPath := client_common.GetConfigDataRootPath(configs, volID)
+
 	err = deleteIrodsFuseLiteData(dataRootPath)
 	if err != nil {
 		klog.Errorf("Error deleting iRODS FUSE Lite data at %q, %s, ignoring", dataRootPath, err)
 	}
 
+	if !irodsConnectionInfo.OverlayFS {
+		return nil
+	}
+
+	lowerPath := client_common.GetConfigOverlayFSLowerPath(configs, volID)
+	upperPath := client_common.GetConfigOverlayFSUpperPath(configs, volID)
+	workdirPath := client_common.GetConfigOverlayFSWorkDirPath(configs, volID)
+
+	// never delete the lower if irodsfs is still mounted, it would delete data in iRODS
+	lowerMountInfo, err := mounter.FindMountInfo(lowerPath)
+	if err != nil {
+		return status.Errorf(codes.Internal, "Failed to check if %q is mounted: %v", lowerPath, err)
+	}
+
+	if lowerMountInfo != nil {
+		return status.Errorf(codes.Internal, "Failed to delete overlayfs lower data at %q, still mounted", lowerPath)
+	}
+
 	err = deleteOverlayFSData(lowerPath)
 	if err != nil {
 		klog.Errorf("Error deleting overlayfs lower data at %q, %s, ignoring", lowerPath, err)
 	}
 
+	err = deleteOverlayFSData(workdirPath)
+	if err != nil {
+		klog.Errorf("Error deleting overlayfs workdir data at %q, %s, ignoring", workdirPath, err)
+	}
+
+	if _, err := os.Stat(upperPath); os.IsNotExist(err) {
+		// nothing to sync
+		return nil
+	}
+
 	// sync
 	// this takes some time if there were a lot of file changes
-	// so we will do this asynchronously
+	// so we will do this asynchronously, and record it in the journal to resume after restarts
+	if journal := getOverlayFSSyncJournal(); journal != nil {
+		record := &OverlayFSSyncRecord{
+			VolumeID:     volID,
+			UpperPath:    upperPath,
+			ClientConfig: configs,
+		}
+
+		if syncState != nil && syncState.baseline != nil {
+			// to detect conflicts on resume
+			record.Baseline = syncState.baseline.Snapshot()
+		}
+
+		err = journal.Start(record)
+		if err != nil {
+			klog.Errorf("Error recording overlayfs sync of %q, %s, sync will not be resumed after restarts", upperPath, err)
+		}
+	}
+
+	startOverlayFSSync(volID, configs, irodsConnectionInfo, upperPath, syncState)
+	return nil
+}
+
+// startOverlayFSSync syncs the upper asynchronously, and tracks it so drain can wait for it
+// the upper is deleted only after all entries are synced, otherwise kept to resume later
+// syncState can be nil, if not nil, entries synced while mounted and unchanged are skipped
+func startOverlayFSSync(volID string, configs map[string]string, irodsConnectionInfo *IRODSFSConnectionInfo, upperPath string, syncState *overlayFSSyncState) {
+	overlayFSSyncs.start(volID, upperPath)
 	go func() {
 		klog.V(5).Infof("Synching overlayfs upper data at %q", upperPath)
 
-		err = syncOverlayFS(volID, irodsConnectionInfo, upperPath)
+		journal := getOverlayFSSyncJournal()
+
+		err := syncOverlayFS(volID, irodsConnectionInfo, upperPath, journal, syncState)
 		if err != nil {
-			klog.Errorf("Error syncing overlayfs upper data at %q, %s, ignoring", upperPath, err)
+			// keep upper to not lose unsynced data
+			klog.Errorf("Error syncing overlayfs upper data at %q, %s, keeping upper", upperPath, err)
+			if journal != nil {
+				if suspendErr := journal.Suspend(volID); suspendErr != nil {
+					klog.Errorf("Error saving overlayfs sync progress of %q, %s", upperPath, suspendErr)
+				}
+			}
+
+			overlayFSSyncs.done(upperPath, err)
+			notifyOverlayFSSyncFailure(volID, configs, upperPath, err)
+			return
 		}
 
 		klog.V(5).Infof("Done synching overlayfs upper data at %q", upperPath)
@@ -255,30 +383,27 @@ func Unmount(mounter mounter.Mounter, volID string, configs map[string]string, t
 		// delete upper
 		err = deleteOverlayFSData(upperPath)
 		if err != nil {
+			// the record is kept, the upper will be synced again on resume
 			klog.Errorf("Error deleting overlayfs upper data at %q, %s, ignoring", upperPath, err)
+		} else if journal != nil {
+			if finishErr := journal.Finish(volID); finishErr != nil {
+				klog.Errorf("Error deleting overlayfs sync record of %q, %s", upperPath, finishErr)
+			}
 		}
-	}()
 
-	return nil
+		overlayFSSyncs.done(upperPath, nil)
+	}()
 }
 
-func unmountOverlayFS(mounter mounter.Mounter, irodsConnectionInfo *IRODSFSConnectionInfo, volID string, configs map[string]string, mountPath string) error {
-	workdirPath := client_common.GetConfigOverlayFSWorkDirPath(configs, volID)
-
+func unmountOverlayFS(mounter mounter.Mounter, mountPath string) error {
 	// overlayfs
 	klog.V(5).Infof("Unmounting overlayfs at %q", mountPath)
 
-	err := mounter.UnmountLazy(mountPath, true)
+	err := mounter.UnmountWithAbort(mountPath)
 	if err != nil {
 		return status.Errorf(codes.Internal, "Failed to unmount %q: %v", mountPath, err)
 	}
 
-	// delete workdir
-	err = deleteOverlayFSData(workdirPath)
-	if err != nil {
-		klog.Errorf("Error deleting overlayfs workdir data at %q, %s, ignoring", workdirPath, err)
-	}
-
 	return nil
 }
 
@@ -331,14 +456,18 @@ func deleteIrodsFuseLiteData(dataRootPath string) error {
 	return nil
 }
 
-func syncOverlayFS(volumeID string, connectionInfo *IRODSFSConnectionInfo, upperPath string) error {
-	syncher, err := NewOverlayFSSyncher(volumeID, connectionInfo, upperPath)
+func syncOverlayFS(volumeID string, connectionInfo *IRODSFSConnectionInfo, upperPath string, journal OverlayFSSyncJournal, syncState *overlayFSSyncState) error {
+	syncher, err := NewOverlayFSSyncher(volumeID, connectionInfo, upperPath, journal)
 	if err != nil {
 		return xerrors.Errorf("failed to create a overlayfs syncher: %w", err)
 	}
 
 	defer syncher.Release()
 
+	if syncState != nil {
+		syncher.SetSyncState(syncState)
+	}
+
 	err = syncher.Sync()
 	if err != nil {
 		return xerrors.Errorf("failed to sync overlayfs upper %q: %w", upperPath, err)
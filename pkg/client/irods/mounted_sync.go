@@ -0,0 +1,371 @@
+package irods
+
+import (
+	"context"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+
+	"golang.org/x/xerrors"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/klog"
+)
+
+const (
+	// pods touch this file at the root of the volume to sync changes to iRODS while mounted
+	overlayFSSyncControlFileName string = ".irods-csi-sync"
+
+	// interval to check the control file and the sync interval
+	overlayFSMountedSyncCheckInterval time.Duration = 10 * time.Second
+	// interval to check the threshold, walking the upper is expensive for large uppers
+	overlayFSMountedSyncThresholdCheckInterval time.Duration = 1 * time.Minute
+)
+
+// overlayFSSyncedEntry is the state of an upper entry when it was synced
+// dirs are compared by inode, ctime and opaque state, as a dir removed and made again has the same name
+type overlayFSSyncedEntry struct {
+	size    int64
+	modTime time.Time
+	inode   uint64
+	ctime   time.Time
+	opaque  bool
+}
+
+func newOverlayFSSyncedEntry(info fs.FileInfo, opaque bool) overlayFSSyncedEntry {
+	entry := overlayFSSyncedEntry{
+		size:    info.Size(),
+		modTime: info.ModTime(),
+		opaque:  opaque,
+	}
+
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok && stat != nil {
+		entry.inode = stat.Ino
+		entry.ctime = time.Unix(int64(stat.Ctim.Sec), int64(stat.Ctim.Nsec))
+	}
+	return entry
+}
+
+// overlayFSSyncState tracks upper entries synced while mounted, so later syncs skip unchanged entries
+// dirs are synced again if they are made again or become opaque, opaque dirs synced again keep entries in the upper
+// baseline has states of iRODS entries at mount time to detect conflicts, nil if conflicts are not checked
+// conflictDirs has dirs stored with a conflict suffix, entries added to them later follow them
+type overlayFSSyncState struct {
+	entries      map[string]overlayFSSyncedEntry
+	baseline     *OverlayFSSyncBaseline
+	conflictDirs *overlayFSConflictDirs
+	mutex        sync.Mutex
+}
+
+func newOverlayFSSyncState(baseline *OverlayFSSyncBaseline) *overlayFSSyncState {
+	return &overlayFSSyncState{
+		entries:      map[string]overlayFSSyncedEntry{},
+		baseline:     baseline,
+		conflictDirs: newOverlayFSConflictDirs(),
+	}
+}
+
+// isSynced checks if the entry is synced and unchanged since, opaque is the opaque state of the dir
+func (state *overlayFSSyncState) isSynced(relPath string, info fs.FileInfo, opaque bool) bool {
+	state.mutex.Lock()
+	defer state.mutex.Unlock()
+
+	entry, ok := state.entries[relPath]
+	if !ok {
+		return false
+	}
+
+	current := newOverlayFSSyncedEntry(info, opaque)
+	if info.IsDir() {
+		return entry.inode == current.inode && entry.ctime.Equal(current.ctime) && entry.opaque == current.opaque
+	}
+	return entry.size == current.size && entry.modTime.Equal(current.modTime)
+}
+
+// markSynced records the state of the entry synced, opaque is the opaque state of the dir
+func (state *overlayFSSyncState) markSynced(relPath string, info fs.FileInfo, opaque bool) {
+	state.mutex.Lock()
+	defer state.mutex.Unlock()
+
+	state.entries[relPath] = newOverlayFSSyncedEntry(info, opaque)
+}
+
+// getUnsyncedBytes returns size of files in the upper changed after they were synced, excluded files are not counted
+func (state *overlayFSSyncState) getUnsyncedBytes(upperPath string, filter *overlayFSSyncFilter) (int64, error) {
+	unsynced := int64(0)
+	err := filepath.WalkDir(upperPath, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		relPath, err := filepath.Rel(upperPath, path)
+		if err != nil {
+			return err
+		}
+
+		if d.IsDir() {
+			if path != upperPath && filter.isExcluded(relPath, true) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if filter.isExcluded(relPath, false) {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			if os.IsNotExist(err) {
+				// deleted while walking
+				return nil
+			}
+			return err
+		}
+
+		if !state.isSynced(relPath, info, false) {
+			unsynced += info.Size()
+		}
+		return nil
+	})
+
+	return unsynced, err
+}
+
+// overlayFSMountedSyncer syncs the upper to iRODS while the volume is mounted
+// syncs are triggered by interval, size of changes, the control file, or admin requests
+type overlayFSMountedSyncer struct {
+	volumeID            string
+	irodsConnectionInfo *IRODSFSConnectionInfo
+	upperPath           string
+	state               *overlayFSSyncState
+	// excluded files do not count toward the threshold
+	filter *overlayFSSyncFilter
+
+	lastSyncTime           time.Time
+	lastControlFileModTime time.Time
+	lastThresholdCheckTime time.Time
+
+	// serializes syncs
+	syncMutex sync.Mutex
+	stopped   bool
+	stopChan  chan struct{}
+	doneChan  chan struct{}
+}
+
+var (
+	overlayFSMountedSyncers      = map[string]*overlayFSMountedSyncer{}
+	overlayFSMountedSyncersMutex sync.Mutex
+)
+
+// startOverlayFSMountedSyncer starts syncing the upper of the mounted volume in background
+func startOverlayFSMountedSyncer(volID string, irodsConnectionInfo *IRODSFSConnectionInfo, upperPath string) {
+	var baseline *OverlayFSSyncBaseline
+	if irodsConnectionInfo.OverlayFSConflictPolicy != OverlayFSConflictOverwrite {
+		// overwrite does not need to know changes by others
+		baseline = NewOverlayFSSyncBaseline(time.Now())
+	}
+
+	// patterns are validated with the connection info
+	filter, err := newOverlayFSSyncFilter(irodsConnectionInfo.OverlayFSSyncExclude)
+	if err != nil {
+		klog.Errorf("Failed to parse sync patterns of volume %q, %s", volID, err)
+	}
+
+	syncer := &overlayFSMountedSyncer{
+		volumeID:               volID,
+		irodsConnectionInfo:    irodsConnectionInfo,
+		upperPath:              upperPath,
+		state:                  newOverlayFSSyncState(baseline),
+		filter:                 filter,
+		lastSyncTime:           time.Now(),
+		lastThresholdCheckTime: time.Now(),
+		stopChan:               make(chan struct{}),
+		doneChan:               make(chan struct{}),
+	}
+
+	// a control file left from the previous mount must not trigger a sync
+	if info, err := os.Stat(syncer.getControlFilePath()); err == nil {
+		syncer.lastControlFileModTime = info.ModTime()
+	}
+
+	overlayFSMountedSyncersMutex.Lock()
+	if previous, ok := overlayFSMountedSyncers[volID]; ok {
+		previous.stop()
+	}
+	overlayFSMountedSyncers[volID] = syncer
+	overlayFSMountedSyncersMutex.Unlock()
+
+	go syncer.run()
+}
+
+// stopOverlayFSMountedSyncer stops syncing the upper of the volume, waiting for the sync in progress
+// returns the state of entries synced while mounted, nil if not syncing
+func stopOverlayFSMountedSyncer(volID string) *overlayFSSyncState {
+	overlayFSMountedSyncersMutex.Lock()
+	syncer, ok := overlayFSMountedSyncers[volID]
+	delete(overlayFSMountedSyncers, volID)
+	overlayFSMountedSyncersMutex.Unlock()
+
+	if !ok {
+		return nil
+	}
+
+	syncer.stop()
+	return syncer.state
+}
+
+func getOverlayFSMountedSyncer(volID string) *overlayFSMountedSyncer {
+	overlayFSMountedSyncersMutex.Lock()
+	defer overlayFSMountedSyncersMutex.Unlock()
+
+	return overlayFSMountedSyncers[volID]
+}
+
+// ListMountedOverlayFSVolumes returns IDs of mounted volumes using overlayfs
+func ListMountedOverlayFSVolumes() []string {
+	overlayFSMountedSyncersMutex.Lock()
+	defer overlayFSMountedSyncersMutex.Unlock()
+
+	volIDs := make([]string, 0, len(overlayFSMountedSyncers))
+	for volID := range overlayFSMountedSyncers {
+		volIDs = append(volIDs, volID)
+	}
+	return volIDs
+}
+
+// SyncMountedOverlayFS syncs changes in the upper of the mounted volume to iRODS now
+// returns NotFound if the volume is not mounted with overlayfs on this node
+func SyncMountedOverlayFS(ctx context.Context, volID string) error {
+	syncer := getOverlayFSMountedSyncer(volID)
+	if syncer == nil {
+		return status.Errorf(codes.NotFound, "Volume %q is not mounted with overlayfs on this node", volID)
+	}
+
+	// the sync keeps running if ctx is done, the state is updated anyway
+	return runWithContext(ctx, func() error {
+		return syncer.sync("requested")
+	})
+}
+
+func (syncer *overlayFSMountedSyncer) getControlFilePath() string {
+	return filepath.Join(syncer.upperPath, overlayFSSyncControlFileName)
+}
+
+func (syncer *overlayFSMountedSyncer) stop() {
+	select {
+	case <-syncer.stopChan:
+		// already stopped
+	default:
+		close(syncer.stopChan)
+	}
+
+	<-syncer.doneChan
+
+	// wait for a sync requested
+	syncer.syncMutex.Lock()
+	defer syncer.syncMutex.Unlock()
+
+	syncer.stopped = true
+}
+
+func (syncer *overlayFSMountedSyncer) run() {
+	defer close(syncer.doneChan)
+
+	if syncer.state.baseline != nil {
+		klog.V(4).Infof("Recording iRODS entries of volume %q at mount time to detect conflicts", syncer.volumeID)
+		err := syncer.state.baseline.capture(syncer.irodsConnectionInfo, syncer.stopChan)
+		if err != nil {
+			// entries not recorded are checked against the mount time
+			klog.Errorf("Failed to record iRODS entries of volume %q at mount time, %s", syncer.volumeID, err)
+		}
+	}
+
+	ticker := time.NewTicker(overlayFSMountedSyncCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-syncer.stopChan:
+			return
+		case <-ticker.C:
+		}
+
+		reason := syncer.getSyncReason()
+		if len(reason) == 0 {
+			continue
+		}
+
+		err := syncer.sync(reason)
+		if err != nil {
+			// entries failed are synced again in the next sync
+			klog.Errorf("Failed to sync overlayfs upper %q of volume %q while mounted, %s", syncer.upperPath, syncer.volumeID, err)
+		}
+	}
+}
+
+// getSyncReason returns why the upper needs to be synced, empty if not needed
+func (syncer *overlayFSMountedSyncer) getSyncReason() string {
+	syncer.syncMutex.Lock()
+	defer syncer.syncMutex.Unlock()
+
+	if info, err := os.Stat(syncer.getControlFilePath()); err == nil {
+		if info.ModTime().After(syncer.lastControlFileModTime) {
+			syncer.lastControlFileModTime = info.ModTime()
+			return "control file touched"
+		}
+	}
+
+	if syncer.irodsConnectionInfo.OverlayFSSyncInterval > 0 && time.Since(syncer.lastSyncTime) >= syncer.irodsConnectionInfo.OverlayFSSyncInterval {
+		return "interval passed"
+	}
+
+	if syncer.irodsConnectionInfo.OverlayFSSyncThreshold > 0 && time.Since(syncer.lastThresholdCheckTime) >= overlayFSMountedSyncThresholdCheckInterval {
+		syncer.lastThresholdCheckTime = time.Now()
+
+		unsynced, err := syncer.state.getUnsyncedBytes(syncer.upperPath, syncer.filter)
+		if err != nil {
+			klog.Errorf("Failed to check changes in overlayfs upper %q of volume %q, %s", syncer.upperPath, syncer.volumeID, err)
+			return ""
+		}
+
+		if unsynced >= syncer.irodsConnectionInfo.OverlayFSSyncThreshold {
+			return "threshold exceeded"
+		}
+	}
+
+	return ""
+}
+
+func (syncer *overlayFSMountedSyncer) sync(reason string) error {
+	syncer.syncMutex.Lock()
+	defer syncer.syncMutex.Unlock()
+
+	if syncer.stopped {
+		return status.Errorf(codes.Unavailable, "Volume %q is being unmounted, changes are synced after unmount", syncer.volumeID)
+	}
+
+	klog.V(4).Infof("Syncing overlayfs upper %q of volume %q while mounted, %s", syncer.upperPath, syncer.volumeID, reason)
+
+	syncher, err := NewOverlayFSSyncher(syncer.volumeID, syncer.irodsConnectionInfo, syncer.upperPath, nil)
+	if err != nil {
+		return xerrors.Errorf("failed to create a overlayfs syncher: %w", err)
+	}
+	defer syncher.Release()
+
+	syncher.SetSyncState(syncer.state)
+	syncher.SetMounted()
+
+	syncer.lastSyncTime = time.Now()
+
+	err = syncher.Sync()
+	if err != nil {
+		return xerrors.Errorf("failed to sync overlayfs upper %q: %w", syncer.upperPath, err)
+	}
+
+	klog.V(4).Infof("Synced overlayfs upper %q of volume %q while mounted", syncer.upperPath, syncer.volumeID)
+	return nil
+}
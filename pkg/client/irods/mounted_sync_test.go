@@ -0,0 +1,89 @@
+package irods
+
+import (
+	"io/fs"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// testFileInfo is a fs.FileInfo with inode and ctime
+type testFileInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+	isDir   bool
+	stat    *syscall.Stat_t
+}
+
+func (info *testFileInfo) Name() string       { return info.name }
+func (info *testFileInfo) Size() int64        { return info.size }
+func (info *testFileInfo) ModTime() time.Time { return info.modTime }
+func (info *testFileInfo) IsDir() bool        { return info.isDir }
+func (info *testFileInfo) Sys() interface{}   { return info.stat }
+
+func (info *testFileInfo) Mode() fs.FileMode {
+	if info.isDir {
+		return fs.ModeDir | 0755
+	}
+	return 0644
+}
+
+func newTestDirInfo(inode uint64, ctime int64) *testFileInfo {
+	return &testFileInfo{
+		name:  "out",
+		isDir: true,
+		stat: &syscall.Stat_t{
+			Ino:  inode,
+			Ctim: syscall.Timespec{Sec: ctime},
+		},
+	}
+}
+
+func TestOverlayFSSyncStateDirs(t *testing.T) {
+	state := newOverlayFSSyncState(nil)
+	state.markSynced("out", newTestDirInfo(10, 100), false)
+
+	testCases := []struct {
+		name   string
+		info   fs.FileInfo
+		opaque bool
+		synced bool
+	}{
+		{name: "unchanged", info: newTestDirInfo(10, 100), synced: true},
+		{name: "made again", info: newTestDirInfo(11, 100)},
+		{name: "made again with the same inode", info: newTestDirInfo(10, 200)},
+		{name: "became opaque", info: newTestDirInfo(10, 100), opaque: true},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			synced := state.isSynced("out", testCase.info, testCase.opaque)
+			if synced != testCase.synced {
+				t.Errorf("expected synced %t, got %t", testCase.synced, synced)
+			}
+		})
+	}
+
+	if state.isSynced("other", newTestDirInfo(10, 100), false) {
+		t.Errorf("dirs not synced must not be synced")
+	}
+}
+
+func TestOverlayFSSyncStateFiles(t *testing.T) {
+	modTime := time.Now()
+	state := newOverlayFSSyncState(nil)
+	state.markSynced("out/a", &testFileInfo{name: "a", size: 10, modTime: modTime}, false)
+
+	if !state.isSynced("out/a", &testFileInfo{name: "a", size: 10, modTime: modTime}, false) {
+		t.Errorf("unchanged file must be synced")
+	}
+
+	if state.isSynced("out/a", &testFileInfo{name: "a", size: 11, modTime: modTime}, false) {
+		t.Errorf("file with a different size must not be synced")
+	}
+
+	if state.isSynced("out/a", &testFileInfo{name: "a", size: 10, modTime: modTime.Add(time.Second)}, false) {
+		t.Errorf("file with a different mtime must not be synced")
+	}
+}
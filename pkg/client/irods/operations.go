@@ -1,6 +1,8 @@
 package irods
 
 import (
+	"context"
+	"fmt"
 	"time"
 
 	irodsclient_fs "github.com/cyverse/go-irodsclient/fs"
@@ -11,6 +13,8 @@ import (
 
 const (
 	applicationName string = "irods-csi-driver"
+
+	connectionTimeout time.Duration = 60 * time.Second
 )
 
 // GetIRODSAccount creates a new account
@@ -24,47 +28,117 @@ func GetIRODSFilesystemConfig() *irodsclient_fs.FileSystemConfig {
 }
 
 // GetIRODSFilesystem creates a new filesystem
-func GetIRODSFilesystem(conn *IRODSFSConnectionInfo) (*irodsclient_fs.FileSystem, error) {
+// connection and request timeouts are bounded by the context deadline
+func GetIRODSFilesystem(ctx context.Context, conn *IRODSFSConnectionInfo) (*irodsclient_fs.FileSystem, error) {
 	account := GetIRODSAccount(conn)
-	return irodsclient_fs.NewFileSystemWithDefault(account, applicationName)
+	config := GetIRODSFilesystemConfig()
+
+	for _, connectionConfig := range []*irodsclient_fs.ConnectionConfig{&config.MetadataConnection, &config.IOConnection} {
+		connectionConfig.CreationTimeout = irodsclient_types.Duration(getTimeout(ctx, time.Duration(connectionConfig.CreationTimeout)))
+		connectionConfig.OperationTimeout = irodsclient_types.Duration(getTimeout(ctx, time.Duration(connectionConfig.OperationTimeout)))
+	}
+
+	return irodsclient_fs.NewFileSystem(account, config)
 }
 
-// Mkdir creates a new directory
-func Mkdir(conn *IRODSFSConnectionInfo, path string) error {
-	filesystem, err := GetIRODSFilesystem(conn)
-	if err != nil {
-		return err
+// getConnectionTimeout returns connection timeout bounded by the context deadline
+func getConnectionTimeout(ctx context.Context) time.Duration {
+	return getTimeout(ctx, connectionTimeout)
+}
+
+// getTimeout returns the timeout bounded by the context deadline
+func getTimeout(ctx context.Context, timeout time.Duration) time.Duration {
+	if deadline, ok := ctx.Deadline(); ok {
+		remaining := time.Until(deadline)
+		if remaining < timeout {
+			timeout = remaining
+		}
 	}
 
-	defer filesystem.Release()
+	if timeout <= 0 {
+		// expired, let the connection fail quickly
+		timeout = time.Millisecond
+	}
+	return timeout
+}
 
-	return filesystem.MakeDir(path, true)
+// getHostKey returns a key identifying the iRODS server for retries and circuit breakers
+func getHostKey(conn *IRODSFSConnectionInfo) string {
+	return fmt.Sprintf("%s:%d", conn.Host, conn.Port)
 }
 
-// Rmdir deletes a directory
-func Rmdir(conn *IRODSFSConnectionInfo, path string) error {
-	filesystem, err := GetIRODSFilesystem(conn)
-	if err != nil {
+// runWithContext runs the operation and returns early if the context is canceled or expired
+// go-irodsclient calls do not take a context, so the operation is not stopped when the context is done.
+// it keeps running in background, holding its iRODS connection, until it finishes or fails by the timeouts of the connection,
+// which GetIRODSFilesystem and TestConnection bound by the context deadline.
+// changes it makes to iRODS may still happen after this returns, and its result is dropped.
+func runWithContext(ctx context.Context, operation func() error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- operation()
+	}()
+
+	select {
+	case err := <-errChan:
 		return err
+	case <-ctx.Done():
+		klog.V(5).Infof("operation abandoned, it keeps running in background until it finishes - %v", ctx.Err())
+		return ctx.Err()
 	}
+}
 
-	defer filesystem.Release()
+// Mkdir creates a new directory
+func Mkdir(ctx context.Context, conn *IRODSFSConnectionInfo, path string) error {
+	return runWithRetry(ctx, getHostKey(conn), func() error {
+		filesystem, err := GetIRODSFilesystem(ctx, conn)
+		if err != nil {
+			return err
+		}
 
-	return filesystem.RemoveDir(path, true, true)
+		defer filesystem.Release()
+
+		return filesystem.MakeDir(path, true)
+	})
+}
+
+// Rmdir deletes a directory
+func Rmdir(ctx context.Context, conn *IRODSFSConnectionInfo, path string) error {
+	return runWithRetry(ctx, getHostKey(conn), func() error {
+		filesystem, err := GetIRODSFilesystem(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		defer filesystem.Release()
+
+		err = filesystem.RemoveDir(path, true, true)
+		if err != nil && irodsclient_types.IsFileNotFoundError(err) {
+			// already deleted, e.g., by a previous attempt
+			return nil
+		}
+		return err
+	})
 }
 
 // TestConnection just test connection creation
-func TestConnection(conn *IRODSFSConnectionInfo) error {
+func TestConnection(ctx context.Context, conn *IRODSFSConnectionInfo) error {
 	account := GetIRODSAccount(conn)
 
-	// test connect
-	irodsConn := irodsclient_connection.NewIRODSConnection(account, time.Second*60, applicationName)
-	err := irodsConn.Connect()
-	if err != nil {
-		klog.V(5).Infof("Failed to connect to iRODS - %v", conn.ToIRODSAccount().GetRedacted())
-		return err
-	}
+	return runWithRetry(ctx, getHostKey(conn), func() error {
+		// test connect
+		timeout := getConnectionTimeout(ctx)
+		irodsConn := irodsclient_connection.NewIRODSConnection(account, timeout, applicationName)
+		err := irodsConn.Connect()
+		if err != nil {
+			klog.V(5).Infof("Failed to connect to iRODS - %v", account.GetRedacted())
+			return err
+		}
 
-	irodsConn.Disconnect()
-	return nil
+		irodsConn.Disconnect()
+		return nil
+	})
 }
@@ -1,28 +1,34 @@
 package irods
 
 import (
+	"errors"
 	"fmt"
 	"io/fs"
 	"os"
 	"path"
 	"path/filepath"
-	"strings"
+	"sync/atomic"
+	"time"
 
 	irodsclient_fs "github.com/cyverse/go-irodsclient/fs"
 	irodsclient_types "github.com/cyverse/go-irodsclient/irods/types"
 	irodsfs_common_inode "github.com/cyverse/irodsfs-common/inode"
 	irodsfs_common_irods "github.com/cyverse/irodsfs-common/irods"
 	irodsfs_common_vpath "github.com/cyverse/irodsfs-common/vpath"
-	"github.com/pkg/xattr"
 	"golang.org/x/xerrors"
 	"k8s.io/klog"
 )
 
 const (
-	overlayFSOpaqueXAttr string = "trusted.overlay.opaque"
-	syncStatusFileSuffix string = ".csi.overlay.sync.log"
+	syncStatusFileSuffix string = ".csi.overlay.sync.ndjson"
 )
 
+// errOverlayFSSyncConflict is returned when an entry is skipped due to a conflict
+var errOverlayFSSyncConflict = errors.New("changed in iRODS after mount")
+
+// errOverlayFSSyncUnsupported is reported for entries iRODS cannot store, e.g., devices, sockets and pipes
+var errOverlayFSSyncUnsupported = errors.New("not supported by iRODS")
+
 // OverlayFSSyncher is a struct for OverlayFSSyncher
 type OverlayFSSyncher struct {
 	volumeID            string
@@ -31,10 +37,41 @@ type OverlayFSSyncher struct {
 	irodsFsVPathManager *irodsfs_common_vpath.VPathManager
 	parallelJobManager  *ParallelJobManager
 	upperLayerPath      string
+
+	// journal records synced entries to skip them on resume, can be nil
+	journal OverlayFSSyncJournal
+	// entries synced before, relative to the upper, with their states when synced
+	doneEntries map[string]OverlayFSSyncDoneEntry
+	// number of entries failed to sync
+	failedEntries atomic.Int64
+	// number of entries skipped due to conflicts
+	conflictEntries atomic.Int64
+	// number of entries synced, skipped, unsupported, excluded, and bytes uploaded
+	syncedEntries      atomic.Int64
+	skippedEntries     atomic.Int64
+	unsupportedEntries atomic.Int64
+	excludedEntries    atomic.Int64
+	syncedBytes        atomic.Int64
+	// writes statuses of entries to the status file
+	statusWriter *overlayFSSyncStatusWriter
+	// entries synced while mounted, can be nil
+	state *overlayFSSyncState
+	// excludes entries from sync, nil if no patterns are given
+	filter *overlayFSSyncFilter
+	// dirs in the upper stored with a conflict suffix, entries in them are synced there
+	conflictDirs *overlayFSConflictDirs
+	// set for syncs while mounted, renamed dirs are not moved as the live overlay reads them from the lower
+	mounted bool
+	// renamed dirs not moved in syncs while mounted, and their paths in the lower, both in the upper
+	redirectedDirs  map[string]bool
+	redirectSources map[string]bool
+	// dirs moved in this sync in order, sources of redirects are resolved to where they are moved
+	movedDirs []overlayFSMovedDir
 }
 
 // NewOverlayFSSyncher creates a new OverlayFSSyncher
-func NewOverlayFSSyncher(volumeID string, irodsConnInfo *IRODSFSConnectionInfo, upper string) (*OverlayFSSyncher, error) {
+// journal can be nil if the sync does not need to be resumed
+func NewOverlayFSSyncher(volumeID string, irodsConnInfo *IRODSFSConnectionInfo, upper string, journal OverlayFSSyncJournal) (*OverlayFSSyncher, error) {
 	irodsAccount := GetIRODSAccount(irodsConnInfo)
 	fsConfig := GetIRODSFilesystemConfig()
 
@@ -52,6 +89,11 @@ func NewOverlayFSSyncher(volumeID string, irodsConnInfo *IRODSFSConnectionInfo,
 		return nil, xerrors.Errorf("failed to create Virtual Path Manager: %w", err)
 	}
 
+	filter, err := newOverlayFSSyncFilter(irodsConnInfo.OverlayFSSyncExclude)
+	if err != nil {
+		return nil, xerrors.Errorf("failed to parse sync patterns: %w", err)
+	}
+
 	parallelJobManager := NewParallelJobManager(4)
 
 	absUpper, err := filepath.Abs(upper)
@@ -66,6 +108,10 @@ func NewOverlayFSSyncher(volumeID string, irodsConnInfo *IRODSFSConnectionInfo,
 		irodsFsVPathManager: vpathManager,
 		parallelJobManager:  parallelJobManager,
 		upperLayerPath:      absUpper,
+		journal:             journal,
+		doneEntries:         map[string]OverlayFSSyncDoneEntry{},
+		filter:              filter,
+		conflictDirs:        newOverlayFSConflictDirs(),
 	}, nil
 }
 
@@ -81,6 +127,122 @@ func (syncher *OverlayFSSyncher) GetUpperLayerPath() string {
 	return syncher.upperLayerPath
 }
 
+// SetMounted marks the sync as a sync while mounted
+// renamed dirs and entries in them are synced in the final sync after unmount
+func (syncher *OverlayFSSyncher) SetMounted() {
+	syncher.mounted = true
+}
+
+// SetSyncState sets the state of entries synced while mounted, to skip unchanged entries
+func (syncher *OverlayFSSyncher) SetSyncState(state *overlayFSSyncState) {
+	syncher.state = state
+	// entries in dirs stored with a conflict suffix in previous syncs follow them
+	syncher.conflictDirs = state.conflictDirs
+}
+
+// isDone checks if the entry is synced before the sync is resumed or while mounted, and unchanged since
+// dirs in the journal are done once, as the upper does not change after unmount
+// dirs synced while mounted are synced again if they are made again or become opaque
+func (syncher *OverlayFSSyncher) isDone(path string, d fs.DirEntry) bool {
+	relPath, err := filepath.Rel(syncher.upperLayerPath, path)
+	if err != nil {
+		return false
+	}
+
+	info, err := d.Info()
+	if err != nil {
+		return false
+	}
+
+	if doneEntry, ok := syncher.doneEntries[relPath]; ok {
+		if info.IsDir() || (doneEntry.Size == info.Size() && doneEntry.ModTime.Equal(info.ModTime())) {
+			return true
+		}
+	}
+
+	if syncher.state != nil {
+		return syncher.state.isSynced(relPath, info, info.IsDir() && syncher.isOpaqueDir(path))
+	}
+	return false
+}
+
+// markDone records the entry as synced
+func (syncher *OverlayFSSyncher) markDone(path string, info fs.FileInfo) {
+	relPath, err := filepath.Rel(syncher.upperLayerPath, path)
+	if err != nil {
+		return
+	}
+
+	if syncher.state != nil && info != nil {
+		syncher.state.markSynced(relPath, info, info.IsDir() && syncher.isOpaqueDir(path))
+	}
+
+	if syncher.journal == nil || info == nil {
+		// without the state, the entry cannot be checked for changes on resume
+		return
+	}
+
+	doneEntry := OverlayFSSyncDoneEntry{
+		Size:    info.Size(),
+		ModTime: info.ModTime(),
+	}
+
+	err = syncher.journal.MarkDone(syncher.volumeID, relPath, doneEntry)
+	if err != nil {
+		// the entry will be synced again on resume
+		klog.Errorf("failed to record sync progress of %q, volume %q, %s", path, syncher.volumeID, err)
+	}
+}
+
+// runSyncTask runs the sync of the entry, and records the result
+func (syncher *OverlayFSSyncher) runSyncTask(path string, d fs.DirEntry, kind string, sync func(status *OverlayFSSyncEntryStatus) error) {
+	// state before sync, changes during sync are synced again later
+	info, _ := d.Info()
+
+	relPath, _ := filepath.Rel(syncher.upperLayerPath, path)
+	status := &OverlayFSSyncEntryStatus{
+		Type: "entry",
+		Time: time.Now(),
+		Kind: kind,
+		Path: relPath,
+	}
+
+	err := sync(status)
+	status.DurationSeconds = time.Since(status.Time).Seconds()
+	syncher.reportEntry(status, err)
+
+	if errors.Is(err, errOverlayFSSyncConflict) {
+		// kept in the upper, not marked done to check again
+		klog.Warningf("skipped syncing %s %q, volume %q, %s", kind, path, syncher.volumeID, err)
+		return
+	}
+
+	if err != nil {
+		klog.Errorf("failed to sync %s %q, volume %q, %s", kind, path, syncher.volumeID, err)
+		return
+	}
+
+	syncher.markDone(path, info)
+}
+
+// loadDoneEntries loads entries synced before the sync is resumed
+func (syncher *OverlayFSSyncher) loadDoneEntries() error {
+	if syncher.journal == nil {
+		return nil
+	}
+
+	doneEntries, err := syncher.journal.GetDone(syncher.volumeID)
+	if err != nil {
+		return xerrors.Errorf("failed to read sync progress for volume %q: %w", syncher.volumeID, err)
+	}
+
+	if len(doneEntries) > 0 {
+		klog.V(3).Infof("resuming sync'ing path %q for volume %q, skipping %d entries synced before", syncher.upperLayerPath, syncher.volumeID, len(doneEntries))
+	}
+	syncher.doneEntries = doneEntries
+	return nil
+}
+
 func (syncher *OverlayFSSyncher) getStatusFilePath() string {
 	return fmt.Sprintf("/%s/home/%s/.%s%s", syncher.irodsConnectionInfo.ClientZoneName, syncher.irodsConnectionInfo.ClientUsername, syncher.volumeID, syncStatusFileSuffix)
 }
@@ -99,7 +261,10 @@ func (syncher *OverlayFSSyncher) Sync() error {
 		return nil
 	}
 
-	syncher.parallelJobManager.Start()
+	err = syncher.loadDoneEntries()
+	if err != nil {
+		return err
+	}
 
 	statusFile := syncher.getStatusFilePath()
 
@@ -110,7 +275,39 @@ func (syncher *OverlayFSSyncher) Sync() error {
 	}
 	defer statusFileHandle.Close()
 
+	syncher.statusWriter = newOverlayFSSyncStatusWriter(statusFileHandle)
+
+	startTime := time.Now()
+	err = syncher.syncEntries()
+	syncher.reportSummary(startTime, err)
+	return err
+}
+
+// syncEntries walks the upper and syncs entries in parallel
+func (syncher *OverlayFSSyncher) syncEntries() error {
+	if syncher.irodsConnectionInfo.OverlayFSSyncApprovalThreshold > 0 {
+		err := syncher.checkApproval()
+		if err != nil {
+			return err
+		}
+	}
+
+	// renamed dirs are moved first, entries in them are synced to where they are moved
+	var err error
+	if syncher.mounted {
+		err = syncher.collectRedirects()
+	} else {
+		err = syncher.applyRedirects()
+	}
+	if err != nil {
+		return xerrors.Errorf("failed to walk dir %q for volume %q: %w", syncher.upperLayerPath, syncher.volumeID, err)
+	}
+
+	syncher.parallelJobManager.Start()
+
 	currentDirPath := ""
+	// small files in the current dir to upload in a bundle, nil if bulk upload is disabled or no files are added
+	var bundle *overlayFSBundle
 
 	walkFunc := func(path string, d fs.DirEntry, err error) error {
 		if err != nil {
@@ -120,11 +317,17 @@ func (syncher *OverlayFSSyncher) Sync() error {
 		parentDirPath := filepath.Dir(path)
 		if currentDirPath != parentDirPath {
 			// new dir
+			if bundle != nil {
+				syncher.scheduleBundle(bundle)
+				bundle = nil
+			}
+
 			// wait until other jobs are done
 			taskName := fmt.Sprintf("barrier - %q for volume %q", parentDirPath, syncher.volumeID)
 			scheduleErr := syncher.parallelJobManager.ScheduleBarrier(taskName)
 			if scheduleErr != nil {
 				klog.Errorf("failed to schedule barrier task for %q, volume %q, %s", path, syncher.volumeID, scheduleErr)
+				syncher.failedEntries.Add(1)
 				return nil
 			}
 
@@ -137,12 +340,27 @@ func (syncher *OverlayFSSyncher) Sync() error {
 				return nil
 			}
 
+			if syncher.isExcluded(path, true) {
+				// entries in the dir are not synced
+				syncher.reportExcluded(path, "dir")
+				return filepath.SkipDir
+			}
+
+			if syncher.redirectedDirs[path] {
+				// the lower dir is moved after unmount, entries in the dir follow
+				klog.V(5).Infof("skipped syncing renamed dir %q while mounted, volume %q", path, syncher.volumeID)
+				return filepath.SkipDir
+			}
+
+			if syncher.isDone(path, d) {
+				// synced before resume or while mounted, entries in the dir are checked separately
+				return nil
+			}
+
 			dirSyncTask := func(job *ParallelJob) error {
-				syncErr := syncher.syncDir(path, statusFileHandle)
-				if syncErr != nil {
-					klog.Errorf("failed to sync dir %q, volume %q, %s", path, syncher.volumeID, syncErr)
-					return nil
-				}
+				syncher.runSyncTask(path, d, "dir", func(status *OverlayFSSyncEntryStatus) error {
+					return syncher.syncDir(path, status)
+				})
 				return nil
 			}
 
@@ -150,6 +368,7 @@ func (syncher *OverlayFSSyncher) Sync() error {
 			scheduleErr := syncher.parallelJobManager.Schedule(taskName, dirSyncTask, 1)
 			if scheduleErr != nil {
 				klog.Errorf("failed to schedule dir sync task for %q, volume %q, %s", path, syncher.volumeID, scheduleErr)
+				syncher.failedEntries.Add(1)
 				return nil
 			}
 		} else {
@@ -158,13 +377,39 @@ func (syncher *OverlayFSSyncher) Sync() error {
 				return nil
 			}
 
-			if d.Type()&os.ModeCharDevice != 0 {
+			if syncher.isWhiteout(path, d) && syncher.isWhiteoutExcluded(path) {
+				// the entry deleted is kept in iRODS
+				syncher.reportExcluded(path, "whiteout")
+				return nil
+			}
+
+			if syncher.isWhiteout(path, d) && syncher.isRedirectSource(syncher.getWhiteoutTarget(path)) {
+				// the dir renamed is moved after unmount
+				klog.V(5).Infof("skipped syncing whiteout %q of a renamed dir while mounted, volume %q", path, syncher.volumeID)
+				return nil
+			}
+
+			if !syncher.isWhiteout(path, d) && syncher.isExcluded(path, false) {
+				syncher.reportExcluded(path, "file")
+				return nil
+			}
+
+			if syncher.isDone(path, d) {
+				// synced before resume, or synced while mounted and unchanged
+				return nil
+			}
+
+			if isUnsupportedEntry(d) {
+				// e.g., devices, sockets and pipes
+				syncher.reportUnsupported(path, d)
+				return nil
+			}
+
+			if syncher.isWhiteout(path, d) {
 				whiteoutSyncTask := func(job *ParallelJob) error {
-					syncErr := syncher.syncWhiteout(path, statusFileHandle)
-					if syncErr != nil {
-						klog.Errorf("failed to sync whiteout %q, volume %q, %s", path, syncher.volumeID, syncErr)
-						return nil
-					}
+					syncher.runSyncTask(path, d, "whiteout", func(status *OverlayFSSyncEntryStatus) error {
+						return syncher.syncWhiteout(syncher.getWhiteoutTarget(path), status)
+					})
 					return nil
 				}
 
@@ -172,22 +417,41 @@ func (syncher *OverlayFSSyncher) Sync() error {
 				scheduleErr := syncher.parallelJobManager.Schedule(taskName, whiteoutSyncTask, 1)
 				if scheduleErr != nil {
 					klog.Errorf("failed to schedule whiteout sync task for %q, volume %q, %s", path, syncher.volumeID, scheduleErr)
+					syncher.failedEntries.Add(1)
 					return nil
 				}
 			} else {
-				fileSyncTask := func(job *ParallelJob) error {
-					syncErr := syncher.syncFile(path, statusFileHandle)
-					if syncErr != nil {
-						klog.Errorf("failed to sync file %q, volume %q, %s", path, syncher.volumeID, syncErr)
-						return nil
+				if info := syncher.getBundleableInfo(d); info != nil {
+					// small file
+					if bundle == nil {
+						bundle = newOverlayFSBundle(parentDirPath)
 					}
+
+					bundle.add(path, d, info)
+					if bundle.isFull() {
+						syncher.scheduleBundle(bundle)
+						bundle = nil
+					}
+					return nil
+				}
+
+				kind := "file"
+				if d.Type()&os.ModeSymlink != 0 {
+					kind = "symlink"
+				}
+
+				fileSyncTask := func(job *ParallelJob) error {
+					syncher.runSyncTask(path, d, kind, func(status *OverlayFSSyncEntryStatus) error {
+						return syncher.syncFile(path, status)
+					})
 					return nil
 				}
 
-				taskName := fmt.Sprintf("sync file - %q for volume %q", path, syncher.volumeID)
+				taskName := fmt.Sprintf("sync %s - %q for volume %q", kind, path, syncher.volumeID)
 				scheduleErr := syncher.parallelJobManager.Schedule(taskName, fileSyncTask, 1)
 				if scheduleErr != nil {
 					klog.Errorf("failed to schedule file sync task for %q, volume %q, %s", path, syncher.volumeID, scheduleErr)
+					syncher.failedEntries.Add(1)
 					return nil
 				}
 			}
@@ -200,10 +464,22 @@ func (syncher *OverlayFSSyncher) Sync() error {
 		return xerrors.Errorf("failed to walk dir %q for volume %q: %w", syncher.upperLayerPath, syncher.volumeID, err)
 	}
 
+	if bundle != nil {
+		syncher.scheduleBundle(bundle)
+	}
+
 	syncher.parallelJobManager.DoneScheduling()
 	err = syncher.parallelJobManager.Wait()
 	if err != nil {
-		klog.Error(err)
+		return xerrors.Errorf("failed to sync upperdir %q for volume %q: %w", syncher.upperLayerPath, syncher.volumeID, err)
+	}
+
+	if failed := syncher.failedEntries.Load(); failed > 0 {
+		return xerrors.Errorf("failed to sync %d entries of upperdir %q for volume %q", failed, syncher.upperLayerPath, syncher.volumeID)
+	}
+
+	if conflicts := syncher.conflictEntries.Load(); conflicts > 0 {
+		return xerrors.Errorf("skipped %d entries of upperdir %q for volume %q conflicting with changes in iRODS, resolve them manually: %w", conflicts, syncher.upperLayerPath, syncher.volumeID, errOverlayFSSyncConflict)
 	}
 
 	return nil
@@ -215,6 +491,10 @@ func (syncher *OverlayFSSyncher) getIRODSPath(localPath string) (string, error)
 		return "", xerrors.Errorf("failed to get relative path from %q to %q", syncher.upperLayerPath, localPath)
 	}
 
+	if irodsPath := syncher.conflictDirs.getIRODSPath(relpath); len(irodsPath) > 0 {
+		return irodsPath, nil
+	}
+
 	vpath := path.Join("/", relpath)
 
 	entry := syncher.irodsFsVPathManager.GetClosestEntry(vpath)
@@ -235,18 +515,67 @@ func (syncher *OverlayFSSyncher) getIRODSPath(localPath string) (string, error)
 	return irodsPath, nil
 }
 
+func (syncher *OverlayFSSyncher) getBaseline() *OverlayFSSyncBaseline {
+	if syncher.state == nil {
+		return nil
+	}
+	return syncher.state.baseline
+}
+
+// resolveConflict checks if the iRODS entry was changed by others after mount, and returns the policy to apply
+// returns empty if not changed or conflicts are not checked
+func (syncher *OverlayFSSyncher) resolveConflict(entry *irodsclient_fs.Entry, status *OverlayFSSyncEntryStatus) OverlayFSConflictPolicy {
+	baseline := syncher.getBaseline()
+	if baseline == nil || !baseline.isChanged(entry) {
+		return ""
+	}
+
+	policy := syncher.irodsConnectionInfo.OverlayFSConflictPolicy
+	klog.Warningf("%q was changed in iRODS after mount, volume %q, applying %q policy", entry.Path, syncher.volumeID, policy)
+
+	status.Message = fmt.Sprintf("%q was changed in iRODS after mount, applied %q policy", entry.Path, policy)
+	return policy
+}
+
+// recordChanged records the state of the iRODS entry changed by the syncher, not to detect it as a conflict later
+func (syncher *OverlayFSSyncher) recordChanged(irodsPath string) {
+	baseline := syncher.getBaseline()
+	if baseline == nil {
+		return
+	}
+
+	entry, err := syncher.irodsFsClient.Stat(irodsPath)
+	if err != nil {
+		klog.Errorf("failed to stat %q to record the change, %s", irodsPath, err)
+		return
+	}
+
+	baseline.record(entry)
+}
+
+// recordRemoved records the iRODS entry removed by the syncher
+func (syncher *OverlayFSSyncher) recordRemoved(irodsPath string) {
+	baseline := syncher.getBaseline()
+	if baseline == nil {
+		return
+	}
+
+	baseline.recordRemoved(irodsPath)
+}
+
 func (syncher *OverlayFSSyncher) isIgnoredFile(path string) bool {
-	filename := filepath.Base(path)
-	// ignore fuse-overlayfs whiteout files
-	// it also uses xattr so we can just ignore them
-	if strings.HasPrefix(filename, ".wh.") && strings.HasSuffix(filename, ".opq") {
+	if path == filepath.Join(syncher.upperLayerPath, overlayFSSyncControlFileName) {
+		// sync trigger, not data
 		return true
 	}
-	return false
+
+	// opaque markers of fuse-overlayfs, handled with the dir
+	return syncher.hasWhiteoutFiles() && filepath.Base(path) == overlayFSOpaqueWhiteout
 }
 
-func (syncher *OverlayFSSyncher) syncWhiteout(path string, statusFileHandle *irodsclient_fs.FileHandle) error {
-	klog.V(5).Infof("processing whiteout file %q", path)
+// syncWhiteout deletes the entry hidden by a whiteout, path is the path of the entry deleted
+func (syncher *OverlayFSSyncher) syncWhiteout(path string, status *OverlayFSSyncEntryStatus) error {
+	klog.V(5).Infof("processing whiteout of %q", path)
 
 	irodsPath, err := syncher.getIRODSPath(path)
 	if err != nil {
@@ -255,13 +584,12 @@ func (syncher *OverlayFSSyncher) syncWhiteout(path string, statusFileHandle *iro
 
 	if len(irodsPath) == 0 {
 		klog.V(5).Infof("ignoring %q as it's not writable", path)
+		status.skip("not writable")
 		return nil
 	}
 
-	if statusFileHandle != nil {
-		msg := fmt.Sprintf("Processing whiteout %q\n", irodsPath)
-		statusFileHandle.Write([]byte(msg))
-	}
+	status.IRODSPath = irodsPath
+	status.Action = OverlayFSSyncActionDelete
 
 	entry, err := syncher.irodsFsClient.Stat(irodsPath)
 	if err != nil {
@@ -269,51 +597,52 @@ func (syncher *OverlayFSSyncher) syncWhiteout(path string, statusFileHandle *iro
 			// not exist
 			klog.Errorf("file or dir %q not exist", irodsPath)
 			// suppress warning
-			if statusFileHandle != nil {
-				msg := fmt.Sprintf("> Failed. file or dir %q not exist, ignored\n", irodsPath)
-				statusFileHandle.Write([]byte(msg))
-			}
+			status.skip("not exist in iRODS")
 			return nil
 		}
 
-		if statusFileHandle != nil {
-			msg := fmt.Sprintf("> Fail. failed to stat %q. %s\n", irodsPath, err)
-			statusFileHandle.Write([]byte(msg))
-		}
 		return xerrors.Errorf("failed to stat %q: %w", irodsPath, err)
 	}
 
+	switch syncher.resolveConflict(entry, status) {
+	case OverlayFSConflictKeepBoth:
+		// keep changes by others
+		status.Outcome = OverlayFSSyncOutcomeSkipped
+		return nil
+	case OverlayFSConflictSkip:
+		return xerrors.Errorf("failed to delete %q: %w", irodsPath, errOverlayFSSyncConflict)
+	}
+
 	klog.V(5).Infof("deleting file or dir %q", irodsPath)
 
 	// remove
 	if entry.IsDir() {
-		err = syncher.irodsFsClient.RemoveDir(irodsPath, true, true)
+		removed, conflicts, err := syncher.removeIRODSDir(path, irodsPath, status)
 		if err != nil {
-			if statusFileHandle != nil {
-				msg := fmt.Sprintf("> Fail. failed to remove dir %q. %s\n", irodsPath, err)
-				statusFileHandle.Write([]byte(msg))
-			}
-			return xerrors.Errorf("failed to remove dir %q: %w", irodsPath, err)
+			return err
 		}
-	} else {
-		err = syncher.irodsFsClient.RemoveFile(irodsPath, true)
-		if err != nil {
-			if statusFileHandle != nil {
-				msg := fmt.Sprintf("> Fail. failed to remove file %q. %s\n", irodsPath, err)
-				statusFileHandle.Write([]byte(msg))
-			}
-			return xerrors.Errorf("failed to remove file %q: %w", irodsPath, err)
+
+		if conflicts > 0 {
+			return xerrors.Errorf("failed to delete %d entries in %q: %w", conflicts, irodsPath, errOverlayFSSyncConflict)
+		}
+
+		if !removed {
+			status.Message = "entries changed in iRODS after mount or excluded from sync are kept"
 		}
+		return nil
 	}
 
-	if statusFileHandle != nil {
-		msg := fmt.Sprintf("> Done. processed whiteout %q\n", irodsPath)
-		statusFileHandle.Write([]byte(msg))
+	err = syncher.irodsFsClient.RemoveFile(irodsPath, true)
+	if err != nil {
+		return xerrors.Errorf("failed to remove file %q: %w", irodsPath, err)
 	}
+
+	syncher.recordRemoved(irodsPath)
+
 	return nil
 }
 
-func (syncher *OverlayFSSyncher) syncFile(path string, statusFileHandle *irodsclient_fs.FileHandle) error {
+func (syncher *OverlayFSSyncher) syncFile(path string, status *OverlayFSSyncEntryStatus) error {
 	klog.V(5).Infof("processing new or updated file %q", path)
 
 	irodsPath, err := syncher.getIRODSPath(path)
@@ -323,61 +652,109 @@ func (syncher *OverlayFSSyncher) syncFile(path string, statusFileHandle *irodscl
 
 	if len(irodsPath) == 0 {
 		klog.V(5).Infof("ignoring %q as it's not writable", path)
+		status.skip("not writable")
 		return nil
 	}
 
-	if statusFileHandle != nil {
-		msg := fmt.Sprintf("Processing file %q\n", irodsPath)
-		statusFileHandle.Write([]byte(msg))
+	status.IRODSPath = irodsPath
+	status.Action = OverlayFSSyncActionCreate
+
+	info, err := os.Lstat(path)
+	if err != nil {
+		return xerrors.Errorf("failed to stat %q: %w", path, err)
 	}
 
+	symlink := info.Mode()&os.ModeSymlink != 0
+
 	entry, err := syncher.irodsFsClient.Stat(irodsPath)
 	if err != nil {
 		if !irodsclient_types.IsFileNotFoundError(err) {
-			if statusFileHandle != nil {
-				msg := fmt.Sprintf("> Fail. failed to stat %q. %s\n", irodsPath, err)
-				statusFileHandle.Write([]byte(msg))
-			}
 			return xerrors.Errorf("failed to stat %q: %w", irodsPath, err)
 		}
 	} else {
 		// exist
+		if !entry.IsDir() && !symlink {
+			// skip files not changed, e.g., touched or copied with "cp -a"
+			// changes by others to the same content are not conflicts
+			unchanged, err := syncher.isFileUnchanged(path, entry)
+			if err != nil {
+				klog.V(4).Infof("failed to compare %q with %q, uploading, %s", path, irodsPath, err)
+			} else if unchanged {
+				klog.V(5).Infof("skipping file %q, same checksum", irodsPath)
+				status.skip("same checksum")
+
+				// e.g., chmod copies the file up without changing content
+				return syncher.preserveAttrs(path, irodsPath)
+			}
+		}
+
+		status.Action = OverlayFSSyncActionOverwrite
+
+		switch syncher.resolveConflict(entry, status) {
+		case OverlayFSConflictKeepBoth:
+			// store ours beside changes by others
+			irodsPath = getConflictPath(irodsPath, time.Now())
+			entry = nil
+			status.IRODSPath = irodsPath
+			status.Action = OverlayFSSyncActionCreate
+		case OverlayFSConflictSkip:
+			return xerrors.Errorf("failed to overwrite %q: %w", irodsPath, errOverlayFSSyncConflict)
+		}
+
 		// if it is a dir, remove first
 		// if it is a file, overwrite
-		if entry.IsDir() {
+		if entry != nil && entry.IsDir() {
 			klog.V(5).Infof("deleting dir %q", irodsPath)
 
-			err = syncher.irodsFsClient.RemoveDir(irodsPath, true, true)
+			removed, conflicts, err := syncher.removeIRODSDir(path, irodsPath, status)
 			if err != nil {
-				if statusFileHandle != nil {
-					msg := fmt.Sprintf("> Fail. failed to remove dir %q. %s\n", irodsPath, err)
-					statusFileHandle.Write([]byte(msg))
-				}
-				return xerrors.Errorf("failed to remove dir %q: %w", irodsPath, err)
+				return err
+			}
+
+			if conflicts > 0 {
+				return xerrors.Errorf("failed to replace dir %q with a file, %d entries in it: %w", irodsPath, conflicts, errOverlayFSSyncConflict)
 			}
+
+			if !removed {
+				return xerrors.Errorf("failed to replace dir %q with a file, it has entries changed in iRODS after mount or excluded from sync", irodsPath)
+			}
+		}
+	}
+
+	if symlink {
+		klog.V(5).Infof("copying symlink %q", irodsPath)
+
+		size, err := syncher.uploadSymlink(path, irodsPath)
+		if err != nil {
+			return err
 		}
+
+		status.Bytes = size
+		syncher.recordChanged(irodsPath)
+		return nil
 	}
 
 	klog.V(5).Infof("copying file %q", irodsPath)
 
-	// upload the file
-	_, err = syncher.irodsFsClient.UploadFileRedirectToResource(path, irodsPath, "", 0, false, true, true, false, nil)
+	// upload the file, verifying checksum
+	err = syncher.uploadFile(path, irodsPath)
 	if err != nil {
-		if statusFileHandle != nil {
-			msg := fmt.Sprintf("> Fail. failed to upload file %q. %s\n", irodsPath, err)
-			statusFileHandle.Write([]byte(msg))
-		}
 		return xerrors.Errorf("failed to upload file %q: %w", irodsPath, err)
 	}
 
-	if statusFileHandle != nil {
-		msg := fmt.Sprintf("> Done. processed file %q\n", irodsPath)
-		statusFileHandle.Write([]byte(msg))
+	status.Bytes = info.Size()
+
+	err = syncher.preserveAttrs(path, irodsPath)
+	if err != nil {
+		return err
 	}
+
+	syncher.recordChanged(irodsPath)
+
 	return nil
 }
 
-func (syncher *OverlayFSSyncher) syncDir(path string, statusFileHandle *irodsclient_fs.FileHandle) error {
+func (syncher *OverlayFSSyncher) syncDir(path string, status *OverlayFSSyncEntryStatus) error {
 	klog.V(5).Infof("processing dir %q", path)
 
 	irodsPath, err := syncher.getIRODSPath(path)
@@ -387,24 +764,14 @@ func (syncher *OverlayFSSyncher) syncDir(path string, statusFileHandle *irodscli
 
 	if len(irodsPath) == 0 {
 		klog.V(5).Infof("ignoring %q as it's not writable", path)
+		status.skip("not writable")
 		return nil
 	}
 
-	if statusFileHandle != nil {
-		msg := fmt.Sprintf("Processing dir %q\n", irodsPath)
-		statusFileHandle.Write([]byte(msg))
-	}
+	status.IRODSPath = irodsPath
+	status.Action = OverlayFSSyncActionCreate
 
-	opaqueDir := false
-	xattrVal, err := xattr.Get(path, overlayFSOpaqueXAttr)
-	if err == nil {
-		xattrValStr := string(xattrVal)
-		klog.V(5).Infof("xattr for path %q: %q = %q", path, overlayFSOpaqueXAttr, xattrValStr)
-
-		if strings.ToLower(xattrValStr) == "y" {
-			opaqueDir = true
-		}
-	}
+	opaqueDir := syncher.isOpaqueDir(path)
 
 	entry, err := syncher.irodsFsClient.Stat(irodsPath)
 	if err != nil {
@@ -414,24 +781,19 @@ func (syncher *OverlayFSSyncher) syncDir(path string, statusFileHandle *irodscli
 
 			err = syncher.irodsFsClient.MakeDir(irodsPath, true)
 			if err != nil {
-				if statusFileHandle != nil {
-					msg := fmt.Sprintf("> Fail. failed to make dir %q. %s\n", irodsPath, err)
-					statusFileHandle.Write([]byte(msg))
-				}
 				return xerrors.Errorf("failed to make dir %q: %w", irodsPath, err)
 			}
 
-			if statusFileHandle != nil {
-				msg := fmt.Sprintf("> Done. processed dir %q\n", irodsPath)
-				statusFileHandle.Write([]byte(msg))
+			err = syncher.preserveAttrs(path, irodsPath)
+			if err != nil {
+				return err
 			}
+
+			syncher.recordChanged(irodsPath)
+
 			return nil
 		}
 
-		if statusFileHandle != nil {
-			msg := fmt.Sprintf("> Fail. failed to stat %q. %s\n", irodsPath, err)
-			statusFileHandle.Write([]byte(msg))
-		}
 		return xerrors.Errorf("failed to stat %q: %w", irodsPath, err)
 	}
 
@@ -440,32 +802,44 @@ func (syncher *OverlayFSSyncher) syncDir(path string, statusFileHandle *irodscli
 	// if it is a dir, merge or remove
 	if !entry.IsDir() {
 		// file
-		klog.V(5).Infof("deleting file %q", irodsPath)
-
-		err = syncher.irodsFsClient.RemoveFile(irodsPath, true)
-		if err != nil {
-			if statusFileHandle != nil {
-				msg := fmt.Sprintf("> Fail. failed to remove file %q. %s\n", irodsPath, err)
-				statusFileHandle.Write([]byte(msg))
+		keepBoth := false
+		switch syncher.resolveConflict(entry, status) {
+		case OverlayFSConflictKeepBoth:
+			// store ours beside changes by others
+			keepBoth = true
+			irodsPath = getConflictPath(irodsPath, time.Now())
+			status.IRODSPath = irodsPath
+		case OverlayFSConflictSkip:
+			return xerrors.Errorf("failed to replace %q with a dir: %w", irodsPath, errOverlayFSSyncConflict)
+		default:
+			klog.V(5).Infof("deleting file %q", irodsPath)
+
+			err = syncher.irodsFsClient.RemoveFile(irodsPath, true)
+			if err != nil {
+				return xerrors.Errorf("failed to remove file %q: %w", irodsPath, err)
 			}
-			return xerrors.Errorf("failed to remove file %q: %w", irodsPath, err)
 		}
 
 		klog.V(5).Infof("making dir %q", irodsPath)
 
 		err = syncher.irodsFsClient.MakeDir(irodsPath, true)
 		if err != nil {
-			if statusFileHandle != nil {
-				msg := fmt.Sprintf("> Fail. failed to make dir %q. %s\n", irodsPath, err)
-				statusFileHandle.Write([]byte(msg))
-			}
 			return xerrors.Errorf("failed to make dir %q: %w", irodsPath, err)
 		}
 
-		if statusFileHandle != nil {
-			msg := fmt.Sprintf("> Done. processed dir %q\n", irodsPath)
-			statusFileHandle.Write([]byte(msg))
+		err = syncher.preserveAttrs(path, irodsPath)
+		if err != nil {
+			return err
+		}
+
+		syncher.recordChanged(irodsPath)
+
+		if keepBoth {
+			// entries in the dir are synced to the dir stored with the suffix
+			relPath, _ := filepath.Rel(syncher.upperLayerPath, path)
+			syncher.conflictDirs.set(relPath, irodsPath)
 		}
+
 		return nil
 	}
 
@@ -473,42 +847,146 @@ func (syncher *OverlayFSSyncher) syncDir(path string, statusFileHandle *irodscli
 	if opaqueDir {
 		// remove
 		klog.V(5).Infof("emptying dir %q", irodsPath)
+		status.Action = OverlayFSSyncActionClearDir
 
-		err = syncher.clearDirEntries(irodsPath)
+		err = syncher.clearDirEntries(path, irodsPath, status)
 		if err != nil {
-			if statusFileHandle != nil {
-				msg := fmt.Sprintf("> Fail. failed to clear dir %q. %s\n", irodsPath, err)
-				statusFileHandle.Write([]byte(msg))
-			}
 			return xerrors.Errorf("failed to clear %q: %w", irodsPath, err)
 		}
 	} else {
 		// merge
 		klog.V(5).Infof("merging dir %q", irodsPath)
+		status.skip("exists in iRODS, merged")
 	}
 
-	return nil
+	// e.g., chmod copies the dir up
+	return syncher.preserveAttrs(path, irodsPath)
 }
 
-func (syncher *OverlayFSSyncher) clearDirEntries(path string) error {
+// clearDirEntries removes entries in the dir not in the upper, entries changed by others after mount are kept unless overwritten
+// entries in the upper are synced by themselves, so the dir can be synced again without deleting entries synced
+// entries excluded from sync are kept
+func (syncher *OverlayFSSyncher) clearDirEntries(localPath string, path string, status *OverlayFSSyncEntryStatus) error {
 	entries, err := syncher.irodsFsClient.List(path)
 	if err != nil {
 		return xerrors.Errorf("failed to read dir %q: %w", path, err)
 	}
 
+	conflicts := 0
 	for _, entry := range entries {
+		entryLocalPath := filepath.Join(localPath, entry.Name)
+		if syncher.isExcluded(entryLocalPath, entry.IsDir()) {
+			continue
+		}
+
+		if _, err := os.Lstat(entryLocalPath); err == nil {
+			// replaced by the entry in the upper
+			continue
+		}
+
+		switch syncher.resolveConflict(entry, status) {
+		case OverlayFSConflictKeepBoth:
+			continue
+		case OverlayFSConflictSkip:
+			conflicts++
+			continue
+		}
+
 		if entry.IsDir() {
-			err = syncher.irodsFsClient.RemoveDir(entry.Path, true, true)
+			_, subConflicts, err := syncher.removeIRODSDir(entryLocalPath, entry.Path, status)
 			if err != nil {
-				return xerrors.Errorf("failed to remove dir %q: %w", entry.Path, err)
+				return err
 			}
-		} else {
-			err = syncher.irodsFsClient.RemoveFile(entry.Path, true)
+			conflicts += subConflicts
+			continue
+		}
+
+		err = syncher.irodsFsClient.RemoveFile(entry.Path, true)
+		if err != nil {
+			return xerrors.Errorf("failed to remove %q: %w", entry.Path, err)
+		}
+
+		syncher.recordRemoved(entry.Path)
+	}
+
+	if conflicts > 0 {
+		return xerrors.Errorf("failed to remove %d entries in %q: %w", conflicts, path, errOverlayFSSyncConflict)
+	}
+	return nil
+}
+
+// removeIRODSDir removes the dir recursively, entries excluded from sync and dirs having them are kept
+// entries in the dir changed by others after mount are checked one by one, as collection modify times do not change with entries in them
+// entries changed are kept unless overwritten, and dirs having them are kept
+// localPath is the path of the dir in the upper, used to match sync patterns
+// returns false if the dir is kept, and the number of entries kept to resolve conflicts manually
+func (syncher *OverlayFSSyncher) removeIRODSDir(localPath string, irodsPath string, status *OverlayFSSyncEntryStatus) (bool, int, error) {
+	if syncher.filter == nil && syncher.getBaseline() == nil {
+		err := syncher.irodsFsClient.RemoveDir(irodsPath, true, true)
+		if err != nil {
+			return false, 0, xerrors.Errorf("failed to remove dir %q: %w", irodsPath, err)
+		}
+
+		syncher.recordRemoved(irodsPath)
+		return true, 0, nil
+	}
+
+	entries, err := syncher.irodsFsClient.List(irodsPath)
+	if err != nil {
+		return false, 0, xerrors.Errorf("failed to read dir %q: %w", irodsPath, err)
+	}
+
+	kept := false
+	conflicts := 0
+	for _, entry := range entries {
+		entryLocalPath := filepath.Join(localPath, entry.Name)
+		if syncher.isExcluded(entryLocalPath, entry.IsDir()) {
+			klog.V(5).Infof("keeping %q in iRODS, excluded from sync", entry.Path)
+			kept = true
+			continue
+		}
+
+		switch syncher.resolveConflict(entry, status) {
+		case OverlayFSConflictKeepBoth:
+			// keep changes by others
+			kept = true
+			continue
+		case OverlayFSConflictSkip:
+			kept = true
+			conflicts++
+			continue
+		}
+
+		if entry.IsDir() {
+			removed, subConflicts, err := syncher.removeIRODSDir(entryLocalPath, entry.Path, status)
 			if err != nil {
-				return xerrors.Errorf("failed to remove %q: %w", entry.Path, err)
+				return false, 0, err
+			}
+
+			if !removed {
+				kept = true
 			}
+			conflicts += subConflicts
+			continue
+		}
+
+		err = syncher.irodsFsClient.RemoveFile(entry.Path, true)
+		if err != nil {
+			return false, 0, xerrors.Errorf("failed to remove %q: %w", entry.Path, err)
 		}
+
+		syncher.recordRemoved(entry.Path)
 	}
 
-	return nil
+	if kept {
+		return false, conflicts, nil
+	}
+
+	err = syncher.irodsFsClient.RemoveDir(irodsPath, true, true)
+	if err != nil {
+		return false, 0, xerrors.Errorf("failed to remove dir %q: %w", irodsPath, err)
+	}
+
+	syncher.recordRemoved(irodsPath)
+	return true, 0, nil
 }
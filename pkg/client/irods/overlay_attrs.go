@@ -0,0 +1,285 @@
+package irods
+
+import (
+	"bytes"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	irodsclient_types "github.com/cyverse/go-irodsclient/irods/types"
+	"github.com/pkg/xattr"
+	"golang.org/x/xerrors"
+	"k8s.io/klog"
+)
+
+const (
+	// overlay sets this on dirs renamed with redirect_dir, the value is the path of the dir in the lower
+	overlayFSRedirectXAttr string = "trusted.overlay.redirect"
+
+	// AVUs to store what iRODS does not have
+	// a symlink is stored as a small data object having the target as content, and the AVU having the target
+	overlayFSSymlinkAVU string = "irods-csi-driver.symlink"
+	// permission bits in octal, e.g., "0755"
+	overlayFSModeAVU string = "irods-csi-driver.mode"
+	// modify time in unix seconds, iRODS sets modify time of data objects to the upload time
+	overlayFSMTimeAVU string = "irods-csi-driver.mtime"
+)
+
+// isUnsupportedEntry checks if the entry cannot be stored in iRODS, e.g., devices, sockets and pipes
+func isUnsupportedEntry(d fs.DirEntry) bool {
+	if d.Type()&(os.ModeDevice|os.ModeNamedPipe|os.ModeSocket|os.ModeIrregular) == 0 {
+		return false
+	}
+	return !isCharDeviceWhiteout(d)
+}
+
+// reportUnsupported reports the entry not synced as iRODS cannot store it
+func (syncher *OverlayFSSyncher) reportUnsupported(path string, d fs.DirEntry) {
+	relPath, _ := filepath.Rel(syncher.upperLayerPath, path)
+
+	klog.Warningf("skipped syncing %q, volume %q, %s is not supported by iRODS", path, syncher.volumeID, d.Type())
+
+	status := &OverlayFSSyncEntryStatus{
+		Type:    "entry",
+		Time:    time.Now(),
+		Kind:    "unsupported",
+		Path:    relPath,
+		Message: fmt.Sprintf("%s is not supported by iRODS", d.Type()),
+	}
+	syncher.reportEntry(status, errOverlayFSSyncUnsupported)
+}
+
+// uploadSymlink stores the symlink as a small data object having the target, marked with an AVU
+func (syncher *OverlayFSSyncher) uploadSymlink(localPath string, irodsPath string) (int64, error) {
+	target, err := os.Readlink(localPath)
+	if err != nil {
+		return 0, xerrors.Errorf("failed to read symlink %q: %w", localPath, err)
+	}
+
+	buffer := bytes.NewBufferString(target)
+	_, err = syncher.irodsFsClient.UploadFileFromBuffer(buffer, irodsPath, "", false, true, true, false, nil)
+	if err != nil {
+		return 0, xerrors.Errorf("failed to upload symlink %q: %w", irodsPath, err)
+	}
+
+	err = syncher.setAVU(irodsPath, overlayFSSymlinkAVU, target)
+	if err != nil {
+		return 0, err
+	}
+
+	return int64(len(target)), nil
+}
+
+// setAVU sets the AVU, replacing values set before
+func (syncher *OverlayFSSyncher) setAVU(irodsPath string, name string, value string) error {
+	err := syncher.irodsFsClient.DeleteMetadataByName(irodsPath, name)
+	if err != nil {
+		// not set before
+		klog.V(5).Infof("failed to delete AVU %q of %q, %s", name, irodsPath, err)
+	}
+
+	err = syncher.irodsFsClient.AddMetadata(irodsPath, name, value, "")
+	if err != nil {
+		return xerrors.Errorf("failed to set AVU %q of %q: %w", name, irodsPath, err)
+	}
+	return nil
+}
+
+// preserveAttrs stores permission bits and modify time of the entry in the upper as AVUs, if enabled
+func (syncher *OverlayFSSyncher) preserveAttrs(localPath string, irodsPath string) error {
+	if !syncher.irodsConnectionInfo.OverlayFSPreserveAttrs {
+		return nil
+	}
+
+	info, err := os.Lstat(localPath)
+	if err != nil {
+		return xerrors.Errorf("failed to stat %q: %w", localPath, err)
+	}
+
+	err = syncher.setAVU(irodsPath, overlayFSModeAVU, fmt.Sprintf("%04o", info.Mode().Perm()))
+	if err != nil {
+		return err
+	}
+
+	return syncher.setAVU(irodsPath, overlayFSMTimeAVU, strconv.FormatInt(info.ModTime().Unix(), 10))
+}
+
+// overlayFSMovedDir is a dir moved by a redirect, paths are in the upper
+type overlayFSMovedDir struct {
+	source string
+	target string
+}
+
+// getRedirect returns the path of the renamed dir in the lower, relative to the upper, empty if not renamed
+// absolute redirects are resolved to where their parents are moved in this sync
+func (syncher *OverlayFSSyncher) getRedirect(path string) string {
+	xattrVal, err := xattr.Get(path, overlayFSRedirectXAttr)
+	if err != nil || len(xattrVal) == 0 {
+		return ""
+	}
+
+	redirect := string(xattrVal)
+	if strings.HasPrefix(redirect, "/") {
+		// absolute from the layer root, paths of the lower at mount time
+		return syncher.getMovedPath(filepath.Join(syncher.upperLayerPath, redirect))
+	}
+
+	// renamed in the same dir
+	return filepath.Join(filepath.Dir(path), redirect)
+}
+
+// getMovedPath returns where the path is after dirs moved in this sync
+func (syncher *OverlayFSSyncher) getMovedPath(path string) string {
+	for _, moved := range syncher.movedDirs {
+		if path == moved.source || strings.HasPrefix(path, moved.source+string(filepath.Separator)) {
+			path = moved.target + strings.TrimPrefix(path, moved.source)
+		}
+	}
+	return path
+}
+
+// collectRedirects finds dirs renamed with redirect_dir without moving them, for syncs while mounted
+// the live overlay reads renamed dirs from the lower, so moving them would empty the dirs in the pod
+func (syncher *OverlayFSSyncher) collectRedirects() error {
+	syncher.redirectedDirs = map[string]bool{}
+	syncher.redirectSources = map[string]bool{}
+
+	walkFunc := func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return xerrors.Errorf("failed to walk %q for volume %q: %w", path, syncher.volumeID, err)
+		}
+
+		if !d.IsDir() || path == syncher.upperLayerPath {
+			return nil
+		}
+
+		if syncher.isExcluded(path, true) {
+			return filepath.SkipDir
+		}
+
+		sourcePath := syncher.getRedirect(path)
+		if len(sourcePath) == 0 {
+			return nil
+		}
+
+		syncher.redirectedDirs[path] = true
+		syncher.redirectSources[sourcePath] = true
+		// entries in the dir are synced after unmount
+		return filepath.SkipDir
+	}
+
+	return filepath.WalkDir(syncher.upperLayerPath, walkFunc)
+}
+
+// isRedirectSource checks if the path is, or is in, a renamed dir not moved yet
+func (syncher *OverlayFSSyncher) isRedirectSource(path string) bool {
+	for sourcePath := range syncher.redirectSources {
+		if path == sourcePath || strings.HasPrefix(path, sourcePath+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
+// applyRedirects moves dirs renamed with redirect_dir in iRODS, before entries in them are synced
+// moves are not run in parallel, as renamed dirs can be nested
+func (syncher *OverlayFSSyncher) applyRedirects() error {
+	walkFunc := func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return xerrors.Errorf("failed to walk %q for volume %q: %w", path, syncher.volumeID, err)
+		}
+
+		if !d.IsDir() || path == syncher.upperLayerPath {
+			return nil
+		}
+
+		if syncher.isExcluded(path, true) {
+			return filepath.SkipDir
+		}
+
+		if syncher.isDone(path, d) {
+			return nil
+		}
+
+		sourcePath := syncher.getRedirect(path)
+		if len(sourcePath) == 0 {
+			return nil
+		}
+
+		syncher.runSyncTask(path, d, "dir", func(status *OverlayFSSyncEntryStatus) error {
+			return syncher.syncRedirect(path, sourcePath, status)
+		})
+		return nil
+	}
+
+	return filepath.WalkDir(syncher.upperLayerPath, walkFunc)
+}
+
+// syncRedirect moves the dir in iRODS from where it was in the lower
+func (syncher *OverlayFSSyncher) syncRedirect(localPath string, sourcePath string, status *OverlayFSSyncEntryStatus) error {
+	irodsPath, err := syncher.getIRODSPath(localPath)
+	if err != nil {
+		return err
+	}
+
+	sourceIRODSPath, err := syncher.getIRODSPath(sourcePath)
+	if err != nil {
+		return err
+	}
+
+	if len(irodsPath) == 0 || len(sourceIRODSPath) == 0 {
+		status.skip("not writable")
+		return nil
+	}
+
+	status.IRODSPath = irodsPath
+	status.Action = OverlayFSSyncActionMove
+
+	_, err = syncher.irodsFsClient.Stat(sourceIRODSPath)
+	if err != nil {
+		if !irodsclient_types.IsFileNotFoundError(err) {
+			return xerrors.Errorf("failed to stat %q: %w", sourceIRODSPath, err)
+		}
+
+		if syncher.irodsFsClient.ExistsDir(irodsPath) {
+			// moved in a previous sync
+			syncher.movedDirs = append(syncher.movedDirs, overlayFSMovedDir{source: sourcePath, target: localPath})
+			status.skip(fmt.Sprintf("already moved from %q", sourceIRODSPath))
+			return nil
+		}
+
+		// contents of the lower dir are lost
+		return xerrors.Errorf("failed to move %q to %q, source does not exist: %w", sourceIRODSPath, irodsPath, err)
+	}
+
+	// the parent can be a new dir not synced yet
+	err = syncher.irodsFsClient.MakeDir(path.Dir(irodsPath), true)
+	if err != nil {
+		return xerrors.Errorf("failed to make dir %q: %w", path.Dir(irodsPath), err)
+	}
+
+	klog.V(5).Infof("moving dir %q to %q", sourceIRODSPath, irodsPath)
+
+	err = syncher.irodsFsClient.RenameDirToDir(sourceIRODSPath, irodsPath)
+	if err != nil {
+		return xerrors.Errorf("failed to move dir %q to %q: %w", sourceIRODSPath, irodsPath, err)
+	}
+
+	status.Message = fmt.Sprintf("moved from %q", sourceIRODSPath)
+	syncher.movedDirs = append(syncher.movedDirs, overlayFSMovedDir{source: sourcePath, target: localPath})
+
+	syncher.recordRemoved(sourceIRODSPath)
+
+	err = syncher.preserveAttrs(localPath, irodsPath)
+	if err != nil {
+		return err
+	}
+
+	syncher.recordChanged(irodsPath)
+	return nil
+}
@@ -0,0 +1,52 @@
+package irods
+
+import "testing"
+
+func TestOverlayFSSyncherGetMovedPath(t *testing.T) {
+	// mv /a/x /c/x; mv /a /b, /b is moved first as it is walked first
+	syncher := &OverlayFSSyncher{
+		upperLayerPath: "/upper",
+		movedDirs: []overlayFSMovedDir{
+			{source: "/upper/a", target: "/upper/b"},
+			{source: "/upper/b/y", target: "/upper/d"},
+		},
+	}
+
+	testCases := []struct {
+		path     string
+		expected string
+	}{
+		{path: "/upper/a", expected: "/upper/b"},
+		{path: "/upper/a/x", expected: "/upper/b/x"},
+		{path: "/upper/a/y/z", expected: "/upper/d/z"},
+		{path: "/upper/ab", expected: "/upper/ab"},
+		{path: "/upper/c/x", expected: "/upper/c/x"},
+	}
+
+	for _, testCase := range testCases {
+		moved := syncher.getMovedPath(testCase.path)
+		if moved != testCase.expected {
+			t.Errorf("expected %q moved to %q, got %q", testCase.path, testCase.expected, moved)
+		}
+	}
+}
+
+func TestOverlayFSSyncherIsRedirectSource(t *testing.T) {
+	syncher := &OverlayFSSyncher{
+		upperLayerPath: "/upper",
+		redirectSources: map[string]bool{
+			"/upper/a": true,
+		},
+	}
+
+	for path, expected := range map[string]bool{
+		"/upper/a":   true,
+		"/upper/a/x": true,
+		"/upper/ab":  false,
+		"/upper/b":   false,
+	} {
+		if source := syncher.isRedirectSource(path); source != expected {
+			t.Errorf("expected %t for %q, got %t", expected, path, source)
+		}
+	}
+}
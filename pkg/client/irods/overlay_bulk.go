@@ -0,0 +1,344 @@
+package irods
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+
+	irodsclient_fs "github.com/cyverse/go-irodsclient/fs"
+	irodsclient_types "github.com/cyverse/go-irodsclient/irods/types"
+	"github.com/rs/xid"
+	"golang.org/x/xerrors"
+	"k8s.io/klog"
+)
+
+const (
+	// max number of files in a bundle
+	overlayFSBundleFilesMax int = 50
+	// max size of files in a bundle in bytes
+	overlayFSBundleSizeMax int64  = 64 * 1024 * 1024
+	bundleFileSuffix       string = ".tar"
+)
+
+// overlayFSBundleEntry is a small file in the upper to upload in a bundle
+type overlayFSBundleEntry struct {
+	path string
+	d    fs.DirEntry
+	info fs.FileInfo
+}
+
+// overlayFSBundle packs small files in a dir of the upper to upload them in an archive extracted by iRODS
+// one upload and one extraction replace an upload per file
+type overlayFSBundle struct {
+	dirPath string
+	entries []*overlayFSBundleEntry
+	size    int64
+}
+
+func newOverlayFSBundle(dirPath string) *overlayFSBundle {
+	return &overlayFSBundle{
+		dirPath: dirPath,
+		entries: []*overlayFSBundleEntry{},
+	}
+}
+
+func (bundle *overlayFSBundle) add(path string, d fs.DirEntry, info fs.FileInfo) {
+	bundle.entries = append(bundle.entries, &overlayFSBundleEntry{
+		path: path,
+		d:    d,
+		info: info,
+	})
+	bundle.size += info.Size()
+}
+
+func (bundle *overlayFSBundle) isFull() bool {
+	return len(bundle.entries) >= overlayFSBundleFilesMax || bundle.size >= overlayFSBundleSizeMax
+}
+
+// getBundleableInfo returns the info of the file if it is small enough to upload in a bundle, nil otherwise
+func (syncher *OverlayFSSyncher) getBundleableInfo(d fs.DirEntry) fs.FileInfo {
+	threshold := syncher.irodsConnectionInfo.OverlayFSSyncBulkThreshold
+	if threshold <= 0 || !d.Type().IsRegular() {
+		return nil
+	}
+
+	info, err := d.Info()
+	if err != nil || info.Size() >= threshold {
+		return nil
+	}
+	return info
+}
+
+// scheduleBundle schedules the upload of files in the bundle
+func (syncher *OverlayFSSyncher) scheduleBundle(bundle *overlayFSBundle) {
+	if len(bundle.entries) == 0 {
+		return
+	}
+
+	bundleSyncTask := func(job *ParallelJob) error {
+		syncher.syncBundle(bundle)
+		return nil
+	}
+
+	taskName := fmt.Sprintf("sync bundle - %q (%d files) for volume %q", bundle.dirPath, len(bundle.entries), syncher.volumeID)
+	scheduleErr := syncher.parallelJobManager.Schedule(taskName, bundleSyncTask, 1)
+	if scheduleErr != nil {
+		klog.Errorf("failed to schedule bundle sync task for %q, volume %q, %s", bundle.dirPath, syncher.volumeID, scheduleErr)
+		syncher.failedEntries.Add(int64(len(bundle.entries)))
+	}
+}
+
+// syncFiles syncs the files one by one
+func (syncher *OverlayFSSyncher) syncFiles(entries []*overlayFSBundleEntry) {
+	for _, bundleEntry := range entries {
+		syncher.runSyncTask(bundleEntry.path, bundleEntry.d, "file", func(status *OverlayFSSyncEntryStatus) error {
+			return syncher.syncFile(bundleEntry.path, status)
+		})
+	}
+}
+
+// syncBundle uploads files in the bundle in an archive
+// files needing per-file handling, e.g., replacing dirs or conflicting, and files of failed bundles are synced one by one
+func (syncher *OverlayFSSyncher) syncBundle(bundle *overlayFSBundle) {
+	irodsDirPath, err := syncher.getIRODSPath(bundle.dirPath)
+	if err != nil || len(irodsDirPath) == 0 {
+		// not writable or unknown, handled per file
+		syncher.syncFiles(bundle.entries)
+		return
+	}
+
+	existingEntries, err := syncher.listIRODSDir(irodsDirPath)
+	if err != nil {
+		klog.Warningf("failed to list %q for bundle upload, volume %q, syncing files one by one, %s", irodsDirPath, syncher.volumeID, err)
+		syncher.syncFiles(bundle.entries)
+		return
+	}
+
+	bundled := []*overlayFSBundleEntry{}
+	unbundled := []*overlayFSBundleEntry{}
+	statuses := map[string]*OverlayFSSyncEntryStatus{}
+
+	for _, bundleEntry := range bundle.entries {
+		relPath, _ := filepath.Rel(syncher.upperLayerPath, bundleEntry.path)
+		status := &OverlayFSSyncEntryStatus{
+			Type:      "entry",
+			Kind:      "file",
+			Path:      relPath,
+			IRODSPath: path.Join(irodsDirPath, bundleEntry.d.Name()),
+			Action:    OverlayFSSyncActionCreate,
+		}
+
+		entry, ok := existingEntries[bundleEntry.d.Name()]
+		if ok {
+			if entry.IsDir() {
+				unbundled = append(unbundled, bundleEntry)
+				continue
+			}
+
+			unchanged, err := syncher.isFileUnchanged(bundleEntry.path, entry)
+			if err == nil && unchanged {
+				syncher.runSyncTask(bundleEntry.path, bundleEntry.d, "file", func(status *OverlayFSSyncEntryStatus) error {
+					status.IRODSPath = entry.Path
+					status.skip("same checksum")
+					return syncher.preserveAttrs(bundleEntry.path, entry.Path)
+				})
+				continue
+			}
+
+			switch syncher.resolveConflict(entry, status) {
+			case OverlayFSConflictKeepBoth, OverlayFSConflictSkip:
+				// applied per file
+				unbundled = append(unbundled, bundleEntry)
+				continue
+			}
+
+			status.Action = OverlayFSSyncActionOverwrite
+		}
+
+		bundled = append(bundled, bundleEntry)
+		statuses[bundleEntry.path] = status
+	}
+
+	syncher.syncFiles(unbundled)
+
+	if len(bundled) == 0 {
+		return
+	}
+
+	if len(bundled) == 1 {
+		// not worth an archive
+		syncher.syncFiles(bundled)
+		return
+	}
+
+	startTime := time.Now()
+	err = syncher.uploadBundle(bundled, irodsDirPath)
+	if err != nil {
+		klog.Warningf("failed to upload bundle of %d files to %q, volume %q, syncing files one by one, %s", len(bundled), irodsDirPath, syncher.volumeID, err)
+		syncher.syncFiles(bundled)
+		return
+	}
+
+	// verify extracted files, files not matching the local files are uploaded again
+	extractedEntries, err := syncher.listIRODSDir(irodsDirPath)
+	if err != nil {
+		klog.Warningf("failed to list %q to verify bundle upload, volume %q, syncing files one by one, %s", irodsDirPath, syncher.volumeID, err)
+		syncher.syncFiles(bundled)
+		return
+	}
+
+	duration := time.Since(startTime).Seconds() / float64(len(bundled))
+
+	for _, bundleEntry := range bundled {
+		status := statuses[bundleEntry.path]
+
+		entry, ok := extractedEntries[bundleEntry.d.Name()]
+		if !ok || entry.IsDir() || entry.Size != bundleEntry.info.Size() {
+			klog.Warningf("failed to verify %q extracted from bundle, volume %q, uploading it again", status.IRODSPath, syncher.volumeID)
+			syncher.syncFiles([]*overlayFSBundleEntry{bundleEntry})
+			continue
+		}
+
+		verified, err := syncher.verifyExtractedFile(bundleEntry.path, entry)
+		if err != nil || !verified {
+			klog.Warningf("failed to verify checksum of %q extracted from bundle, volume %q, uploading it again, %v", status.IRODSPath, syncher.volumeID, err)
+			syncher.syncFiles([]*overlayFSBundleEntry{bundleEntry})
+			continue
+		}
+
+		status.Time = startTime
+		status.Bytes = bundleEntry.info.Size()
+		status.DurationSeconds = duration
+		if len(status.Message) == 0 {
+			status.Message = "uploaded in a bundle"
+		}
+
+		err = syncher.preserveAttrs(bundleEntry.path, entry.Path)
+		syncher.reportEntry(status, err)
+		if err != nil {
+			klog.Errorf("failed to sync file %q, volume %q, %s", bundleEntry.path, syncher.volumeID, err)
+			continue
+		}
+
+		if baseline := syncher.getBaseline(); baseline != nil {
+			baseline.record(entry)
+		}
+
+		syncher.markDone(bundleEntry.path, bundleEntry.info)
+	}
+}
+
+// listIRODSDir returns entries in the iRODS dir by name
+func (syncher *OverlayFSSyncher) listIRODSDir(irodsDirPath string) (map[string]*irodsclient_fs.Entry, error) {
+	entries, err := syncher.irodsFsClient.List(irodsDirPath)
+	if err != nil {
+		return nil, xerrors.Errorf("failed to read dir %q: %w", irodsDirPath, err)
+	}
+
+	entryMap := map[string]*irodsclient_fs.Entry{}
+	for _, entry := range entries {
+		entryMap[entry.Name] = entry
+	}
+	return entryMap, nil
+}
+
+func (syncher *OverlayFSSyncher) getBundleFilePath() string {
+	return fmt.Sprintf("/%s/home/%s/.%s.csi.overlay.sync.bundle.%s%s", syncher.irodsConnectionInfo.ClientZoneName, syncher.irodsConnectionInfo.ClientUsername, syncher.volumeID, xid.New().String(), bundleFileSuffix)
+}
+
+// uploadBundle packs the files in a tar archive, uploads it, and extracts it to the iRODS dir, overwriting existing files
+func (syncher *OverlayFSSyncher) uploadBundle(entries []*overlayFSBundleEntry, irodsDirPath string) error {
+	localBundle, err := os.CreateTemp("", "irods-csi-bundle-*"+bundleFileSuffix)
+	if err != nil {
+		return xerrors.Errorf("failed to create bundle file: %w", err)
+	}
+	defer os.Remove(localBundle.Name())
+
+	err = writeBundle(localBundle, entries)
+	closeErr := localBundle.Close()
+	if err != nil {
+		return err
+	}
+	if closeErr != nil {
+		return xerrors.Errorf("failed to write bundle file %q: %w", localBundle.Name(), closeErr)
+	}
+
+	irodsBundlePath := syncher.getBundleFilePath()
+
+	klog.V(5).Infof("uploading bundle of %d files to %q", len(entries), irodsBundlePath)
+
+	err = syncher.uploadFile(localBundle.Name(), irodsBundlePath)
+	if err != nil {
+		return xerrors.Errorf("failed to upload bundle %q: %w", irodsBundlePath, err)
+	}
+
+	defer func() {
+		removeErr := syncher.irodsFsClient.RemoveFile(irodsBundlePath, true)
+		if removeErr != nil && !irodsclient_types.IsFileNotFoundError(removeErr) {
+			klog.Errorf("failed to remove bundle %q, %s", irodsBundlePath, removeErr)
+		}
+	}()
+
+	klog.V(5).Infof("extracting bundle %q to %q", irodsBundlePath, irodsDirPath)
+
+	err = syncher.irodsFsClient.ExtractStructFile(irodsBundlePath, irodsDirPath, "", irodsclient_types.TAR_FILE_DT, true, true)
+	if err != nil {
+		return xerrors.Errorf("failed to extract bundle %q to %q: %w", irodsBundlePath, irodsDirPath, err)
+	}
+	return nil
+}
+
+// writeBundle writes the files in a tar archive, with names relative to their dir
+func writeBundle(writer io.Writer, entries []*overlayFSBundleEntry) error {
+	tarWriter := tar.NewWriter(writer)
+
+	for _, bundleEntry := range entries {
+		err := writeBundleEntry(tarWriter, bundleEntry)
+		if err != nil {
+			return err
+		}
+	}
+
+	err := tarWriter.Close()
+	if err != nil {
+		return xerrors.Errorf("failed to write bundle: %w", err)
+	}
+	return nil
+}
+
+func writeBundleEntry(tarWriter *tar.Writer, bundleEntry *overlayFSBundleEntry) error {
+	file, err := os.Open(bundleEntry.path)
+	if err != nil {
+		return xerrors.Errorf("failed to open %q: %w", bundleEntry.path, err)
+	}
+	defer file.Close()
+
+	// the file may have changed after walk, the archive has the current content
+	info, err := file.Stat()
+	if err != nil {
+		return xerrors.Errorf("failed to stat %q: %w", bundleEntry.path, err)
+	}
+	bundleEntry.info = info
+
+	header, err := tar.FileInfoHeader(info, "")
+	if err != nil {
+		return xerrors.Errorf("failed to create bundle header for %q: %w", bundleEntry.path, err)
+	}
+	header.Name = bundleEntry.d.Name()
+
+	err = tarWriter.WriteHeader(header)
+	if err != nil {
+		return xerrors.Errorf("failed to write bundle header for %q: %w", bundleEntry.path, err)
+	}
+
+	_, err = io.CopyN(tarWriter, file, info.Size())
+	if err != nil {
+		return xerrors.Errorf("failed to write %q to bundle: %w", bundleEntry.path, err)
+	}
+	return nil
+}
@@ -0,0 +1,92 @@
+package irods
+
+import (
+	"bytes"
+	"os"
+
+	irodsclient_fs "github.com/cyverse/go-irodsclient/fs"
+	irodsclient_common "github.com/cyverse/go-irodsclient/irods/common"
+	irodsclient_irodsfs "github.com/cyverse/go-irodsclient/irods/fs"
+	irodsclient_types "github.com/cyverse/go-irodsclient/irods/types"
+	irodsclient_util "github.com/cyverse/go-irodsclient/irods/util"
+	"golang.org/x/xerrors"
+	"k8s.io/klog"
+)
+
+const (
+	// max attempts to upload a file if the checksum does not match after upload
+	overlayFSUploadAttemptsMax int = 3
+)
+
+// isFileUnchanged checks if the local file has the same content as the iRODS data object, by comparing checksums
+// returns false if the data object has no checksum to compare
+func (syncher *OverlayFSSyncher) isFileUnchanged(localPath string, entry *irodsclient_fs.Entry) (bool, error) {
+	if entry.IsDir() || len(entry.CheckSum) == 0 || entry.CheckSumAlgorithm == irodsclient_types.ChecksumAlgorithmUnknown {
+		return false, nil
+	}
+
+	stat, err := os.Stat(localPath)
+	if err != nil {
+		return false, xerrors.Errorf("failed to stat %q: %w", localPath, err)
+	}
+
+	if stat.Size() != entry.Size {
+		return false, nil
+	}
+
+	hash, err := irodsclient_util.HashLocalFile(localPath, string(entry.CheckSumAlgorithm))
+	if err != nil {
+		return false, xerrors.Errorf("failed to get %q hash of %q: %w", entry.CheckSumAlgorithm, localPath, err)
+	}
+
+	return bytes.Equal(hash, entry.CheckSum), nil
+}
+
+// verifyExtractedFile checks if the data object extracted from a bundle has the same content as the local file
+// extraction does not compute checksums, iRODS computes the checksum of the data object on request
+// the checksum is set to the entry on match
+func (syncher *OverlayFSSyncher) verifyExtractedFile(localPath string, entry *irodsclient_fs.Entry) (bool, error) {
+	conn, err := syncher.irodsFsClient.GetMetadataConnection()
+	if err != nil {
+		return false, xerrors.Errorf("failed to get connection: %w", err)
+	}
+	defer syncher.irodsFsClient.ReturnMetadataConnection(conn)
+
+	checksum, err := irodsclient_irodsfs.GetDataObjectChecksum(conn, entry.Path, "")
+	if err != nil {
+		return false, xerrors.Errorf("failed to get checksum of %q: %w", entry.Path, err)
+	}
+
+	hash, err := irodsclient_util.HashLocalFile(localPath, string(checksum.Algorithm))
+	if err != nil {
+		return false, xerrors.Errorf("failed to get %q hash of %q: %w", checksum.Algorithm, localPath, err)
+	}
+
+	if !bytes.Equal(hash, checksum.Checksum) {
+		return false, nil
+	}
+
+	entry.CheckSumAlgorithm = checksum.Algorithm
+	entry.CheckSum = checksum.Checksum
+	return true, nil
+}
+
+// uploadFile uploads the local file, iRODS verifies the checksum of the data object against the local file
+// uploads are retried if the checksum does not match, e.g., the file changed or got corrupted in transfer
+func (syncher *OverlayFSSyncher) uploadFile(localPath string, irodsPath string) error {
+	var err error
+	for attempt := 1; attempt <= overlayFSUploadAttemptsMax; attempt++ {
+		_, err = syncher.irodsFsClient.UploadFileRedirectToResource(localPath, irodsPath, "", 0, false, true, true, false, nil)
+		if err == nil {
+			return nil
+		}
+
+		if irodsclient_types.GetIRODSErrorCode(err) != irodsclient_common.USER_CHKSUM_MISMATCH {
+			return err
+		}
+
+		klog.Warningf("checksum mismatch after upload of %q to %q (attempt %d/%d), %s", localPath, irodsPath, attempt, overlayFSUploadAttemptsMax, err)
+	}
+
+	return xerrors.Errorf("failed to upload %q to %q, checksum mismatch after %d attempts: %w", localPath, irodsPath, overlayFSUploadAttemptsMax, err)
+}
@@ -0,0 +1,101 @@
+package irods
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/pkg/xattr"
+	"k8s.io/klog"
+)
+
+// overlay and fuse-overlayfs mark deletes and dir replacements in the upper in different formats
+// - kernel overlay: char device 0/0 whiteouts, and the opaque xattr on dirs
+// - fuse-overlayfs: the same if privileged, or ".wh.<name>" files and ".wh..wh..opq" markers in dirs if xattrs are unavailable
+// files named ".wh.<name>" are whiteouts only in uppers written by fuse-overlayfs, with kernel overlay they are user data
+const (
+	overlayFSWhiteoutPrefix string = ".wh."
+	overlayFSOpaqueWhiteout string = ".wh..wh..opq"
+)
+
+// xattrs marking opaque dirs, by kernel overlay, unprivileged overlay and fuse-overlayfs
+var overlayFSOpaqueXAttrs = []string{
+	"trusted.overlay.opaque",
+	"user.overlay.opaque",
+	"user.fuseoverlayfs.opaque",
+}
+
+// isCharDeviceWhiteout checks if the entry is a char device with 0/0 device number
+func isCharDeviceWhiteout(d fs.DirEntry) bool {
+	if d.Type()&os.ModeCharDevice == 0 {
+		return false
+	}
+
+	info, err := d.Info()
+	if err != nil {
+		return false
+	}
+
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		return stat.Rdev == 0
+	}
+	return true
+}
+
+// hasWhiteoutFiles checks if the upper was written by fuse-overlayfs, which may use whiteout files
+func (syncher *OverlayFSSyncher) hasWhiteoutFiles() bool {
+	return syncher.irodsConnectionInfo.OverlayFSDriver == FuseOverlayFSDriverType
+}
+
+// isWhiteoutFile checks if the entry is a ".wh.<name>" file of fuse-overlayfs
+func (syncher *OverlayFSSyncher) isWhiteoutFile(path string, d fs.DirEntry) bool {
+	if !syncher.hasWhiteoutFiles() || !d.Type().IsRegular() {
+		return false
+	}
+
+	filename := filepath.Base(path)
+	return strings.HasPrefix(filename, overlayFSWhiteoutPrefix) && filename != overlayFSOpaqueWhiteout
+}
+
+// isWhiteout checks if the entry marks a delete of an entry in the lower, in the formats of the driver
+func (syncher *OverlayFSSyncher) isWhiteout(path string, d fs.DirEntry) bool {
+	return isCharDeviceWhiteout(d) || syncher.isWhiteoutFile(path, d)
+}
+
+// getWhiteoutTarget returns the path of the entry deleted by the whiteout
+func (syncher *OverlayFSSyncher) getWhiteoutTarget(path string) string {
+	filename := filepath.Base(path)
+	if !syncher.hasWhiteoutFiles() || !strings.HasPrefix(filename, overlayFSWhiteoutPrefix) {
+		// char device whiteouts have the name of the entry deleted
+		return path
+	}
+
+	return filepath.Join(filepath.Dir(path), strings.TrimPrefix(filename, overlayFSWhiteoutPrefix))
+}
+
+// isOpaqueDir checks if the dir in the upper hides entries in the lower
+func (syncher *OverlayFSSyncher) isOpaqueDir(path string) bool {
+	for _, opaqueXAttr := range overlayFSOpaqueXAttrs {
+		xattrVal, err := xattr.Get(path, opaqueXAttr)
+		if err != nil {
+			continue
+		}
+
+		xattrValStr := string(xattrVal)
+		klog.V(5).Infof("xattr for path %q: %q = %q", path, opaqueXAttr, xattrValStr)
+
+		if strings.ToLower(xattrValStr) == "y" {
+			return true
+		}
+	}
+
+	if !syncher.hasWhiteoutFiles() {
+		return false
+	}
+
+	// fuse-overlayfs without xattrs
+	_, err := os.Lstat(filepath.Join(path, overlayFSOpaqueWhiteout))
+	return err == nil
+}
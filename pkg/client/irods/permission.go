@@ -0,0 +1,218 @@
+package irods
+
+import (
+	"context"
+	"path"
+
+	irodsclient_fs "github.com/cyverse/go-irodsclient/fs"
+	irodsclient_types "github.com/cyverse/go-irodsclient/irods/types"
+	"github.com/cyverse/irods-csi-driver/pkg/common"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/klog"
+)
+
+const (
+	// every user is a member of the public group
+	irodsPublicGroup string = "public"
+)
+
+// irodsAccessLevelOrder lists access levels from the lowest to the highest
+// a higher level includes permissions of lower levels
+var irodsAccessLevelOrder = []irodsclient_types.IRODSAccessLevelType{
+	irodsclient_types.IRODSAccessLevelNull,
+	irodsclient_types.IRODSAccessLevelExecute,
+	irodsclient_types.IRODSAccessLevelReadAnnotation,
+	irodsclient_types.IRODSAccessLevelReadSystemMetadata,
+	irodsclient_types.IRODSAccessLevelReadMetadata,
+	irodsclient_types.IRODSAccessLevelReadObject,
+	irodsclient_types.IRODSAccessLevelWriteAnnotation,
+	irodsclient_types.IRODSAccessLevelCreateMetadata,
+	irodsclient_types.IRODSAccessLevelModifyMetadata,
+	irodsclient_types.IRODSAccessLevelDeleteMetadata,
+	irodsclient_types.IRODSAccessLevelAdministerObject,
+	irodsclient_types.IRODSAccessLevelCreateObject,
+	irodsclient_types.IRODSAccessLevelModifyObject,
+	irodsclient_types.IRODSAccessLevelDeleteObject,
+	irodsclient_types.IRODSAccessLevelCreateToken,
+	irodsclient_types.IRODSAccessLevelDeleteToken,
+	irodsclient_types.IRODSAccessLevelCurate,
+	irodsclient_types.IRODSAccessLevelOwner,
+}
+
+func getAccessLevelRank(level irodsclient_types.IRODSAccessLevelType) int {
+	for idx, l := range irodsAccessLevelOrder {
+		if l == level {
+			return idx
+		}
+	}
+	return 0
+}
+
+// CheckVolumePermissions checks that the user can read all path mappings of the volume, and write to them unless readOnly
+// it is used for writable publishes of shared mounts, whose write access is not checked at mount
+func CheckVolumePermissions(ctx context.Context, configs map[string]string, readOnly bool) error {
+	irodsConnectionInfo, err := GetConnectionInfo(configs)
+	if err != nil {
+		return err
+	}
+
+	return checkPermissions(ctx, irodsConnectionInfo, readOnly || irodsConnectionInfo.Readonly)
+}
+
+// checkPermissions runs CheckPermissions, converting errors to grpc status
+func checkPermissions(ctx context.Context, conn *IRODSFSConnectionInfo, readOnly bool) error {
+	klog.V(5).Infof("Checking iRODS permissions of path mappings (read-only %t)", readOnly)
+	err := CheckPermissions(ctx, conn, readOnly)
+	if err != nil {
+		if ctxErr := common.GetContextStatusError(ctx, "Checking iRODS permissions was interrupted"); ctxErr != nil {
+			return ctxErr
+		}
+		return GetStatusError(err, "Could not access iRODS paths with given access parameters")
+	}
+	return nil
+}
+
+// CheckPermissions checks that the user can read all path mappings, and write to them unless the mount is read-only
+// returns PermissionDenied if ACLs do not allow the access, NotFound if a path does not exist
+func CheckPermissions(ctx context.Context, conn *IRODSFSConnectionInfo, readOnly bool) error {
+	account := GetIRODSAccount(conn)
+
+	return runWithRetry(ctx, getHostKey(conn), func() error {
+		filesystem, err := GetIRODSFilesystem(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		defer filesystem.Release()
+
+		checker := &permissionChecker{
+			filesystem: filesystem,
+			username:   account.ClientUser,
+			zone:       account.ClientZone,
+			// access via tickets is not granted by ACLs
+			checkACLs: len(account.Ticket) == 0,
+		}
+
+		if checker.checkACLs {
+			user, err := filesystem.GetUser(account.ClientUser, account.ClientZone, irodsclient_types.IRODSUserRodsUser)
+			if err != nil {
+				return err
+			}
+
+			if user.Type == irodsclient_types.IRODSUserRodsAdmin {
+				// admins can access everything
+				checker.checkACLs = false
+			}
+		}
+
+		for _, mapping := range conn.PathMappings {
+			writable := !readOnly && !mapping.ReadOnly
+
+			err = checker.check(mapping.IRODSPath, writable, mapping.CreateDir, mapping.IgnoreNotExistError)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// permissionChecker checks ACLs of the user
+type permissionChecker struct {
+	filesystem *irodsclient_fs.FileSystem
+	username   string
+	zone       string
+	checkACLs  bool
+
+	// names of the user and groups the user belongs to
+	principals map[string]bool
+}
+
+func (checker *permissionChecker) check(irodsPath string, writable bool, createDir bool, ignoreNotExist bool) error {
+	accessDesc := "read"
+	if writable {
+		accessDesc = "read and write"
+	}
+
+	_, err := checker.filesystem.Stat(irodsPath)
+	if err != nil {
+		if !irodsclient_types.IsFileNotFoundError(err) {
+			return err
+		}
+
+		if ignoreNotExist {
+			klog.V(5).Infof("Skipping permission check of %q, path does not exist", irodsPath)
+			return nil
+		}
+
+		if createDir {
+			// irodsfs creates the dir in the parent
+			return checker.check(path.Dir(irodsPath), true, false, false)
+		}
+
+		return status.Errorf(codes.NotFound, "iRODS path %q does not exist", irodsPath)
+	}
+
+	if !checker.checkACLs {
+		return nil
+	}
+
+	level, err := checker.getAccessLevel(irodsPath)
+	if err != nil {
+		return err
+	}
+
+	required := irodsclient_types.IRODSAccessLevelReadObject
+	if writable {
+		required = irodsclient_types.IRODSAccessLevelModifyObject
+	}
+
+	if getAccessLevelRank(level) < getAccessLevelRank(required) {
+		return status.Errorf(codes.PermissionDenied, "iRODS user %q has %q access to %q, but the volume requires %s access, mount the volume read-only or grant %q access", checker.username, level, irodsPath, accessDesc, required.ChmodString())
+	}
+
+	return nil
+}
+
+// getAccessLevel returns the highest access level granted to the user directly or via groups
+func (checker *permissionChecker) getAccessLevel(irodsPath string) (irodsclient_types.IRODSAccessLevelType, error) {
+	if checker.principals == nil {
+		groups, err := checker.filesystem.ListUserGroupNames(checker.zone, checker.username)
+		if err != nil {
+			return irodsclient_types.IRODSAccessLevelNull, err
+		}
+
+		checker.principals = map[string]bool{
+			checker.username: true,
+			irodsPublicGroup: true,
+		}
+
+		for _, group := range groups {
+			checker.principals[group] = true
+		}
+	}
+
+	accesses, err := checker.filesystem.ListACLs(irodsPath)
+	if err != nil {
+		return irodsclient_types.IRODSAccessLevelNull, err
+	}
+
+	level := irodsclient_types.IRODSAccessLevelNull
+	for _, access := range accesses {
+		if len(access.UserZone) > 0 && access.UserZone != checker.zone {
+			continue
+		}
+
+		if !checker.principals[access.UserName] {
+			continue
+		}
+
+		if getAccessLevelRank(access.AccessLevel) > getAccessLevelRank(level) {
+			level = access.AccessLevel
+		}
+	}
+
+	return level, nil
+}
@@ -0,0 +1,236 @@
+package irods
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/cyverse/irods-csi-driver/pkg/common"
+	"golang.org/x/xerrors"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/klog"
+)
+
+const (
+	defaultRetryMaxAttempts        int           = 3
+	defaultRetryInitialBackoff     time.Duration = 1 * time.Second
+	defaultRetryMaxBackoff         time.Duration = 10 * time.Second
+	defaultCircuitBreakerThreshold int           = 5
+	defaultCircuitBreakerCooldown  time.Duration = 30 * time.Second
+)
+
+// RetryPolicy is a policy to retry iRODS operations on transient errors and to fast-fail while the server is down
+type RetryPolicy struct {
+	MaxAttempts    int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	// number of consecutive transient failures to open the circuit breaker of the host
+	CircuitBreakerThreshold int
+	// time to fast-fail after the circuit breaker opens, then a single trial operation is allowed at a time
+	CircuitBreakerCooldown time.Duration
+}
+
+// NewDefaultRetryPolicy creates a new RetryPolicy with default values
+func NewDefaultRetryPolicy() *RetryPolicy {
+	return &RetryPolicy{
+		MaxAttempts:             defaultRetryMaxAttempts,
+		InitialBackoff:          defaultRetryInitialBackoff,
+		MaxBackoff:              defaultRetryMaxBackoff,
+		CircuitBreakerThreshold: defaultCircuitBreakerThreshold,
+		CircuitBreakerCooldown:  defaultCircuitBreakerCooldown,
+	}
+}
+
+// NewRetryPolicyFromConfigs creates a new RetryPolicy from driver configs
+func NewRetryPolicyFromConfigs(configs map[string]string) (*RetryPolicy, error) {
+	policy := NewDefaultRetryPolicy()
+
+	for k, v := range configs {
+		var err error
+		switch common.NormalizeConfigKey(k) {
+		case common.NormalizeConfigKey("irods_retry_max_attempts"):
+			policy.MaxAttempts, err = strconv.Atoi(v)
+		case common.NormalizeConfigKey("irods_retry_initial_backoff"):
+			policy.InitialBackoff, err = time.ParseDuration(v)
+		case common.NormalizeConfigKey("irods_retry_max_backoff"):
+			policy.MaxBackoff, err = time.ParseDuration(v)
+		case common.NormalizeConfigKey("irods_circuit_breaker_threshold"):
+			policy.CircuitBreakerThreshold, err = strconv.Atoi(v)
+		case common.NormalizeConfigKey("irods_circuit_breaker_cooldown"):
+			policy.CircuitBreakerCooldown, err = time.ParseDuration(v)
+		default:
+			// ignore
+		}
+
+		if err != nil {
+			return nil, xerrors.Errorf("failed to parse %q value %q: %w", k, v, err)
+		}
+	}
+
+	if policy.InitialBackoff < 0 {
+		return nil, xerrors.Errorf("irods_retry_initial_backoff must not be negative, got %v", policy.InitialBackoff)
+	}
+
+	if policy.MaxBackoff < 0 {
+		return nil, xerrors.Errorf("irods_retry_max_backoff must not be negative, got %v", policy.MaxBackoff)
+	}
+
+	if policy.CircuitBreakerThreshold < 0 {
+		return nil, xerrors.Errorf("irods_circuit_breaker_threshold must not be negative, got %d", policy.CircuitBreakerThreshold)
+	}
+
+	if policy.CircuitBreakerCooldown < 0 {
+		return nil, xerrors.Errorf("irods_circuit_breaker_cooldown must not be negative, got %v", policy.CircuitBreakerCooldown)
+	}
+
+	if policy.MaxAttempts < 1 {
+		policy.MaxAttempts = 1
+	}
+
+	return policy, nil
+}
+
+// circuitBreaker tracks consecutive transient failures of a host
+type circuitBreaker struct {
+	failures  int
+	openUntil time.Time
+	// a trial operation is running after cooldown, others fast-fail until it reports
+	probing bool
+}
+
+var (
+	retryPolicy     = NewDefaultRetryPolicy()
+	circuitBreakers = map[string]*circuitBreaker{}
+	retryMutex      sync.Mutex
+)
+
+// SetRetryPolicy sets the retry policy for iRODS operations
+func SetRetryPolicy(policy *RetryPolicy) {
+	retryMutex.Lock()
+	defer retryMutex.Unlock()
+
+	retryPolicy = policy
+}
+
+func getRetryPolicy() *RetryPolicy {
+	retryMutex.Lock()
+	defer retryMutex.Unlock()
+
+	return retryPolicy
+}
+
+// checkCircuitBreaker returns the time the circuit breaker of the host is open until, zero if closed
+// after cooldown, the circuit breaker is half-open and lets one caller through as a trial operation, returning true for it
+func checkCircuitBreaker(host string) (time.Time, bool) {
+	retryMutex.Lock()
+	defer retryMutex.Unlock()
+
+	breaker, ok := circuitBreakers[host]
+	if !ok || breaker.openUntil.IsZero() {
+		return time.Time{}, false
+	}
+
+	now := time.Now()
+	if now.Before(breaker.openUntil) {
+		return breaker.openUntil, false
+	}
+
+	if breaker.probing {
+		// retry after the trial operation reports
+		return now, false
+	}
+
+	breaker.probing = true
+	return time.Time{}, true
+}
+
+// releaseProbe lets another caller try if the trial operation ended without a result, e.g., its context was canceled
+func releaseProbe(host string) {
+	retryMutex.Lock()
+	defer retryMutex.Unlock()
+
+	if breaker, ok := circuitBreakers[host]; ok {
+		breaker.probing = false
+	}
+}
+
+func reportSuccess(host string) {
+	retryMutex.Lock()
+	defer retryMutex.Unlock()
+
+	delete(circuitBreakers, host)
+}
+
+func reportTransientFailure(host string) {
+	retryMutex.Lock()
+	defer retryMutex.Unlock()
+
+	breaker, ok := circuitBreakers[host]
+	if !ok {
+		breaker = &circuitBreaker{}
+		circuitBreakers[host] = breaker
+	}
+
+	breaker.probing = false
+	breaker.failures++
+	if retryPolicy.CircuitBreakerThreshold > 0 && breaker.failures >= retryPolicy.CircuitBreakerThreshold {
+		if time.Now().After(breaker.openUntil) {
+			klog.Warningf("iRODS server %q is unavailable after %d consecutive failures, fast-failing for %v", host, breaker.failures, retryPolicy.CircuitBreakerCooldown)
+		}
+		breaker.openUntil = time.Now().Add(retryPolicy.CircuitBreakerCooldown)
+	}
+}
+
+// runWithRetry runs the operation against the host, retrying on transient errors with exponential backoff
+// it fast-fails with Unavailable while the circuit breaker of the host is open
+func runWithRetry(ctx context.Context, host string, operation func() error) error {
+	policy := getRetryPolicy()
+	backoff := policy.InitialBackoff
+
+	for attempt := 1; ; attempt++ {
+		openUntil, probe := checkCircuitBreaker(host)
+		if !openUntil.IsZero() {
+			return status.Errorf(codes.Unavailable, "iRODS server %q is unavailable, retry after %v", host, openUntil.Format(time.RFC3339))
+		}
+
+		err := runWithContext(ctx, operation)
+		if err == nil {
+			reportSuccess(host)
+			return nil
+		}
+
+		if ctx.Err() != nil {
+			if probe {
+				releaseProbe(host)
+			}
+			return err
+		}
+
+		if !IsTransientError(err) {
+			// the server responded
+			reportSuccess(host)
+			return err
+		}
+
+		reportTransientFailure(host)
+
+		if attempt >= policy.MaxAttempts {
+			return err
+		}
+
+		klog.V(4).Infof("Retrying iRODS operation on %q in %v after transient error (attempt %d/%d) - %v", host, backoff, attempt, policy.MaxAttempts, err)
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+
+		backoff *= 2
+		if backoff > policy.MaxBackoff {
+			backoff = policy.MaxBackoff
+		}
+	}
+}
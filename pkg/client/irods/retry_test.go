@@ -0,0 +1,102 @@
+package irods
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestCircuitBreakerHalfOpenAllowsOneProbe(t *testing.T) {
+	SetRetryPolicy(&RetryPolicy{
+		MaxAttempts:             1,
+		CircuitBreakerThreshold: 1,
+		CircuitBreakerCooldown:  10 * time.Millisecond,
+	})
+	defer SetRetryPolicy(NewDefaultRetryPolicy())
+
+	host := "probe.test:1247"
+	defer reportSuccess(host)
+
+	err := runWithRetry(context.Background(), host, func() error {
+		return &net.OpError{Op: "dial", Err: context.DeadlineExceeded}
+	})
+	if err == nil || status.Code(err) == codes.Unavailable {
+		t.Fatalf("expected the transient error, got %v", err)
+	}
+
+	// open
+	err = runWithRetry(context.Background(), host, func() error { return nil })
+	if status.Code(err) != codes.Unavailable {
+		t.Fatalf("expected %s while open, got %v", codes.Unavailable, err)
+	}
+
+	time.Sleep(20 * time.Millisecond)
+
+	// half-open, the probe is blocked until released
+	probeStarted := make(chan struct{})
+	unblock := make(chan struct{})
+	probeDone := make(chan error)
+	go func() {
+		probeDone <- runWithRetry(context.Background(), host, func() error {
+			close(probeStarted)
+			<-unblock
+			return nil
+		})
+	}()
+	<-probeStarted
+
+	err = runWithRetry(context.Background(), host, func() error { return nil })
+	if status.Code(err) != codes.Unavailable {
+		t.Errorf("expected %s while probing, got %v", codes.Unavailable, err)
+	}
+
+	close(unblock)
+	if err := <-probeDone; err != nil {
+		t.Fatalf("expected the probe to succeed, got %v", err)
+	}
+
+	// closed
+	err = runWithRetry(context.Background(), host, func() error { return nil })
+	if err != nil {
+		t.Errorf("expected success after the probe, got %v", err)
+	}
+}
+
+func TestCircuitBreakerReleasesCanceledProbe(t *testing.T) {
+	SetRetryPolicy(&RetryPolicy{
+		MaxAttempts:             1,
+		CircuitBreakerThreshold: 1,
+		CircuitBreakerCooldown:  10 * time.Millisecond,
+	})
+	defer SetRetryPolicy(NewDefaultRetryPolicy())
+
+	host := "canceled-probe.test:1247"
+	defer reportSuccess(host)
+
+	reportTransientFailure(host)
+	time.Sleep(20 * time.Millisecond)
+
+	// the probe is abandoned when its context is canceled
+	ctx, cancel := context.WithCancel(context.Background())
+	unblock := make(chan struct{})
+	defer close(unblock)
+
+	err := runWithRetry(ctx, host, func() error {
+		cancel()
+		<-unblock
+		return nil
+	})
+	if err != context.Canceled {
+		t.Fatalf("expected %v, got %v", context.Canceled, err)
+	}
+
+	// the next caller becomes the probe
+	err = runWithRetry(context.Background(), host, func() error { return nil })
+	if err != nil {
+		t.Errorf("expected success after the canceled probe, got %v", err)
+	}
+}
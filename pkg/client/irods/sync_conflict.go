@@ -0,0 +1,283 @@
+package irods
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	irodsclient_fs "github.com/cyverse/go-irodsclient/fs"
+	irodsclient_types "github.com/cyverse/go-irodsclient/irods/types"
+	"golang.org/x/xerrors"
+	"k8s.io/klog"
+)
+
+// OverlayFSConflictPolicy is a policy to apply when iRODS data was changed by others while the volume was mounted
+type OverlayFSConflictPolicy string
+
+// overlayfs conflict policies
+const (
+	// OverlayFSConflictOverwrite overwrites changes in iRODS with changes in the upper
+	OverlayFSConflictOverwrite OverlayFSConflictPolicy = "overwrite"
+	// OverlayFSConflictKeepBoth keeps changes in iRODS, and stores changes in the upper with a conflict suffix
+	OverlayFSConflictKeepBoth OverlayFSConflictPolicy = "keep_both"
+	// OverlayFSConflictSkip keeps changes in iRODS, and keeps the upper to resolve conflicts manually
+	OverlayFSConflictSkip OverlayFSConflictPolicy = "skip"
+)
+
+const (
+	// max number of iRODS entries recorded at mount time, the mount time is used for entries not recorded
+	overlayFSSyncBaselineEntriesMax int = 100000
+)
+
+// GetOverlayFSConflictPolicy returns valid conflict policy
+// unknown policies are rejected, not to overwrite changes in iRODS by a typo
+func GetOverlayFSConflictPolicy(policy string) (OverlayFSConflictPolicy, error) {
+	switch strings.ReplaceAll(strings.ToLower(policy), "-", "_") {
+	case string(OverlayFSConflictOverwrite):
+		return OverlayFSConflictOverwrite, nil
+	case string(OverlayFSConflictKeepBoth):
+		return OverlayFSConflictKeepBoth, nil
+	case string(OverlayFSConflictSkip):
+		return OverlayFSConflictSkip, nil
+	default:
+		return "", xerrors.Errorf("unknown conflict policy %q, must be one of %q, %q or %q", policy, OverlayFSConflictOverwrite, OverlayFSConflictKeepBoth, OverlayFSConflictSkip)
+	}
+}
+
+// OverlayFSSyncBaselineEntry is a state of an iRODS entry at mount time
+type OverlayFSSyncBaselineEntry struct {
+	ModifyTime time.Time `yaml:"modify_time" json:"modify_time"`
+	CheckSum   []byte    `yaml:"checksum,omitempty" json:"checksum,omitempty"`
+}
+
+// OverlayFSSyncBaseline records states of iRODS entries at mount time to detect changes by others
+// entries are updated when the syncher changes them, so its own changes are not conflicts
+type OverlayFSSyncBaseline struct {
+	MountTime time.Time                              `yaml:"mount_time" json:"mount_time"`
+	Entries   map[string]*OverlayFSSyncBaselineEntry `yaml:"entries" json:"entries"`
+	// paths removed by the syncher
+	Removed map[string]bool `yaml:"removed,omitempty" json:"removed,omitempty"`
+
+	mutex sync.Mutex
+}
+
+// NewOverlayFSSyncBaseline creates a new OverlayFSSyncBaseline without entries
+func NewOverlayFSSyncBaseline(mountTime time.Time) *OverlayFSSyncBaseline {
+	return &OverlayFSSyncBaseline{
+		MountTime: mountTime,
+		Entries:   map[string]*OverlayFSSyncBaselineEntry{},
+		Removed:   map[string]bool{},
+	}
+}
+
+// Snapshot returns a copy of the baseline, to persist while it is being updated
+func (baseline *OverlayFSSyncBaseline) Snapshot() *OverlayFSSyncBaseline {
+	baseline.mutex.Lock()
+	defer baseline.mutex.Unlock()
+
+	snapshot := NewOverlayFSSyncBaseline(baseline.MountTime)
+	for irodsPath, entry := range baseline.Entries {
+		snapshot.Entries[irodsPath] = entry
+	}
+	for irodsPath := range baseline.Removed {
+		snapshot.Removed[irodsPath] = true
+	}
+	return snapshot
+}
+
+// record records the state of the entry, called at mount time and after the syncher changes the entry
+func (baseline *OverlayFSSyncBaseline) record(entry *irodsclient_fs.Entry) {
+	baseline.mutex.Lock()
+	defer baseline.mutex.Unlock()
+
+	if baseline.Entries == nil {
+		baseline.Entries = map[string]*OverlayFSSyncBaselineEntry{}
+	}
+
+	baseline.Entries[entry.Path] = &OverlayFSSyncBaselineEntry{
+		ModifyTime: entry.ModifyTime,
+		CheckSum:   entry.CheckSum,
+	}
+	delete(baseline.Removed, entry.Path)
+}
+
+// recordRemoved records the entry removed by the syncher
+func (baseline *OverlayFSSyncBaseline) recordRemoved(irodsPath string) {
+	baseline.mutex.Lock()
+	defer baseline.mutex.Unlock()
+
+	if baseline.Removed == nil {
+		baseline.Removed = map[string]bool{}
+	}
+
+	for p := range baseline.Entries {
+		if p == irodsPath || strings.HasPrefix(p, irodsPath+"/") {
+			delete(baseline.Entries, p)
+		}
+	}
+	baseline.Removed[irodsPath] = true
+}
+
+// isChanged checks if the entry was changed by others after mount
+func (baseline *OverlayFSSyncBaseline) isChanged(entry *irodsclient_fs.Entry) bool {
+	baseline.mutex.Lock()
+	defer baseline.mutex.Unlock()
+
+	if expected, ok := baseline.Entries[entry.Path]; ok {
+		if expected.ModifyTime.Equal(entry.ModifyTime) {
+			return false
+		}
+
+		// touched, but the content is the same
+		if !entry.IsDir() && len(expected.CheckSum) > 0 && bytes.Equal(expected.CheckSum, entry.CheckSum) {
+			return false
+		}
+		return true
+	}
+
+	if baseline.Removed[entry.Path] {
+		// created by others after the syncher removed it
+		return true
+	}
+
+	// not recorded at mount time, created or changed after mount
+	// this relies on clocks of the node and the iRODS server
+	return entry.ModifyTime.After(baseline.MountTime)
+}
+
+// capture records states of iRODS entries under the path mappings
+// stops early if stopChan is closed or too many entries, entries not recorded are checked against the mount time
+func (baseline *OverlayFSSyncBaseline) capture(irodsConnectionInfo *IRODSFSConnectionInfo, stopChan <-chan struct{}) error {
+	filesystem, err := GetIRODSFilesystem(context.Background(), irodsConnectionInfo)
+	if err != nil {
+		return err
+	}
+	defer filesystem.Release()
+
+	count := 0
+	var walk func(irodsPath string) error
+	walk = func(irodsPath string) error {
+		select {
+		case <-stopChan:
+			return nil
+		default:
+		}
+
+		if count >= overlayFSSyncBaselineEntriesMax {
+			return nil
+		}
+
+		entries, err := filesystem.List(irodsPath)
+		if err != nil {
+			return err
+		}
+
+		for _, entry := range entries {
+			if count >= overlayFSSyncBaselineEntriesMax {
+				klog.V(4).Infof("Recorded %d iRODS entries at mount time, using the mount time for others", count)
+				return nil
+			}
+
+			baseline.record(entry)
+			count++
+
+			if entry.IsDir() {
+				err = walk(entry.Path)
+				if err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+
+	for _, mapping := range irodsConnectionInfo.PathMappings {
+		if mapping.ReadOnly {
+			continue
+		}
+
+		entry, err := filesystem.Stat(mapping.IRODSPath)
+		if err != nil {
+			if irodsclient_types.IsFileNotFoundError(err) {
+				continue
+			}
+			return err
+		}
+
+		baseline.record(entry)
+		count++
+
+		if entry.IsDir() {
+			err = walk(entry.Path)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// getConflictPath returns a path to store changes in the upper conflicting with changes in iRODS
+// e.g., /zone/home/user/data.csv to /zone/home/user/data.conflict-20240101T000000.csv
+func getConflictPath(irodsPath string, now time.Time) string {
+	dir := path.Dir(irodsPath)
+	name := path.Base(irodsPath)
+
+	ext := path.Ext(name)
+	if ext == name {
+		// dot file, e.g., .bashrc
+		ext = ""
+	}
+
+	base := strings.TrimSuffix(name, ext)
+	return path.Join(dir, fmt.Sprintf("%s.conflict-%s%s", base, now.UTC().Format("20060102T150405"), ext))
+}
+
+// overlayFSConflictDirs has dirs in the upper stored in iRODS with a conflict suffix
+// entries in the dirs are synced under the iRODS paths with the suffix
+type overlayFSConflictDirs struct {
+	// path relative to the upper -> iRODS path
+	dirs  map[string]string
+	mutex sync.Mutex
+}
+
+func newOverlayFSConflictDirs() *overlayFSConflictDirs {
+	return &overlayFSConflictDirs{
+		dirs: map[string]string{},
+	}
+}
+
+func (conflictDirs *overlayFSConflictDirs) set(relPath string, irodsPath string) {
+	conflictDirs.mutex.Lock()
+	defer conflictDirs.mutex.Unlock()
+
+	conflictDirs.dirs[filepath.Clean(relPath)] = irodsPath
+}
+
+// getIRODSPath returns the iRODS path of the entry in a dir stored with a conflict suffix, empty if not in such dirs
+func (conflictDirs *overlayFSConflictDirs) getIRODSPath(relPath string) string {
+	conflictDirs.mutex.Lock()
+	defer conflictDirs.mutex.Unlock()
+
+	if len(conflictDirs.dirs) == 0 {
+		return ""
+	}
+
+	relPath = filepath.Clean(relPath)
+	for dirPath := relPath; dirPath != "." && dirPath != "/"; dirPath = filepath.Dir(dirPath) {
+		if irodsDirPath, ok := conflictDirs.dirs[dirPath]; ok {
+			subPath, err := filepath.Rel(dirPath, relPath)
+			if err != nil {
+				return ""
+			}
+			return path.Join(irodsDirPath, filepath.ToSlash(subPath))
+		}
+	}
+	return ""
+}
@@ -0,0 +1,170 @@
+package irods
+
+import (
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.org/x/xerrors"
+	"k8s.io/klog"
+)
+
+// overlayFSSyncPattern is a gitignore-style pattern
+type overlayFSSyncPattern struct {
+	pattern string
+	// "!pattern", includes paths excluded by previous patterns
+	include bool
+	// "pattern/", matches dirs only
+	dirOnly bool
+	// segments split by "/", "**" matches zero or more segments
+	segments []string
+}
+
+// overlayFSSyncFilter excludes paths in the upper from sync with gitignore-style patterns
+// the last pattern matching a path decides, entries in excluded dirs are excluded and cannot be included again
+type overlayFSSyncFilter struct {
+	patterns []overlayFSSyncPattern
+}
+
+// parseOverlayFSSyncPatterns splits patterns separated by new lines, empty lines and comments starting with "#" are dropped
+// commas are not separators as they are valid in patterns, e.g., "[a,b]"
+func parseOverlayFSSyncPatterns(patterns string) []string {
+	parsed := []string{}
+	for _, line := range strings.Split(patterns, "\n") {
+		line = strings.TrimSpace(line)
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		parsed = append(parsed, line)
+	}
+	return parsed
+}
+
+// newOverlayFSSyncFilter creates a filter, returns nil if no patterns are given
+func newOverlayFSSyncFilter(patterns []string) (*overlayFSSyncFilter, error) {
+	if len(patterns) == 0 {
+		return nil, nil
+	}
+
+	filter := &overlayFSSyncFilter{
+		patterns: []overlayFSSyncPattern{},
+	}
+
+	for _, pattern := range patterns {
+		syncPattern := overlayFSSyncPattern{
+			pattern: pattern,
+		}
+
+		if strings.HasPrefix(pattern, "!") {
+			syncPattern.include = true
+			pattern = pattern[1:]
+		}
+
+		if strings.HasSuffix(pattern, "/") {
+			syncPattern.dirOnly = true
+			pattern = strings.TrimRight(pattern, "/")
+		}
+
+		if len(pattern) == 0 {
+			return nil, xerrors.Errorf("invalid sync pattern %q, empty", syncPattern.pattern)
+		}
+
+		if !strings.Contains(pattern, "/") {
+			// matches at any depth
+			pattern = "**/" + pattern
+		}
+
+		syncPattern.segments = strings.Split(strings.TrimPrefix(pattern, "/"), "/")
+		for _, segment := range syncPattern.segments {
+			if _, err := path.Match(segment, ""); err != nil {
+				return nil, xerrors.Errorf("invalid sync pattern %q: %w", syncPattern.pattern, err)
+			}
+		}
+
+		filter.patterns = append(filter.patterns, syncPattern)
+	}
+
+	return filter, nil
+}
+
+// isExcluded checks if the path relative to the upper is excluded from sync
+// parent dirs are not checked, they are skipped while walking
+func (filter *overlayFSSyncFilter) isExcluded(relPath string, isDir bool) bool {
+	if filter == nil {
+		return false
+	}
+
+	segments := strings.Split(path.Clean(relPath), "/")
+
+	excluded := false
+	for _, pattern := range filter.patterns {
+		if pattern.dirOnly && !isDir {
+			continue
+		}
+
+		if matchOverlayFSSyncSegments(pattern.segments, segments) {
+			excluded = !pattern.include
+		}
+	}
+	return excluded
+}
+
+func matchOverlayFSSyncSegments(patternSegments []string, pathSegments []string) bool {
+	if len(patternSegments) == 0 {
+		return len(pathSegments) == 0
+	}
+
+	if patternSegments[0] == "**" {
+		// zero or more segments
+		for idx := 0; idx <= len(pathSegments); idx++ {
+			if matchOverlayFSSyncSegments(patternSegments[1:], pathSegments[idx:]) {
+				return true
+			}
+		}
+		return false
+	}
+
+	if len(pathSegments) == 0 {
+		return false
+	}
+
+	matched, err := path.Match(patternSegments[0], pathSegments[0])
+	if err != nil || !matched {
+		return false
+	}
+	return matchOverlayFSSyncSegments(patternSegments[1:], pathSegments[1:])
+}
+
+// isExcluded checks if the entry in the upper is excluded from sync
+func (syncher *OverlayFSSyncher) isExcluded(path string, isDir bool) bool {
+	relPath, err := filepath.Rel(syncher.upperLayerPath, path)
+	if err != nil {
+		return false
+	}
+	return syncher.filter.isExcluded(relPath, isDir)
+}
+
+// isWhiteoutExcluded checks if the entry deleted by the whiteout is excluded from sync
+// the type of the entry deleted is unknown, dir-only patterns match too
+func (syncher *OverlayFSSyncher) isWhiteoutExcluded(path string) bool {
+	target := syncher.getWhiteoutTarget(path)
+	return syncher.isExcluded(target, false) || syncher.isExcluded(target, true)
+}
+
+// reportExcluded reports the entry not synced as it is excluded, entries in excluded dirs are not reported
+func (syncher *OverlayFSSyncher) reportExcluded(path string, kind string) {
+	relPath, _ := filepath.Rel(syncher.upperLayerPath, path)
+
+	klog.V(5).Infof("skipped syncing %s %q, volume %q, excluded", kind, path, syncher.volumeID)
+
+	status := &OverlayFSSyncEntryStatus{
+		Type:    "entry",
+		Time:    time.Now(),
+		Kind:    kind,
+		Path:    relPath,
+		Outcome: OverlayFSSyncOutcomeExcluded,
+		Message: "excluded by sync patterns",
+	}
+	syncher.reportEntry(status, nil)
+}
@@ -0,0 +1,94 @@
+package irods
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseOverlayFSSyncPatterns(t *testing.T) {
+	testCases := []struct {
+		name     string
+		patterns string
+		expected []string
+	}{
+		{name: "empty", patterns: "", expected: []string{}},
+		{name: "lines", patterns: "*.swp\n__pycache__/\n/scratch", expected: []string{"*.swp", "__pycache__/", "/scratch"}},
+		{name: "comments and empty lines", patterns: "# editor\n*.swp\n\n  \n#cache\n.cache/\n", expected: []string{"*.swp", ".cache/"}},
+		{name: "spaces trimmed", patterns: "  *.swp  \r\n\t.git/", expected: []string{"*.swp", ".git/"}},
+		{name: "commas kept", patterns: "[a,b]*\nc,d", expected: []string{"[a,b]*", "c,d"}},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			parsed := parseOverlayFSSyncPatterns(testCase.patterns)
+			if !reflect.DeepEqual(parsed, testCase.expected) {
+				t.Errorf("expected %q, got %q", testCase.expected, parsed)
+			}
+		})
+	}
+}
+
+func TestNewOverlayFSSyncFilterInvalid(t *testing.T) {
+	for _, pattern := range []string{"!", "/", "!/", "[a"} {
+		_, err := newOverlayFSSyncFilter([]string{pattern})
+		if err == nil {
+			t.Errorf("expected an error for pattern %q", pattern)
+		}
+	}
+
+	filter, err := newOverlayFSSyncFilter(nil)
+	if err != nil || filter != nil {
+		t.Errorf("expected no filter without patterns, got %v, %v", filter, err)
+	}
+}
+
+func TestOverlayFSSyncFilterIsExcluded(t *testing.T) {
+	testCases := []struct {
+		name     string
+		patterns []string
+		relPath  string
+		isDir    bool
+		excluded bool
+	}{
+		{name: "name at root", patterns: []string{"*.swp"}, relPath: "a.swp", excluded: true},
+		{name: "name at depth", patterns: []string{"*.swp"}, relPath: "a/b/c.swp", excluded: true},
+		{name: "name not matched", patterns: []string{"*.swp"}, relPath: "a/b/c.txt", excluded: false},
+		{name: "wildcard within segment", patterns: []string{"*.swp"}, relPath: "a.swp/b", excluded: false},
+		{name: "question mark", patterns: []string{"file?.log"}, relPath: "file1.log", excluded: true},
+		{name: "bracket with comma", patterns: []string{"[a,b]*"}, relPath: "x/bcd", excluded: true},
+		{name: "bracket with comma not matched", patterns: []string{"[a,b]*"}, relPath: "cd", excluded: false},
+		{name: "anchored", patterns: []string{"/scratch"}, relPath: "scratch", isDir: true, excluded: true},
+		{name: "anchored not at depth", patterns: []string{"/scratch"}, relPath: "a/scratch", isDir: true, excluded: false},
+		{name: "path with slash is anchored", patterns: []string{"data/tmp"}, relPath: "data/tmp", isDir: true, excluded: true},
+		{name: "path with slash not at depth", patterns: []string{"data/tmp"}, relPath: "x/data/tmp", isDir: true, excluded: false},
+		{name: "dir only matches dir", patterns: []string{"__pycache__/"}, relPath: "a/__pycache__", isDir: true, excluded: true},
+		{name: "dir only skips file", patterns: []string{"__pycache__/"}, relPath: "a/__pycache__", isDir: false, excluded: false},
+		{name: "leading double star", patterns: []string{"**/logs"}, relPath: "a/b/logs", isDir: true, excluded: true},
+		{name: "middle double star", patterns: []string{"a/**/b"}, relPath: "a/x/y/b", excluded: true},
+		{name: "middle double star zero segments", patterns: []string{"a/**/b"}, relPath: "a/b", excluded: true},
+		{name: "trailing double star", patterns: []string{"a/**"}, relPath: "a/x/y", excluded: true},
+		{name: "include after exclude", patterns: []string{"*.log", "!keep.log"}, relPath: "d/keep.log", excluded: false},
+		{name: "include does not match others", patterns: []string{"*.log", "!keep.log"}, relPath: "d/other.log", excluded: true},
+		{name: "last pattern decides", patterns: []string{"!keep.log", "*.log"}, relPath: "keep.log", excluded: true},
+		{name: "cleaned path", patterns: []string{"/a/b"}, relPath: "a/./b/", excluded: true},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			filter, err := newOverlayFSSyncFilter(testCase.patterns)
+			if err != nil {
+				t.Fatalf("failed to create filter: %v", err)
+			}
+
+			excluded := filter.isExcluded(testCase.relPath, testCase.isDir)
+			if excluded != testCase.excluded {
+				t.Errorf("expected excluded %t for %q with %q, got %t", testCase.excluded, testCase.relPath, testCase.patterns, excluded)
+			}
+		})
+	}
+
+	var filter *overlayFSSyncFilter
+	if filter.isExcluded("a.swp", false) {
+		t.Errorf("nil filter must not exclude paths")
+	}
+}
@@ -0,0 +1,98 @@
+package irods
+
+import (
+	"os"
+	"sync"
+	"time"
+
+	"k8s.io/klog"
+)
+
+// OverlayFSSyncRecord is a record of an overlayfs upper sync in progress
+type OverlayFSSyncRecord struct {
+	VolumeID  string `yaml:"volume_id" json:"volume_id"`
+	UpperPath string `yaml:"upper_path" json:"upper_path"`
+	// configs the volume was mounted with, to connect to iRODS on resume
+	ClientConfig map[string]string `yaml:"client_config" json:"client_config"`
+	// states of iRODS entries at mount time, nil if conflicts are not checked
+	Baseline *OverlayFSSyncBaseline `yaml:"baseline,omitempty" json:"baseline,omitempty"`
+}
+
+// OverlayFSSyncDoneEntry is the state of an entry of the upper when it was synced
+// the entry is synced again on resume if it changed since
+type OverlayFSSyncDoneEntry struct {
+	Size    int64
+	ModTime time.Time
+}
+
+// OverlayFSSyncJournal persists overlayfs upper syncs in progress, so they can be resumed after node plugin restarts
+type OverlayFSSyncJournal interface {
+	// Start records a sync of the volume
+	Start(record *OverlayFSSyncRecord) error
+	// MarkDone records an entry of the upper, relative to the upper, as synced with its state
+	MarkDone(volID string, entryPath string, entry OverlayFSSyncDoneEntry) error
+	// GetDone returns entries of the upper synced so far
+	GetDone(volID string) (map[string]OverlayFSSyncDoneEntry, error)
+	// Suspend keeps the record to resume later, called when the sync fails
+	Suspend(volID string) error
+	// Finish deletes the record, called after the sync succeeds and the upper is deleted
+	Finish(volID string) error
+	// List returns syncs not finished
+	List() []*OverlayFSSyncRecord
+}
+
+var (
+	overlayFSSyncJournal      OverlayFSSyncJournal
+	overlayFSSyncJournalMutex sync.Mutex
+)
+
+// SetOverlayFSSyncJournal sets the journal to persist overlayfs upper syncs
+func SetOverlayFSSyncJournal(journal OverlayFSSyncJournal) {
+	overlayFSSyncJournalMutex.Lock()
+	defer overlayFSSyncJournalMutex.Unlock()
+
+	overlayFSSyncJournal = journal
+}
+
+func getOverlayFSSyncJournal() OverlayFSSyncJournal {
+	overlayFSSyncJournalMutex.Lock()
+	defer overlayFSSyncJournalMutex.Unlock()
+
+	return overlayFSSyncJournal
+}
+
+// ResumeOverlayFSSyncs resumes overlayfs upper syncs interrupted by node plugin restarts
+// entries synced before the restart are skipped
+func ResumeOverlayFSSyncs() {
+	journal := getOverlayFSSyncJournal()
+	if journal == nil {
+		return
+	}
+
+	for _, record := range journal.List() {
+		if _, err := os.Stat(record.UpperPath); os.IsNotExist(err) {
+			// deleted after sync, but before the record is deleted
+			klog.V(4).Infof("Overlayfs upper %q of volume %q is already synced", record.UpperPath, record.VolumeID)
+			err = journal.Finish(record.VolumeID)
+			if err != nil {
+				klog.Errorf("Failed to delete overlayfs sync record of volume %q, %s", record.VolumeID, err)
+			}
+			continue
+		}
+
+		irodsConnectionInfo, err := GetConnectionInfo(record.ClientConfig)
+		if err != nil {
+			klog.Errorf("Failed to resume syncing overlayfs upper %q of volume %q, %s, keeping upper", record.UpperPath, record.VolumeID, err)
+			continue
+		}
+
+		var syncState *overlayFSSyncState
+		if record.Baseline != nil {
+			// copy, not to change the record while the journal saves it
+			syncState = newOverlayFSSyncState(record.Baseline.Snapshot())
+		}
+
+		klog.Infof("Resuming sync of overlayfs upper %q of volume %q", record.UpperPath, record.VolumeID)
+		startOverlayFSSync(record.VolumeID, record.ClientConfig, irodsConnectionInfo, record.UpperPath, syncState)
+	}
+}
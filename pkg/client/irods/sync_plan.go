@@ -0,0 +1,550 @@
+package irods
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+
+	irodsclient_types "github.com/cyverse/go-irodsclient/irods/types"
+	"golang.org/x/xerrors"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/klog"
+)
+
+const (
+	syncPlanFileSuffix string = ".csi.overlay.sync.plan.json"
+)
+
+// errOverlayFSSyncApprovalRequired is returned when a sync deletes more entries than allowed without approval
+var errOverlayFSSyncApprovalRequired = errors.New("approval required")
+
+// OverlayFSSyncActionType is a type of change a sync makes to iRODS
+type OverlayFSSyncActionType string
+
+// overlayfs sync action types
+const (
+	// OverlayFSSyncActionCreate creates a file or a dir
+	OverlayFSSyncActionCreate OverlayFSSyncActionType = "create"
+	// OverlayFSSyncActionOverwrite overwrites a file
+	OverlayFSSyncActionOverwrite OverlayFSSyncActionType = "overwrite"
+	// OverlayFSSyncActionDelete deletes a file or a dir recursively
+	OverlayFSSyncActionDelete OverlayFSSyncActionType = "delete"
+	// OverlayFSSyncActionClearDir deletes all entries in a dir, for opaque dirs
+	OverlayFSSyncActionClearDir OverlayFSSyncActionType = "clear_dir"
+	// OverlayFSSyncActionMove moves a dir renamed in the overlay
+	OverlayFSSyncActionMove OverlayFSSyncActionType = "move"
+)
+
+// OverlayFSSyncAction is a change a sync makes to iRODS
+type OverlayFSSyncAction struct {
+	Action OverlayFSSyncActionType `json:"action"`
+	// "file", "dir" or "symlink"
+	Kind string `json:"kind"`
+	// path relative to the upper
+	Path      string `json:"path"`
+	IRODSPath string `json:"irods_path"`
+	// path the dir is moved from
+	SourceIRODSPath string `json:"source_irods_path,omitempty"`
+	// size of the file to upload
+	Size int64 `json:"size,omitempty"`
+	// number of iRODS entries deleted by delete and clear_dir, recursively, including the dir deleted
+	Entries int `json:"entries,omitempty"`
+}
+
+// IsDestructive checks if the action deletes data in iRODS
+func (action *OverlayFSSyncAction) IsDestructive() bool {
+	return action.Action == OverlayFSSyncActionDelete || action.Action == OverlayFSSyncActionClearDir
+}
+
+// OverlayFSSyncPlanSummary is a summary of actions
+type OverlayFSSyncPlanSummary struct {
+	Creates    int `json:"creates"`
+	Overwrites int `json:"overwrites"`
+	Deletes    int `json:"deletes"`
+	ClearDirs  int `json:"clear_dirs"`
+	Moves      int `json:"moves"`
+	// entries excluded by sync patterns, entries in excluded dirs are not counted
+	Excluded int `json:"excluded"`
+	// bytes to upload
+	Bytes int64 `json:"bytes"`
+	// number of iRODS entries deleted by deletes and clear_dirs, recursively
+	Destructive int `json:"destructive"`
+}
+
+// OverlayFSSyncPlan lists changes a sync would make to iRODS
+type OverlayFSSyncPlan struct {
+	VolumeID  string                   `json:"volume_id"`
+	UpperPath string                   `json:"upper_path"`
+	Actions   []*OverlayFSSyncAction   `json:"actions"`
+	Summary   OverlayFSSyncPlanSummary `json:"summary"`
+}
+
+func (plan *OverlayFSSyncPlan) add(action *OverlayFSSyncAction) {
+	plan.Actions = append(plan.Actions, action)
+
+	switch action.Action {
+	case OverlayFSSyncActionCreate:
+		plan.Summary.Creates++
+	case OverlayFSSyncActionOverwrite:
+		plan.Summary.Overwrites++
+	case OverlayFSSyncActionDelete:
+		plan.Summary.Deletes++
+	case OverlayFSSyncActionClearDir:
+		plan.Summary.ClearDirs++
+	case OverlayFSSyncActionMove:
+		plan.Summary.Moves++
+	}
+
+	plan.Summary.Bytes += action.Size
+	if action.IsDestructive() {
+		plan.Summary.Destructive += action.Entries
+	}
+}
+
+var (
+	// volume id -> number of entries approved to delete, consumed by the next sync requiring approval
+	overlayFSSyncApprovals      = map[string]int{}
+	overlayFSSyncApprovalsMutex sync.Mutex
+)
+
+// consumeOverlayFSSyncApproval checks if the sync deleting the number of entries is approved
+// the approval is consumed either way, a sync deleting more than approved needs to be reviewed again
+func consumeOverlayFSSyncApproval(volID string, destructive int) bool {
+	overlayFSSyncApprovalsMutex.Lock()
+	defer overlayFSSyncApprovalsMutex.Unlock()
+
+	approved, ok := overlayFSSyncApprovals[volID]
+	if !ok {
+		return false
+	}
+
+	delete(overlayFSSyncApprovals, volID)
+
+	if destructive > approved {
+		klog.Warningf("sync of volume %q deletes %d entries, more than %d approved, approval is revoked", volID, destructive, approved)
+		return false
+	}
+	return true
+}
+
+// Plan walks the upper and lists changes the sync would make to iRODS, without changing iRODS
+// conflict policies and unchanged files are not considered, they are overwrites in the plan
+func (syncher *OverlayFSSyncher) Plan() (*OverlayFSSyncPlan, error) {
+	err := syncher.loadDoneEntries()
+	if err != nil {
+		return nil, err
+	}
+
+	plan := &OverlayFSSyncPlan{
+		VolumeID:  syncher.volumeID,
+		UpperPath: syncher.upperLayerPath,
+		Actions:   []*OverlayFSSyncAction{},
+	}
+
+	walkFunc := func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return xerrors.Errorf("failed to walk %q for volume %q: %w", path, syncher.volumeID, err)
+		}
+
+		if path == syncher.upperLayerPath {
+			return nil
+		}
+
+		if !d.IsDir() && syncher.isIgnoredFile(path) {
+			return nil
+		}
+
+		if syncher.isPlanExcluded(path, d) {
+			plan.Summary.Excluded++
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if syncher.isDone(path, d) {
+			return nil
+		}
+
+		return syncher.planEntry(plan, path, d)
+	}
+
+	err = filepath.WalkDir(syncher.upperLayerPath, walkFunc)
+	if err != nil {
+		return nil, xerrors.Errorf("failed to walk dir %q for volume %q: %w", syncher.upperLayerPath, syncher.volumeID, err)
+	}
+
+	return plan, nil
+}
+
+func (syncher *OverlayFSSyncher) isPlanExcluded(path string, d fs.DirEntry) bool {
+	if syncher.isWhiteout(path, d) {
+		return syncher.isWhiteoutExcluded(path)
+	}
+	return syncher.isExcluded(path, d.IsDir())
+}
+
+func (syncher *OverlayFSSyncher) planEntry(plan *OverlayFSSyncPlan, path string, d fs.DirEntry) error {
+	if isUnsupportedEntry(d) {
+		// not synced
+		return nil
+	}
+
+	whiteout := syncher.isWhiteout(path, d)
+	if whiteout {
+		// plan the delete of the entry hidden
+		path = syncher.getWhiteoutTarget(path)
+	}
+
+	irodsPath, err := syncher.getIRODSPath(path)
+	if err != nil {
+		return err
+	}
+
+	if len(irodsPath) == 0 {
+		// not writable
+		return nil
+	}
+
+	relPath, err := filepath.Rel(syncher.upperLayerPath, path)
+	if err != nil {
+		return err
+	}
+
+	entry, err := syncher.irodsFsClient.Stat(irodsPath)
+	if err != nil {
+		if !irodsclient_types.IsFileNotFoundError(err) {
+			return xerrors.Errorf("failed to stat %q: %w", irodsPath, err)
+		}
+		entry = nil
+	}
+
+	newAction := func(action OverlayFSSyncActionType, kind string) *OverlayFSSyncAction {
+		return &OverlayFSSyncAction{
+			Action:    action,
+			Kind:      kind,
+			Path:      relPath,
+			IRODSPath: irodsPath,
+		}
+	}
+
+	existingKind := "file"
+	if entry != nil && entry.IsDir() {
+		existingKind = "dir"
+	}
+
+	// deletes of dirs delete all entries in them
+	newDeleteAction := func() (*OverlayFSSyncAction, error) {
+		action := newAction(OverlayFSSyncActionDelete, existingKind)
+		action.Entries = 1
+		if entry.IsDir() {
+			count, err := syncher.countIRODSEntries(path, irodsPath)
+			if err != nil {
+				return nil, err
+			}
+			action.Entries += count
+		}
+		return action, nil
+	}
+
+	if d.IsDir() {
+		if sourcePath := syncher.getRedirect(path); len(sourcePath) > 0 {
+			sourceIRODSPath, err := syncher.getIRODSPath(sourcePath)
+			if err != nil {
+				return err
+			}
+
+			if len(sourceIRODSPath) > 0 && syncher.irodsFsClient.ExistsDir(sourceIRODSPath) {
+				// entries in the dir are compared with iRODS before the move, they can be listed as creates
+				action := newAction(OverlayFSSyncActionMove, "dir")
+				action.SourceIRODSPath = sourceIRODSPath
+				plan.add(action)
+				return nil
+			}
+		}
+	}
+
+	switch {
+	case d.IsDir():
+		if entry == nil {
+			plan.add(newAction(OverlayFSSyncActionCreate, "dir"))
+			return nil
+		}
+
+		if !entry.IsDir() {
+			deleteAction, err := newDeleteAction()
+			if err != nil {
+				return err
+			}
+
+			plan.add(deleteAction)
+			plan.add(newAction(OverlayFSSyncActionCreate, "dir"))
+			return nil
+		}
+
+		if syncher.isOpaqueDir(path) {
+			count, err := syncher.countClearedIRODSEntries(path, irodsPath)
+			if err != nil {
+				return err
+			}
+
+			if count > 0 {
+				action := newAction(OverlayFSSyncActionClearDir, "dir")
+				action.Entries = count
+				plan.add(action)
+			}
+		}
+		return nil
+	case whiteout:
+		if entry != nil {
+			deleteAction, err := newDeleteAction()
+			if err != nil {
+				return err
+			}
+
+			plan.add(deleteAction)
+		}
+		return nil
+	default:
+		info, err := d.Info()
+		if err != nil {
+			return xerrors.Errorf("failed to stat %q: %w", path, err)
+		}
+
+		actionType := OverlayFSSyncActionCreate
+		if entry != nil {
+			if entry.IsDir() {
+				deleteAction, err := newDeleteAction()
+				if err != nil {
+					return err
+				}
+
+				plan.add(deleteAction)
+			} else {
+				actionType = OverlayFSSyncActionOverwrite
+			}
+		}
+
+		kind := "file"
+		if d.Type()&os.ModeSymlink != 0 {
+			kind = "symlink"
+		}
+
+		action := newAction(actionType, kind)
+		action.Size = info.Size()
+		plan.add(action)
+		return nil
+	}
+}
+
+// countIRODSEntries counts entries in the iRODS dir recursively, entries excluded from sync are not counted as they are kept
+// localPath is the path of the dir in the upper, used to match sync patterns
+func (syncher *OverlayFSSyncher) countIRODSEntries(localPath string, irodsPath string) (int, error) {
+	entries, err := syncher.irodsFsClient.List(irodsPath)
+	if err != nil {
+		return 0, xerrors.Errorf("failed to read dir %q: %w", irodsPath, err)
+	}
+
+	count := 0
+	for _, entry := range entries {
+		entryLocalPath := filepath.Join(localPath, entry.Name)
+		if syncher.isExcluded(entryLocalPath, entry.IsDir()) {
+			continue
+		}
+
+		count++
+		if !entry.IsDir() {
+			continue
+		}
+
+		subCount, err := syncher.countIRODSEntries(entryLocalPath, entry.Path)
+		if err != nil {
+			return 0, err
+		}
+		count += subCount
+	}
+	return count, nil
+}
+
+// countClearedIRODSEntries counts entries deleted by clearing the opaque dir, entries in the upper replace them and are not counted
+func (syncher *OverlayFSSyncher) countClearedIRODSEntries(localPath string, irodsPath string) (int, error) {
+	entries, err := syncher.irodsFsClient.List(irodsPath)
+	if err != nil {
+		return 0, xerrors.Errorf("failed to read dir %q: %w", irodsPath, err)
+	}
+
+	count := 0
+	for _, entry := range entries {
+		entryLocalPath := filepath.Join(localPath, entry.Name)
+		if syncher.isExcluded(entryLocalPath, entry.IsDir()) {
+			continue
+		}
+
+		if _, err := os.Lstat(entryLocalPath); err == nil {
+			continue
+		}
+
+		count++
+		if !entry.IsDir() {
+			continue
+		}
+
+		subCount, err := syncher.countIRODSEntries(entryLocalPath, entry.Path)
+		if err != nil {
+			return 0, err
+		}
+		count += subCount
+	}
+	return count, nil
+}
+
+func (syncher *OverlayFSSyncher) getPlanFilePath() string {
+	return fmt.Sprintf("/%s/home/%s/.%s%s", syncher.irodsConnectionInfo.ClientZoneName, syncher.irodsConnectionInfo.ClientUsername, syncher.volumeID, syncPlanFileSuffix)
+}
+
+// checkApproval plans the sync, and fails if it deletes more entries than the threshold and is not approved for as many
+// the plan is stored in iRODS next to the sync status file for review
+func (syncher *OverlayFSSyncher) checkApproval() error {
+	plan, err := syncher.Plan()
+	if err != nil {
+		return xerrors.Errorf("failed to plan sync for volume %q: %w", syncher.volumeID, err)
+	}
+
+	threshold := syncher.irodsConnectionInfo.OverlayFSSyncApprovalThreshold
+	if plan.Summary.Destructive <= threshold {
+		return nil
+	}
+
+	if consumeOverlayFSSyncApproval(syncher.volumeID, plan.Summary.Destructive) {
+		klog.Infof("sync'ing path %q for volume %q deleting %d entries, approved", syncher.upperLayerPath, syncher.volumeID, plan.Summary.Destructive)
+		return nil
+	}
+
+	planFile := syncher.getPlanFilePath()
+	err = syncher.savePlan(plan, planFile)
+	if err != nil {
+		klog.Errorf("failed to save sync plan to %q, %s", planFile, err)
+	}
+
+	return xerrors.Errorf("sync of volume %q deletes %d entries, more than %d, review the plan at %q and approve it: %w", syncher.volumeID, plan.Summary.Destructive, threshold, planFile, errOverlayFSSyncApprovalRequired)
+}
+
+func (syncher *OverlayFSSyncher) savePlan(plan *OverlayFSSyncPlan, planFile string) error {
+	planBytes, err := json.MarshalIndent(plan, "", "  ")
+	if err != nil {
+		return xerrors.Errorf("failed to serialize sync plan: %w", err)
+	}
+
+	handle, err := syncher.irodsFsClient.OpenFile(planFile, "", "w+") // write + truncate
+	if err != nil {
+		return xerrors.Errorf("failed to create sync plan file %q: %w", planFile, err)
+	}
+	defer handle.Close()
+
+	_, err = handle.Write(planBytes)
+	if err != nil {
+		return xerrors.Errorf("failed to write sync plan file %q: %w", planFile, err)
+	}
+	return nil
+}
+
+// findOverlayFSSync returns the connection info, upper and state of the volume mounted or syncing after unmount
+func findOverlayFSSync(volID string) (*IRODSFSConnectionInfo, string, *overlayFSSyncState, *OverlayFSSyncRecord, error) {
+	if syncer := getOverlayFSMountedSyncer(volID); syncer != nil {
+		return syncer.irodsConnectionInfo, syncer.upperPath, syncer.state, nil, nil
+	}
+
+	journal := getOverlayFSSyncJournal()
+	if journal != nil {
+		for _, record := range journal.List() {
+			if record.VolumeID != volID {
+				continue
+			}
+
+			irodsConnectionInfo, err := GetConnectionInfo(record.ClientConfig)
+			if err != nil {
+				return nil, "", nil, nil, err
+			}
+
+			var syncState *overlayFSSyncState
+			if record.Baseline != nil {
+				syncState = newOverlayFSSyncState(record.Baseline.Snapshot())
+			}
+			return irodsConnectionInfo, record.UpperPath, syncState, record, nil
+		}
+	}
+
+	return nil, "", nil, nil, status.Errorf(codes.NotFound, "Volume %q is not mounted with overlayfs or syncing on this node", volID)
+}
+
+// PlanOverlayFSSync lists changes the sync of the volume would make to iRODS, without changing iRODS
+// returns NotFound if the volume is not mounted with overlayfs or syncing on this node
+func PlanOverlayFSSync(ctx context.Context, volID string) (*OverlayFSSyncPlan, error) {
+	irodsConnectionInfo, upperPath, syncState, record, err := findOverlayFSSync(volID)
+	if err != nil {
+		return nil, err
+	}
+
+	var journal OverlayFSSyncJournal
+	if record != nil {
+		// skip entries synced before
+		journal = getOverlayFSSyncJournal()
+	}
+
+	var plan *OverlayFSSyncPlan
+	err = runWithContext(ctx, func() error {
+		syncher, err := NewOverlayFSSyncher(volID, irodsConnectionInfo, upperPath, journal)
+		if err != nil {
+			return xerrors.Errorf("failed to create a overlayfs syncher: %w", err)
+		}
+		defer syncher.Release()
+
+		if syncState != nil {
+			syncher.SetSyncState(syncState)
+		}
+
+		plan, err = syncher.Plan()
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return plan, nil
+}
+
+// ApproveOverlayFSSync approves the next sync of the volume deleting more entries than the threshold
+// destructive is the number of entries deleted in the plan reviewed, the sync is not approved if it deletes more
+// a sync suspended for approval after unmount is resumed now
+// returns NotFound if the volume is not mounted with overlayfs or syncing on this node
+func ApproveOverlayFSSync(volID string, destructive int) error {
+	if destructive < 0 {
+		return status.Errorf(codes.InvalidArgument, "Invalid number of entries to delete %d, must not be negative", destructive)
+	}
+
+	irodsConnectionInfo, upperPath, syncState, record, err := findOverlayFSSync(volID)
+	if err != nil {
+		return err
+	}
+
+	// also serializes resuming syncs on approval
+	overlayFSSyncApprovalsMutex.Lock()
+	defer overlayFSSyncApprovalsMutex.Unlock()
+
+	overlayFSSyncApprovals[volID] = destructive
+
+	klog.Infof("Approved sync of overlayfs upper %q of volume %q deleting up to %d entries", upperPath, volID, destructive)
+
+	if record != nil && !overlayFSSyncs.isPending(upperPath) {
+		klog.Infof("Resuming sync of overlayfs upper %q of volume %q", upperPath, volID)
+		startOverlayFSSync(volID, record.ClientConfig, irodsConnectionInfo, upperPath, syncState)
+	}
+
+	return nil
+}
@@ -0,0 +1,171 @@
+package irods
+
+import (
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	irodsclient_fs "github.com/cyverse/go-irodsclient/fs"
+	"github.com/cyverse/irods-csi-driver/pkg/metrics"
+	"k8s.io/klog"
+)
+
+// OverlayFSSyncOutcome is a result of syncing an entry
+type OverlayFSSyncOutcome string
+
+// overlayfs sync outcomes
+const (
+	// OverlayFSSyncOutcomeSynced means the change is applied to iRODS
+	OverlayFSSyncOutcomeSynced OverlayFSSyncOutcome = "synced"
+	// OverlayFSSyncOutcomeSkipped means nothing needs to change in iRODS, e.g., unchanged or not writable
+	OverlayFSSyncOutcomeSkipped OverlayFSSyncOutcome = "skipped"
+	// OverlayFSSyncOutcomeConflict means the entry is kept in the upper due to a conflict
+	OverlayFSSyncOutcomeConflict OverlayFSSyncOutcome = "conflict"
+	// OverlayFSSyncOutcomeFailed means the entry failed to sync, it is kept in the upper
+	OverlayFSSyncOutcomeFailed OverlayFSSyncOutcome = "failed"
+	// OverlayFSSyncOutcomeUnsupported means iRODS cannot store the entry, e.g., devices, sockets and pipes
+	// the entry is not synced, but the sync does not fail
+	OverlayFSSyncOutcomeUnsupported OverlayFSSyncOutcome = "unsupported"
+	// OverlayFSSyncOutcomeExcluded means the entry is excluded by sync patterns, it is not synced and dropped with the upper
+	OverlayFSSyncOutcomeExcluded OverlayFSSyncOutcome = "excluded"
+)
+
+// OverlayFSSyncEntryStatus is a status of an entry synced, a line of the sync status file
+type OverlayFSSyncEntryStatus struct {
+	// "entry"
+	Type string    `json:"type"`
+	Time time.Time `json:"time"`
+	// "file", "dir", "symlink", "whiteout" or "unsupported"
+	Kind string `json:"kind"`
+	// path relative to the upper
+	Path      string                  `json:"path"`
+	IRODSPath string                  `json:"irods_path,omitempty"`
+	Action    OverlayFSSyncActionType `json:"action,omitempty"`
+	Outcome   OverlayFSSyncOutcome    `json:"outcome"`
+	// bytes uploaded
+	Bytes           int64   `json:"bytes"`
+	DurationSeconds float64 `json:"duration_seconds"`
+	Message         string  `json:"message,omitempty"`
+	Error           string  `json:"error,omitempty"`
+}
+
+// skip marks the entry as skipped, nothing changed in iRODS
+func (status *OverlayFSSyncEntryStatus) skip(message string) {
+	status.Outcome = OverlayFSSyncOutcomeSkipped
+	status.Message = message
+}
+
+// OverlayFSSyncSummary is a summary of a sync, the last line of the sync status file
+type OverlayFSSyncSummary struct {
+	// "summary"
+	Type            string    `json:"type"`
+	VolumeID        string    `json:"volume_id"`
+	UpperPath       string    `json:"upper_path"`
+	StartTime       time.Time `json:"start_time"`
+	EndTime         time.Time `json:"end_time"`
+	DurationSeconds float64   `json:"duration_seconds"`
+	Synced          int64     `json:"synced"`
+	Skipped         int64     `json:"skipped"`
+	Conflicts       int64     `json:"conflicts"`
+	Failed          int64     `json:"failed"`
+	Unsupported     int64     `json:"unsupported"`
+	Excluded        int64     `json:"excluded"`
+	Bytes           int64     `json:"bytes"`
+	// "success" or "failure"
+	Result string `json:"result"`
+	Error  string `json:"error,omitempty"`
+}
+
+// overlayFSSyncStatusWriter writes statuses of entries as NDJSON, safe to use concurrently
+type overlayFSSyncStatusWriter struct {
+	handle *irodsclient_fs.FileHandle
+	mutex  sync.Mutex
+}
+
+func newOverlayFSSyncStatusWriter(handle *irodsclient_fs.FileHandle) *overlayFSSyncStatusWriter {
+	return &overlayFSSyncStatusWriter{
+		handle: handle,
+	}
+}
+
+func (writer *overlayFSSyncStatusWriter) write(record interface{}) {
+	if writer == nil || writer.handle == nil {
+		return
+	}
+
+	recordBytes, err := json.Marshal(record)
+	if err != nil {
+		klog.Errorf("failed to serialize sync status, %s", err)
+		return
+	}
+
+	writer.mutex.Lock()
+	defer writer.mutex.Unlock()
+
+	_, err = writer.handle.Write(append(recordBytes, '\n'))
+	if err != nil {
+		klog.Errorf("failed to write sync status, %s", err)
+	}
+}
+
+// reportEntry counts the entry status, and writes it to the status file
+func (syncher *OverlayFSSyncher) reportEntry(status *OverlayFSSyncEntryStatus, err error) {
+	switch {
+	case errors.Is(err, errOverlayFSSyncConflict):
+		status.Outcome = OverlayFSSyncOutcomeConflict
+		syncher.conflictEntries.Add(1)
+	case errors.Is(err, errOverlayFSSyncUnsupported):
+		status.Outcome = OverlayFSSyncOutcomeUnsupported
+		syncher.unsupportedEntries.Add(1)
+	case err != nil:
+		status.Outcome = OverlayFSSyncOutcomeFailed
+		syncher.failedEntries.Add(1)
+	case status.Outcome == OverlayFSSyncOutcomeSkipped:
+		syncher.skippedEntries.Add(1)
+	case status.Outcome == OverlayFSSyncOutcomeExcluded:
+		syncher.excludedEntries.Add(1)
+	default:
+		status.Outcome = OverlayFSSyncOutcomeSynced
+		syncher.syncedEntries.Add(1)
+		syncher.syncedBytes.Add(status.Bytes)
+		metrics.AddCounterForOverlayFSSyncBytes(syncher.volumeID, status.Bytes)
+	}
+
+	if err != nil {
+		status.Error = err.Error()
+	}
+
+	metrics.IncreaseCounterForOverlayFSSyncEntries(syncher.volumeID, string(status.Outcome))
+	syncher.statusWriter.write(status)
+}
+
+// reportSummary writes the summary of the sync to the status file
+func (syncher *OverlayFSSyncher) reportSummary(startTime time.Time, err error) {
+	endTime := time.Now()
+	summary := &OverlayFSSyncSummary{
+		Type:            "summary",
+		VolumeID:        syncher.volumeID,
+		UpperPath:       syncher.upperLayerPath,
+		StartTime:       startTime,
+		EndTime:         endTime,
+		DurationSeconds: endTime.Sub(startTime).Seconds(),
+		Synced:          syncher.syncedEntries.Load(),
+		Skipped:         syncher.skippedEntries.Load(),
+		Conflicts:       syncher.conflictEntries.Load(),
+		Failed:          syncher.failedEntries.Load(),
+		Unsupported:     syncher.unsupportedEntries.Load(),
+		Excluded:        syncher.excludedEntries.Load(),
+		Bytes:           syncher.syncedBytes.Load(),
+		Result:          "success",
+	}
+
+	if err != nil {
+		summary.Result = "failure"
+		summary.Error = err.Error()
+		metrics.IncreaseCounterForOverlayFSSyncFailures(syncher.volumeID)
+	}
+
+	klog.V(3).Infof("sync'ed path %q for volume %q, %d synced, %d skipped, %d conflicts, %d failed, %d unsupported, %d excluded, %d bytes", summary.UpperPath, summary.VolumeID, summary.Synced, summary.Skipped, summary.Conflicts, summary.Failed, summary.Unsupported, summary.Excluded, summary.Bytes)
+	syncher.statusWriter.write(summary)
+}
@@ -0,0 +1,137 @@
+package irods
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/cyverse/irods-csi-driver/pkg/metrics"
+)
+
+const (
+	syncTrackerPollInterval = 1 * time.Second
+)
+
+// overlayFSSyncTracker tracks asynchronous overlayfs upper syncs
+type overlayFSSyncTracker struct {
+	// upper path -> volume id
+	pending map[string]string
+	// upper paths kept due to sync failures
+	failed map[string]string
+	mutex  sync.Mutex
+}
+
+var (
+	overlayFSSyncs = &overlayFSSyncTracker{
+		pending: map[string]string{},
+		failed:  map[string]string{},
+	}
+
+	overlayFSSyncFailureHandler OverlayFSSyncFailureHandler
+	overlayFSSyncFailureMutex   sync.Mutex
+)
+
+// OverlayFSSyncFailureHandler is called when syncing overlayfs upper data of a volume to iRODS fails
+// configs are the configs the volume was mounted with
+type OverlayFSSyncFailureHandler func(volID string, configs map[string]string, upperPath string, err error)
+
+// SetOverlayFSSyncFailureHandler sets the handler called on overlayfs upper sync failures
+func SetOverlayFSSyncFailureHandler(handler OverlayFSSyncFailureHandler) {
+	overlayFSSyncFailureMutex.Lock()
+	defer overlayFSSyncFailureMutex.Unlock()
+
+	overlayFSSyncFailureHandler = handler
+}
+
+func notifyOverlayFSSyncFailure(volID string, configs map[string]string, upperPath string, err error) {
+	overlayFSSyncFailureMutex.Lock()
+	handler := overlayFSSyncFailureHandler
+	overlayFSSyncFailureMutex.Unlock()
+
+	if handler != nil {
+		handler(volID, configs, upperPath, err)
+	}
+}
+
+func (tracker *overlayFSSyncTracker) start(volID string, upperPath string) {
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+
+	tracker.pending[upperPath] = volID
+	delete(tracker.failed, upperPath)
+
+	metrics.SetGaugeForPendingOverlayFSSync(volID, true)
+}
+
+func (tracker *overlayFSSyncTracker) done(upperPath string, err error) {
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+
+	volID := tracker.pending[upperPath]
+	delete(tracker.pending, upperPath)
+
+	if err != nil {
+		// still pending
+		tracker.failed[upperPath] = volID
+		return
+	}
+
+	metrics.SetGaugeForPendingOverlayFSSync(volID, false)
+}
+
+func (tracker *overlayFSSyncTracker) isPending(upperPath string) bool {
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+
+	_, ok := tracker.pending[upperPath]
+	return ok
+}
+
+// isUnflushed checks if the upper is still syncing, or kept due to sync failures
+func (tracker *overlayFSSyncTracker) isUnflushed(upperPath string) bool {
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+
+	_, pending := tracker.pending[upperPath]
+	_, failed := tracker.failed[upperPath]
+	return pending || failed
+}
+
+func (tracker *overlayFSSyncTracker) getPendingCount() int {
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+
+	return len(tracker.pending)
+}
+
+func (tracker *overlayFSSyncTracker) getUnflushed() []string {
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+
+	unflushed := []string{}
+	for upperPath := range tracker.pending {
+		unflushed = append(unflushed, upperPath)
+	}
+
+	for upperPath := range tracker.failed {
+		unflushed = append(unflushed, upperPath)
+	}
+	return unflushed
+}
+
+// WaitForOverlayFSSyncs waits for in-flight overlayfs upper syncs until ctx is done
+// returns upper paths that are not flushed to iRODS, still syncing or kept due to sync failures
+func WaitForOverlayFSSyncs(ctx context.Context) []string {
+	ticker := time.NewTicker(syncTrackerPollInterval)
+	defer ticker.Stop()
+
+	for overlayFSSyncs.getPendingCount() > 0 {
+		select {
+		case <-ctx.Done():
+			return overlayFSSyncs.getUnflushed()
+		case <-ticker.C:
+		}
+	}
+
+	return overlayFSSyncs.getUnflushed()
+}
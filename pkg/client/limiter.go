@@ -0,0 +1,202 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/cyverse/irods-csi-driver/pkg/client/common"
+	"github.com/cyverse/irods-csi-driver/pkg/client/irods"
+	"github.com/cyverse/irods-csi-driver/pkg/client/nfs"
+	"github.com/cyverse/irods-csi-driver/pkg/client/webdav"
+	csi_common "github.com/cyverse/irods-csi-driver/pkg/common"
+	"github.com/cyverse/irods-csi-driver/pkg/metrics"
+	"golang.org/x/xerrors"
+	"k8s.io/klog"
+)
+
+// serverMountSlots are mount slots of a server, shared by mounts waiting or running
+type serverMountSlots struct {
+	slots chan struct{}
+	// number of mounts waiting or running, slots are deleted when it drops to zero
+	users int
+}
+
+// MountLimiter limits concurrent mounts per node and per server, mounts exceeding limits wait in queue
+type MountLimiter struct {
+	nodeSlots chan struct{}
+
+	serverLimit int
+	serverSlots map[string]*serverMountSlots
+	mutex       sync.Mutex
+}
+
+// NewMountLimiter creates a new MountLimiter, zero or negative limit means unlimited
+func NewMountLimiter(nodeLimit int, serverLimit int) *MountLimiter {
+	limiter := &MountLimiter{
+		nodeSlots:   nil,
+		serverLimit: serverLimit,
+		serverSlots: map[string]*serverMountSlots{},
+	}
+
+	if nodeLimit > 0 {
+		limiter.nodeSlots = make(chan struct{}, nodeLimit)
+	}
+
+	return limiter
+}
+
+// NewMountLimiterFromConfigs creates a new MountLimiter from driver configs
+// limits are given via "max_concurrent_mounts" (per node) and "max_concurrent_mounts_per_server" (per iRODS host/user)
+func NewMountLimiterFromConfigs(configs map[string]string) (*MountLimiter, error) {
+	nodeLimit := 0
+	serverLimit := 0
+
+	for k, v := range configs {
+		switch csi_common.NormalizeConfigKey(k) {
+		case csi_common.NormalizeConfigKey("max_concurrent_mounts"):
+			limit, err := strconv.Atoi(v)
+			if err != nil {
+				return nil, xerrors.Errorf("failed to parse max concurrent mounts %q: %w", v, err)
+			}
+			nodeLimit = limit
+		case csi_common.NormalizeConfigKey("max_concurrent_mounts_per_server"):
+			limit, err := strconv.Atoi(v)
+			if err != nil {
+				return nil, xerrors.Errorf("failed to parse max concurrent mounts per server %q: %w", v, err)
+			}
+			serverLimit = limit
+		default:
+			// ignore
+		}
+	}
+
+	return NewMountLimiter(nodeLimit, serverLimit), nil
+}
+
+// getServerSlots returns mount slots of the server, putServerSlots must be called when done
+func (limiter *MountLimiter) getServerSlots(serverKey string) chan struct{} {
+	if limiter.serverLimit <= 0 || len(serverKey) == 0 {
+		return nil
+	}
+
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+
+	slots, ok := limiter.serverSlots[serverKey]
+	if !ok {
+		slots = &serverMountSlots{
+			slots: make(chan struct{}, limiter.serverLimit),
+		}
+		limiter.serverSlots[serverKey] = slots
+	}
+
+	slots.users++
+	return slots.slots
+}
+
+// putServerSlots deletes mount slots of the server if no mounts are waiting or running
+func (limiter *MountLimiter) putServerSlots(serverKey string) {
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+
+	slots, ok := limiter.serverSlots[serverKey]
+	if !ok {
+		return
+	}
+
+	slots.users--
+	if slots.users <= 0 {
+		delete(limiter.serverSlots, serverKey)
+	}
+}
+
+// Acquire waits for a mount slot of the node and the server until ctx is done
+// the returned release function must be called after mount
+func (limiter *MountLimiter) Acquire(ctx context.Context, serverKey string) (func(), error) {
+	if limiter == nil {
+		return func() {}, nil
+	}
+
+	serverSlots := limiter.getServerSlots(serverKey)
+
+	startTime := time.Now()
+	metrics.IncreaseCounterForWaitingVolumeMount()
+	defer metrics.DecreaseCounterForWaitingVolumeMount()
+
+	if limiter.nodeSlots != nil {
+		select {
+		case limiter.nodeSlots <- struct{}{}:
+		case <-ctx.Done():
+			if serverSlots != nil {
+				limiter.putServerSlots(serverKey)
+			}
+			return nil, csi_common.GetContextStatusError(ctx, "Timed out waiting for a mount slot of the node")
+		}
+	}
+
+	if serverSlots != nil {
+		select {
+		case serverSlots <- struct{}{}:
+		case <-ctx.Done():
+			if limiter.nodeSlots != nil {
+				<-limiter.nodeSlots
+			}
+			limiter.putServerSlots(serverKey)
+			return nil, csi_common.GetContextStatusError(ctx, "Timed out waiting for a mount slot of server %q", serverKey)
+		}
+	}
+
+	waitTime := time.Since(startTime)
+	metrics.ObserveVolumeMountWaitTime(waitTime.Seconds())
+	if waitTime > time.Second {
+		klog.V(4).Infof("Waited %v for a mount slot of server %q", waitTime, serverKey)
+	}
+
+	release := func() {
+		if serverSlots != nil {
+			<-serverSlots
+			limiter.putServerSlots(serverKey)
+		}
+
+		if limiter.nodeSlots != nil {
+			<-limiter.nodeSlots
+		}
+	}
+	return release, nil
+}
+
+// GetServerKey returns a key identifying the iRODS server and user the fs client connects to
+// returns empty string if configs are invalid
+func GetServerKey(configs map[string]string) string {
+	switch common.GetClientType(configs) {
+	case common.IrodsFuseClientType:
+		connInfo, err := irods.GetConnectionInfo(configs)
+		if err != nil {
+			return ""
+		}
+		return fmt.Sprintf("%s:%d/%s", connInfo.Host, connInfo.Port, connInfo.Username)
+	case common.WebdavClientType:
+		connInfo, err := webdav.GetConnectionInfo(configs)
+		if err != nil {
+			return ""
+		}
+
+		u, err := url.Parse(connInfo.URL)
+		if err != nil {
+			return ""
+		}
+		return fmt.Sprintf("%s/%s", u.Host, connInfo.User)
+	case common.NfsClientType:
+		connInfo, err := nfs.GetConnectionInfo(configs)
+		if err != nil {
+			return ""
+		}
+		return fmt.Sprintf("%s:%d", connInfo.Hostname, connInfo.Port)
+	default:
+		return ""
+	}
+}
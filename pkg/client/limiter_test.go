@@ -0,0 +1,92 @@
+package client
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func getServerSlotsCount(limiter *MountLimiter) int {
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+
+	return len(limiter.serverSlots)
+}
+
+func TestMountLimiterDeletesIdleServerSlots(t *testing.T) {
+	limiter := NewMountLimiter(0, 1)
+
+	release1, err := limiter.Acquire(context.Background(), "host:1247/user1")
+	if err != nil {
+		t.Fatalf("failed to acquire a slot: %v", err)
+	}
+
+	release2, err := limiter.Acquire(context.Background(), "host:1247/user2")
+	if err != nil {
+		t.Fatalf("failed to acquire a slot: %v", err)
+	}
+
+	if count := getServerSlotsCount(limiter); count != 2 {
+		t.Errorf("expected slots of 2 servers, got %d", count)
+	}
+
+	// the slot of user1 is busy, waiting times out
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	_, err = limiter.Acquire(ctx, "host:1247/user1")
+	if status.Code(err) != codes.DeadlineExceeded {
+		t.Errorf("expected %s, got %v", codes.DeadlineExceeded, err)
+	}
+
+	release1()
+	release2()
+
+	if count := getServerSlotsCount(limiter); count != 0 {
+		t.Errorf("expected no slots after release, got %d", count)
+	}
+}
+
+func TestMountLimiterKeepsServerSlotsInUse(t *testing.T) {
+	limiter := NewMountLimiter(0, 1)
+
+	release, err := limiter.Acquire(context.Background(), "host:1247/user")
+	if err != nil {
+		t.Fatalf("failed to acquire a slot: %v", err)
+	}
+
+	acquired := make(chan func())
+	go func() {
+		waitingRelease, err := limiter.Acquire(context.Background(), "host:1247/user")
+		if err != nil {
+			t.Errorf("failed to acquire a slot: %v", err)
+		}
+		acquired <- waitingRelease
+	}()
+
+	// wait for the mount to queue on the slot
+	for {
+		limiter.mutex.Lock()
+		users := limiter.serverSlots["host:1247/user"].users
+		limiter.mutex.Unlock()
+		if users == 2 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	release()
+
+	waitingRelease := <-acquired
+	if count := getServerSlotsCount(limiter); count != 1 {
+		t.Errorf("expected slots kept for the waiting mount, got %d", count)
+	}
+
+	waitingRelease()
+	if count := getServerSlotsCount(limiter); count != 0 {
+		t.Errorf("expected no slots after release, got %d", count)
+	}
+}
@@ -1,15 +1,17 @@
 package nfs
 
 import (
+	"context"
 	"fmt"
 
+	"github.com/cyverse/irods-csi-driver/pkg/common"
 	"github.com/cyverse/irods-csi-driver/pkg/mounter"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 	"k8s.io/klog"
 )
 
-func Mount(mounter mounter.Mounter, volID string, configs map[string]string, mntOptions []string, targetPath string) error {
+func Mount(ctx context.Context, mounter mounter.Mounter, volID string, configs map[string]string, mntOptions []string, targetPath string) error {
 	irodsConnectionInfo, err := GetConnectionInfo(configs)
 	if err != nil {
 		return err
@@ -29,7 +31,12 @@ func Mount(mounter mounter.Mounter, volID string, configs map[string]string, mnt
 	}
 
 	klog.V(5).Infof("Mounting %q (%q) at %q with options %v", source, fsType, targetPath, mountOptions)
-	if err := mounter.MountSensitive2(source, source, targetPath, fsType, mountOptions, mountSensitiveOptions, stdinArgs); err != nil {
+	if err := mounter.MountSensitive2(ctx, source, source, targetPath, fsType, mountOptions, mountSensitiveOptions, stdinArgs); err != nil {
+		if ctxErr := common.GetContextStatusError(ctx, "Mounting %q (%q) at %q was interrupted", source, fsType, targetPath); ctxErr != nil {
+			// umount the volume to ensure no leftovers
+			mounter.UnmountLazy(targetPath, true)
+			return ctxErr
+		}
 		return status.Errorf(codes.Internal, "Failed to mount %q (%q) at %q: %v", source, fsType, targetPath, err)
 	}
 
@@ -37,9 +44,14 @@ func Mount(mounter mounter.Mounter, volID string, configs map[string]string, mnt
 }
 
 func Unmount(mounter mounter.Mounter, volID string, configs map[string]string, targetPath string) error {
-	err := mounter.Unmount(targetPath)
+	err := mounter.UnmountWithAbort(targetPath)
 	if err != nil {
 		return status.Errorf(codes.Internal, "Failed to unmount %q: %v", targetPath, err)
 	}
 	return nil
 }
+
+// Cleanup deletes leftover data of the volume, nfs has nothing to delete
+func Cleanup(volID string, configs map[string]string) error {
+	return nil
+}
@@ -1,12 +1,14 @@
 package webdav
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"path/filepath"
 	"syscall"
 
 	client_common "github.com/cyverse/irods-csi-driver/pkg/client/common"
+	"github.com/cyverse/irods-csi-driver/pkg/common"
 	"github.com/cyverse/irods-csi-driver/pkg/mounter"
 	"golang.org/x/xerrors"
 	"google.golang.org/grpc/codes"
@@ -14,7 +16,7 @@ import (
 	"k8s.io/klog"
 )
 
-func Mount(mounter mounter.Mounter, volID string, configs map[string]string, mntOptions []string, targetPath string) error {
+func Mount(ctx context.Context, mounter mounter.Mounter, volID string, configs map[string]string, mntOptions []string, targetPath string) error {
 	irodsConnectionInfo, err := GetConnectionInfo(configs)
 	if err != nil {
 		return err
@@ -62,7 +64,12 @@ func Mount(mounter mounter.Mounter, volID string, configs map[string]string, mnt
 	}
 
 	klog.V(5).Infof("Mounting %q (%q) at %q with options %v", source, fsType, targetPath, mountOptions)
-	if err := mounter.MountSensitive2(source, source, targetPath, fsType, mountOptions, mountSensitiveOptions, stdinArgs); err != nil {
+	if err := mounter.MountSensitive2(ctx, source, source, targetPath, fsType, mountOptions, mountSensitiveOptions, stdinArgs); err != nil {
+		if ctxErr := common.GetContextStatusError(ctx, "Mounting %q (%q) at %q was interrupted", source, fsType, targetPath); ctxErr != nil {
+			// umount the volume to ensure no leftovers
+			mounter.UnmountLazy(targetPath, true)
+			return ctxErr
+		}
 		return status.Errorf(codes.Internal, "Failed to mount %q (%q) at %q: %v", source, fsType, targetPath, err)
 	}
 
@@ -70,13 +77,18 @@ func Mount(mounter mounter.Mounter, volID string, configs map[string]string, mnt
 }
 
 func Unmount(mounter mounter.Mounter, volID string, configs map[string]string, targetPath string) error {
-	err := mounter.UnmountLazy(targetPath, true)
+	err := mounter.UnmountWithAbort(targetPath)
 	if err != nil {
 		return status.Errorf(codes.Internal, "Failed to unmount %q: %v", targetPath, err)
 	}
 
+	return Cleanup(volID, configs)
+}
+
+// Cleanup deletes leftover davfs data of the volume
+func Cleanup(volID string, configs map[string]string) error {
 	dataRootPath := client_common.GetConfigDataRootPath(configs, volID)
-	err = deleteDavFSData(dataRootPath)
+	err := deleteDavFSData(dataRootPath)
 	if err != nil {
 		klog.Errorf("Error deleting davfs data at %q, %s, ignoring", dataRootPath, err)
 	}
@@ -24,18 +24,42 @@ import (
 	"path"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"golang.org/x/xerrors"
 )
 
 // Config holds the parameters list which can be configured
 type Config struct {
-	Endpoint               string // CSI endpoint
-	NodeID                 string // node ID
-	SecretPath             string // Secret mount path
-	PoolServiceEndpoint    string // iRODS FS Pool Service endpoint
-	PrometheusExporterPort int    // Prometheus Exporter Service port
-	StoragePath            string // Path to storage dir (for saving volume info and etc)
+	Endpoint               string        // CSI endpoint
+	NodeID                 string        // node ID
+	SecretPath             string        // Secret mount path
+	PoolServiceEndpoint    string        // iRODS FS Pool Service endpoint
+	PrometheusExporterPort int           // Prometheus Exporter Service port
+	StoragePath            string        // Path to storage dir (for saving volume info and etc)
+	SweepOnStartup         bool          // Unmount stale mounts and delete leftover data under storage dir on startup
+	AdminEndpoint          string        // Admin service endpoint for node operations (e.g., drain)
+	DrainTimeout           time.Duration // Time to wait for unmounts and syncs on drain
+	Mode                   string        // Services to run, controller, node, or all
+}
+
+const (
+	// ControllerMode runs controller service only
+	ControllerMode string = "controller"
+	// NodeMode runs node service only
+	NodeMode string = "node"
+	// AllMode runs both controller and node services
+	AllMode string = "all"
+)
+
+// IsValidMode checks if the mode is known
+func IsValidMode(mode string) bool {
+	return mode == ControllerMode || mode == NodeMode || mode == AllMode
+}
+
+// IsNodeServiceEnabled checks if node service runs, which owns mounts and overlayfs syncs on the node
+func (config *Config) IsNodeServiceEnabled() bool {
+	return config.Mode == NodeMode || config.Mode == AllMode
 }
 
 // NormalizeConfigKey normalizes config key
@@ -86,7 +110,7 @@ func MergeConfig(driverConfig *Config, driverSecrets map[string]string, volSecre
 func RedactConfig(config map[string]string) map[string]string {
 	newConfigs := make(map[string]string)
 	for k, v := range config {
-		if k == NormalizeConfigKey("password") || k == NormalizeConfigKey("irods_user_password") {
+		if k == NormalizeConfigKey("password") || k == NormalizeConfigKey("irods_user_password") || k == NormalizeConfigKey("user_password") || k == NormalizeConfigKey("ticket") || k == NormalizeConfigKey("irods_ticket") {
 			newConfigs[k] = "**REDACTED**"
 		} else {
 			newConfigs[k] = v
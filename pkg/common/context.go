@@ -0,0 +1,19 @@
+package common
+
+import (
+	"context"
+	"fmt"
+
+	"google.golang.org/grpc/status"
+)
+
+// GetContextStatusError returns a grpc status error if the context is canceled or expired, otherwise nil
+func GetContextStatusError(ctx context.Context, format string, args ...interface{}) error {
+	err := ctx.Err()
+	if err == nil {
+		return nil
+	}
+
+	code := status.FromContextError(err).Code()
+	return status.Errorf(code, "%s - %v", fmt.Sprintf(format, args...), err)
+}
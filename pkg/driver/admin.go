@@ -0,0 +1,194 @@
+package driver
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"strconv"
+
+	"github.com/cyverse/irods-csi-driver/pkg/client"
+	"github.com/cyverse/irods-csi-driver/pkg/common"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/klog"
+)
+
+// runAdminServer runs admin service for node operations, e.g., drain
+// it should listen on a unix domain socket as requests are not authenticated
+func (driver *Driver) runAdminServer() error {
+	scheme, addr, err := common.ParseCSIEndpoint(driver.config.AdminEndpoint)
+	if err != nil {
+		return err
+	}
+
+	listener, err := net.Listen(scheme, addr)
+	if err != nil {
+		return err
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/drain", driver.handleDrain)
+	mux.HandleFunc("/sync", driver.handleSync)
+	mux.HandleFunc("/plan", driver.handlePlan)
+	mux.HandleFunc("/approve", driver.handleApprove)
+
+	driver.adminServer = &http.Server{Handler: mux}
+
+	go func() {
+		klog.V(3).Infof("Listening for admin requests on address: %#v", listener.Addr())
+		err := driver.adminServer.Serve(listener)
+		if err != nil && err != http.ErrServerClosed {
+			klog.Errorf("Admin server error: %v", err)
+		}
+	}()
+
+	return nil
+}
+
+func (driver *Driver) handleDrain(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// drain must go on even if the client disconnects
+	ctx, cancel := context.WithTimeout(context.Background(), driver.config.DrainTimeout)
+	defer cancel()
+
+	report := driver.Drain(ctx)
+
+	w.Header().Set("Content-Type", "application/json")
+	if len(report.FailedVolumes) > 0 || len(report.UnflushedPaths) > 0 {
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+
+	err := json.NewEncoder(w).Encode(report)
+	if err != nil {
+		klog.Errorf("Failed to write drain report: %v", err)
+	}
+}
+
+// SyncReport is a result of on-demand sync of mounted volumes
+type SyncReport struct {
+	SyncedVolumes []string `json:"synced_volumes"`
+	// errors of volumes failed to sync, keyed by volume ID
+	FailedVolumes map[string]string `json:"failed_volumes"`
+}
+
+// handleSync syncs local changes of mounted volumes to iRODS without unmounting
+// syncs the volume given by "volume_id" query, or all mounted volumes if not given
+func (driver *Driver) handleSync(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	volIDs := client.ListSyncableVolumes()
+	if volID := r.URL.Query().Get("volume_id"); len(volID) > 0 {
+		volIDs = []string{volID}
+	}
+
+	report := &SyncReport{
+		SyncedVolumes: []string{},
+		FailedVolumes: map[string]string{},
+	}
+
+	notFound := false
+	for _, volID := range volIDs {
+		klog.V(4).Infof("Syncing volume %q on request", volID)
+
+		err := client.SyncVolume(r.Context(), volID)
+		if err != nil {
+			klog.Errorf("Failed to sync volume %q, %s", volID, err)
+			report.FailedVolumes[volID] = err.Error()
+			if status.Code(err) == codes.NotFound {
+				notFound = true
+			}
+			continue
+		}
+
+		report.SyncedVolumes = append(report.SyncedVolumes, volID)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if len(report.FailedVolumes) > 0 {
+		if notFound && len(volIDs) == 1 {
+			w.WriteHeader(http.StatusNotFound)
+		} else {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}
+
+	err := json.NewEncoder(w).Encode(report)
+	if err != nil {
+		klog.Errorf("Failed to write sync report: %v", err)
+	}
+}
+
+// handlePlan returns changes a sync of the volume given by "volume_id" query would make to iRODS
+func (driver *Driver) handlePlan(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	volID := r.URL.Query().Get("volume_id")
+	if len(volID) == 0 {
+		http.Error(w, "volume_id is required", http.StatusBadRequest)
+		return
+	}
+
+	plan, err := client.PlanVolumeSync(r.Context(), volID)
+	if err != nil {
+		klog.Errorf("Failed to plan sync of volume %q, %s", volID, err)
+		http.Error(w, err.Error(), getHTTPStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(plan)
+	if err != nil {
+		klog.Errorf("Failed to write sync plan: %v", err)
+	}
+}
+
+// handleApprove approves the next sync of the volume given by "volume_id" query that requires approval
+// "destructive" query is the number of entries deleted in the plan reviewed, the sync is not approved if it deletes more
+func (driver *Driver) handleApprove(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	volID := r.URL.Query().Get("volume_id")
+	if len(volID) == 0 {
+		http.Error(w, "volume_id is required", http.StatusBadRequest)
+		return
+	}
+
+	destructive, err := strconv.Atoi(r.URL.Query().Get("destructive"))
+	if err != nil {
+		http.Error(w, "destructive is required, the number of entries deleted in the plan reviewed", http.StatusBadRequest)
+		return
+	}
+
+	err = client.ApproveVolumeSync(volID, destructive)
+	if err != nil {
+		klog.Errorf("Failed to approve sync of volume %q, %s", volID, err)
+		http.Error(w, err.Error(), getHTTPStatus(err))
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func getHTTPStatus(err error) int {
+	switch status.Code(err) {
+	case codes.NotFound:
+		return http.StatusNotFound
+	case codes.InvalidArgument:
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
@@ -0,0 +1,148 @@
+package driver
+
+import (
+	"os"
+	"path"
+	"strings"
+
+	"github.com/cyverse/irods-csi-driver/pkg/common"
+	"golang.org/x/xerrors"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"gopkg.in/yaml.v3"
+)
+
+// ClientUserMappingRule maps pod identity to iRODS client user
+// namespace and service account accept glob patterns, empty string matches any
+type ClientUserMappingRule struct {
+	Namespace      string `yaml:"namespace" json:"namespace"`
+	ServiceAccount string `yaml:"service_account" json:"service_account"`
+	ClientUser     string `yaml:"client_user" json:"client_user"`
+}
+
+func (rule *ClientUserMappingRule) match(namespace string, serviceAccount string) bool {
+	return matchPattern(rule.Namespace, namespace) && matchPattern(rule.ServiceAccount, serviceAccount)
+}
+
+func matchPattern(pattern string, value string) bool {
+	if len(pattern) == 0 || pattern == "*" {
+		return true
+	}
+
+	matched, err := path.Match(pattern, value)
+	if err != nil {
+		return false
+	}
+	return matched
+}
+
+// ClientUserMapper maps pod identity to iRODS client user
+type ClientUserMapper struct {
+	rules []ClientUserMappingRule
+}
+
+// NewClientUserMapper creates a new ClientUserMapper
+func NewClientUserMapper(rules []ClientUserMappingRule) *ClientUserMapper {
+	return &ClientUserMapper{
+		rules: rules,
+	}
+}
+
+// NewClientUserMapperFromSecrets creates a new ClientUserMapper from driver secrets
+// rules are given in yaml or json via "client_user_mapping", or a path to a file via "client_user_mapping_file"
+func NewClientUserMapperFromSecrets(secrets map[string]string) (*ClientUserMapper, error) {
+	rules := []ClientUserMappingRule{}
+
+	for k, v := range secrets {
+		switch common.NormalizeConfigKey(k) {
+		case common.NormalizeConfigKey("client_user_mapping"):
+			fileRules, err := parseClientUserMappingRules([]byte(v))
+			if err != nil {
+				return nil, xerrors.Errorf("failed to parse client user mapping: %w", err)
+			}
+			rules = append(rules, fileRules...)
+		case common.NormalizeConfigKey("client_user_mapping_file"):
+			data, err := os.ReadFile(v)
+			if err != nil {
+				return nil, xerrors.Errorf("failed to read client user mapping file %q: %w", v, err)
+			}
+
+			fileRules, err := parseClientUserMappingRules(data)
+			if err != nil {
+				return nil, xerrors.Errorf("failed to parse client user mapping file %q: %w", v, err)
+			}
+			rules = append(rules, fileRules...)
+		default:
+			// ignore
+		}
+	}
+
+	return NewClientUserMapper(rules), nil
+}
+
+func parseClientUserMappingRules(data []byte) ([]ClientUserMappingRule, error) {
+	rules := []ClientUserMappingRule{}
+	if len(strings.TrimSpace(string(data))) == 0 {
+		return rules, nil
+	}
+
+	// json is also a valid yaml
+	err := yaml.Unmarshal(data, &rules)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, rule := range rules {
+		if len(rule.ClientUser) == 0 {
+			return nil, xerrors.Errorf("client user is not given for namespace %q, service account %q", rule.Namespace, rule.ServiceAccount)
+		}
+
+		if _, err := path.Match(rule.Namespace, ""); err != nil {
+			return nil, xerrors.Errorf("invalid namespace pattern %q: %w", rule.Namespace, err)
+		}
+
+		if _, err := path.Match(rule.ServiceAccount, ""); err != nil {
+			return nil, xerrors.Errorf("invalid service account pattern %q: %w", rule.ServiceAccount, err)
+		}
+	}
+
+	return rules, nil
+}
+
+// IsEnabled checks if any mapping rule is given
+func (mapper *ClientUserMapper) IsEnabled() bool {
+	return mapper != nil && len(mapper.rules) > 0
+}
+
+// GetClientUser returns iRODS client user mapped from pod namespace and service account, the first matching rule wins
+func (mapper *ClientUserMapper) GetClientUser(namespace string, serviceAccount string) (string, error) {
+	if len(namespace) == 0 {
+		return "", status.Error(codes.PermissionDenied, "Pod namespace is not available to map client user, podInfoOnMount must be enabled")
+	}
+
+	for _, rule := range mapper.rules {
+		if rule.match(namespace, serviceAccount) {
+			return rule.ClientUser, nil
+		}
+	}
+
+	return "", status.Errorf(codes.PermissionDenied, "No client user mapping found for namespace %q, service account %q", namespace, serviceAccount)
+}
+
+// applyClientUserMapping overrides client user in configs with the one mapped from pod identity in volume context
+func (driver *Driver) applyClientUserMapping(volContext map[string]string, configs map[string]string) error {
+	if !driver.clientUserMapper.IsEnabled() {
+		return nil
+	}
+
+	clientUser, err := driver.clientUserMapper.GetClientUser(volContext[podInfoNamespaceKey], volContext[podInfoServiceAccountKey])
+	if err != nil {
+		return err
+	}
+
+	// remove all aliases so the volume attributes can't override the mapped user
+	delete(configs, common.NormalizeConfigKey("irods_client_user_name"))
+	delete(configs, common.NormalizeConfigKey("client_user_name"))
+	configs[common.NormalizeConfigKey("client_user")] = clientUser
+	return nil
+}
@@ -8,10 +8,28 @@ import (
 	"strconv"
 	"strings"
 
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	client_common "github.com/cyverse/irods-csi-driver/pkg/client/common"
 	"github.com/cyverse/irods-csi-driver/pkg/common"
 	"github.com/cyverse/irods-csi-driver/pkg/mounter"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	"k8s.io/klog"
+)
+
+const (
+	// pod info passed to NodePublishVolume by kubelet
+	podInfoEphemeralKey      string = "csi.storage.k8s.io/ephemeral"
+	podInfoNamespaceKey      string = "csi.storage.k8s.io/pod.namespace"
+	podInfoServiceAccountKey string = "csi.storage.k8s.io/serviceAccount.name"
+	podInfoNameKey           string = "csi.storage.k8s.io/pod.name"
+	// service account tokens passed to NodePublishVolume by kubelet when tokenRequests is set in CSIDriver
+	podInfoServiceAccountTokensKey string = "csi.storage.k8s.io/serviceAccount.tokens"
+	// pvc info passed to CreateVolume by external-provisioner when --extra-create-metadata is set
+	// these are copied to volume context, so available in node too
+	pvcNameKey      string = "csi.storage.k8s.io/pvc/name"
+	pvcNamespaceKey string = "csi.storage.k8s.io/pvc/namespace"
+	pvNameKey       string = "csi.storage.k8s.io/pv/name"
 )
 
 // readSecrets reads secrets from secret volume mount
@@ -59,10 +77,55 @@ func isDynamicVolumeProvisioningMode(volContext map[string]string) bool {
 	return false
 }
 
+// isEphemeralVolume checks if the volume is a CSI ephemeral inline volume
+func isEphemeralVolume(volContext map[string]string) bool {
+	ephemeral, _ := strconv.ParseBool(volContext[podInfoEphemeralKey])
+	return ephemeral
+}
+
 func setDynamicVolumeProvisioningMode(volContext map[string]string) {
 	volContext[common.NormalizeConfigKey("provisioning_mode")] = "dynamic"
 }
 
+// isSharedMountDisabled checks if static volumes should be mounted per publish rather than shared at staging path
+func isSharedMountDisabled(configs map[string]string) bool {
+	disabled, _ := strconv.ParseBool(configs[common.NormalizeConfigKey("no_shared_mount")])
+	return disabled
+}
+
+// isPublishMountRequired checks if static volumes must be mounted at NodePublishVolume rather than shared at staging path
+// client user mapping and token exchange require pod info, which is only given at NodePublishVolume
+func (driver *Driver) isPublishMountRequired(configs map[string]string) bool {
+	return isSharedMountDisabled(configs) || driver.clientUserMapper.IsEnabled() || driver.isTokenExchangeEnabled()
+}
+
+// applyVolumeMountGroup applies volume mount group (fsGroup) given by CO to configs and mount options
+// irodsfuse squashes file ownership to the group, webdav mounts with the group, nfs leaves ownership to the server
+func applyVolumeMountGroup(volCap *csi.VolumeCapability, configs map[string]string, mountOptions []string) ([]string, error) {
+	volumeMountGroup := volCap.GetMount().GetVolumeMountGroup()
+	if len(volumeMountGroup) == 0 {
+		return mountOptions, nil
+	}
+
+	gid, err := strconv.ParseUint(volumeMountGroup, 10, 32)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "Volume mount group %q must be a valid gid number - %v", volumeMountGroup, err)
+	}
+
+	switch client_common.GetClientType(configs) {
+	case client_common.IrodsFuseClientType:
+		// remove all aliases so the volume attributes can't override the group
+		delete(configs, common.NormalizeConfigKey("group_id"))
+		configs[common.NormalizeConfigKey("gid")] = strconv.FormatUint(gid, 10)
+	case client_common.WebdavClientType:
+		mountOptions = append(mountOptions, fmt.Sprintf("gid=%d", gid))
+	default:
+		klog.V(4).Infof("Volume mount group %q is ignored for client type %q", volumeMountGroup, client_common.GetClientType(configs))
+	}
+
+	return mountOptions, nil
+}
+
 // ControllerConfig is a controller config struct
 type ControllerConfig struct {
 	VolumeRootPath     string
@@ -57,6 +57,11 @@ func (driver *Driver) CreateVolume(ctx context.Context, req *csi.CreateVolumeReq
 
 	klog.V(4).Infof("CreateVolume: volumeName(%#v)", volName)
 
+	if !driver.volumeLocks.TryAcquire(volName) {
+		return nil, status.Errorf(codes.Aborted, volumeOperationAlreadyExistsFmt, volName)
+	}
+	defer driver.volumeLocks.Release(volName)
+
 	volCaps := req.GetVolumeCapabilities()
 	if len(volCaps) == 0 {
 		metrics.IncreaseCounterForVolumeMountFailures()
@@ -108,10 +113,15 @@ func (driver *Driver) CreateVolume(ctx context.Context, req *csi.CreateVolumeReq
 	if !controllerConfig.NotCreateVolumeDir {
 		// create
 		klog.V(5).Infof("Creating a volume dir %q", controllerConfig.VolumePath)
-		err = irods.Mkdir(irodsConnectionInfo, controllerConfig.VolumePath)
+		err = irods.Mkdir(ctx, irodsConnectionInfo, controllerConfig.VolumePath)
 		if err != nil {
 			metrics.IncreaseCounterForVolumeMountFailures()
-			return nil, status.Errorf(codes.Internal, "Could not create a volume dir %q : %v", controllerConfig.VolumePath, err)
+			if ctxErr := common.GetContextStatusError(ctx, "Creating a volume dir %q was interrupted", controllerConfig.VolumePath); ctxErr != nil {
+				return nil, ctxErr
+			}
+			statusErr := irods.GetStatusError(err, "Could not create a volume dir %q", controllerConfig.VolumePath)
+			driver.recordProvisioningFailure(controllerConfig.VolumePath, configs, statusErr)
+			return nil, statusErr
 		}
 	}
 
@@ -164,6 +174,11 @@ func (driver *Driver) DeleteVolume(ctx context.Context, req *csi.DeleteVolumeReq
 
 	klog.V(4).Infof("DeleteVolume: volumeId (%#v)", volID)
 
+	if !driver.volumeLocks.TryAcquire(volID) {
+		return nil, status.Errorf(codes.Aborted, volumeOperationAlreadyExistsFmt, volID)
+	}
+	defer driver.volumeLocks.Release(volID)
+
 	controllerVolume, err := driver.controllerVolumeManager.Pop(volID)
 	if err != nil {
 		return nil, err
@@ -178,9 +193,21 @@ func (driver *Driver) DeleteVolume(ctx context.Context, req *csi.DeleteVolumeReq
 
 	if !controllerVolume.RetainData {
 		klog.V(5).Infof("Deleting a volume dir %q", controllerVolume.Path)
-		err := irods.Rmdir(controllerVolume.ConnectionInfo, controllerVolume.Path)
+		err := irods.Rmdir(ctx, controllerVolume.ConnectionInfo, controllerVolume.Path)
 		if err != nil {
-			return nil, status.Errorf(codes.Internal, "Could not delete a volume dir %q: %v", controllerVolume.Path, err)
+			// put it back so a retry can delete the dir
+			putErr := driver.controllerVolumeManager.Put(controllerVolume)
+			if putErr != nil {
+				klog.Errorf("failed to restore controller volume %q, %s", volID, putErr)
+			}
+
+			if ctxErr := common.GetContextStatusError(ctx, "Deleting a volume dir %q was interrupted", controllerVolume.Path); ctxErr != nil {
+				return nil, ctxErr
+			}
+			statusErr := irods.GetStatusError(err, "Could not delete a volume dir %q", controllerVolume.Path)
+			// external-provisioner names pv after the volume name
+			driver.recordVolumeDeletionFailure(controllerVolume.Name, controllerVolume.Path, statusErr)
+			return nil, statusErr
 		}
 	}
 
@@ -275,3 +302,8 @@ func (driver *Driver) ListSnapshots(ctx context.Context, req *csi.ListSnapshotsR
 func (driver *Driver) ControllerExpandVolume(ctx context.Context, req *csi.ControllerExpandVolumeRequest) (*csi.ControllerExpandVolumeResponse, error) {
 	return nil, status.Error(codes.Unimplemented, "")
 }
+
+// ControllerGetVolume returns volume info
+func (driver *Driver) ControllerGetVolume(ctx context.Context, req *csi.ControllerGetVolumeRequest) (*csi.ControllerGetVolumeResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "")
+}
@@ -0,0 +1,153 @@
+package driver
+
+import (
+	"context"
+	"time"
+
+	"github.com/cyverse/irods-csi-driver/pkg/client"
+	client_common "github.com/cyverse/irods-csi-driver/pkg/client/common"
+	"github.com/cyverse/irods-csi-driver/pkg/metrics"
+	"github.com/cyverse/irods-csi-driver/pkg/mounter"
+	"github.com/cyverse/irods-csi-driver/pkg/volumeinfo"
+	"golang.org/x/xerrors"
+	"k8s.io/klog"
+)
+
+const (
+	drainLockPollInterval = 100 * time.Millisecond
+)
+
+// DrainReport is a result of node drain
+type DrainReport struct {
+	UnmountedVolumes []string `json:"unmounted_volumes"`
+	FailedVolumes    []string `json:"failed_volumes"`
+	// paths of data not flushed to iRODS, e.g., overlayfs uppers still syncing or failed to sync
+	UnflushedPaths []string `json:"unflushed_paths"`
+}
+
+// isDraining checks if the node is draining, new mounts are not accepted while draining
+func (driver *Driver) isDraining() bool {
+	return driver.draining.Load()
+}
+
+// Drain stops accepting new mounts, unmounts all tracked volumes and waits for syncs until ctx is done
+func (driver *Driver) Drain(ctx context.Context) *DrainReport {
+	driver.draining.Store(true)
+
+	klog.Infof("Draining node, not accepting new mounts")
+
+	report := &DrainReport{
+		UnmountedVolumes: []string{},
+		FailedVolumes:    []string{},
+		UnflushedPaths:   []string{},
+	}
+
+	for _, nodeVolume := range driver.nodeVolumeManager.List() {
+		err := driver.drainVolume(ctx, nodeVolume)
+		if err != nil {
+			klog.Errorf("Failed to drain volume %q, %s", nodeVolume.ID, err)
+			report.FailedVolumes = append(report.FailedVolumes, nodeVolume.ID)
+			continue
+		}
+
+		report.UnmountedVolumes = append(report.UnmountedVolumes, nodeVolume.ID)
+	}
+
+	klog.Infof("Waiting for syncs to complete")
+	report.UnflushedPaths = client.WaitForSyncs(ctx)
+
+	klog.Infof("Drain done: %d unmounted, %d failed, %d unflushed", len(report.UnmountedVolumes), len(report.FailedVolumes), len(report.UnflushedPaths))
+	for _, unflushedPath := range report.UnflushedPaths {
+		klog.Warningf("Data at %q is not flushed to iRODS", unflushedPath)
+	}
+
+	return report
+}
+
+func (driver *Driver) drainVolume(ctx context.Context, nodeVolume *volumeinfo.NodeVolume) error {
+	// wait for in-flight operations on the volume
+	for !driver.volumeLocks.TryAcquire(nodeVolume.ID) {
+		select {
+		case <-ctx.Done():
+			return xerrors.Errorf("timed out waiting for in-flight operations on volume %q", nodeVolume.ID)
+		case <-time.After(drainLockPollInterval):
+		}
+	}
+	defer driver.volumeLocks.Release(nodeVolume.ID)
+
+	clientType := client_common.GetValidClientType(nodeVolume.ClientType)
+
+	if nodeVolume.DynamicVolumeProvisioning || nodeVolume.HasStagingMount() {
+		// bind mounts of all pods must be gone before the staging mount is unmounted
+		for _, publishPath := range nodeVolume.GetPublishPaths() {
+			klog.V(5).Infof("Drain: bind unmounting %q", publishPath)
+			err := driver.mounter.UnmountWithAbort(publishPath)
+			if err != nil {
+				metrics.IncreaseCounterForVolumeUnmountFailures()
+				return xerrors.Errorf("failed to unmount %q: %w", publishPath, err)
+			}
+
+			metrics.IncreaseCounterForVolumeUnmount()
+			metrics.DecreaseCounterForActiveVolumeMount()
+
+			_, err = driver.nodeVolumeManager.Update(nodeVolume.ID, func(nodeVolume *volumeinfo.NodeVolume) {
+				nodeVolume.RemovePublishPath(publishPath)
+			})
+			if err != nil {
+				return err
+			}
+		}
+	} else if len(nodeVolume.MountPath) > 0 {
+		klog.V(5).Infof("Drain: unmounting %q", nodeVolume.MountPath)
+		err := client.UnmountClient(driver.mounter, nodeVolume.ID, clientType, nodeVolume.ClientConfig, nodeVolume.MountPath)
+		if err != nil {
+			return xerrors.Errorf("failed to unmount %q: %w", nodeVolume.MountPath, err)
+		}
+	}
+
+	if nodeVolume.HasStagingMount() {
+		// bind mounts not tracked, e.g., recorded by old versions, would keep serving pods from a torn down mount
+		bindPaths, err := getBindMountPaths(nodeVolume.StagingMountPath)
+		if err != nil {
+			return err
+		}
+
+		if len(bindPaths) > 0 {
+			return xerrors.Errorf("failed to unmount %q, still bind mounted at %v", nodeVolume.StagingMountPath, bindPaths)
+		}
+
+		klog.V(5).Infof("Drain: unmounting %q", nodeVolume.StagingMountPath)
+		err = client.UnmountClient(driver.mounter, nodeVolume.ID, clientType, nodeVolume.ClientConfig, nodeVolume.StagingMountPath)
+		if err != nil {
+			return xerrors.Errorf("failed to unmount %q: %w", nodeVolume.StagingMountPath, err)
+		}
+	}
+
+	_, err := driver.nodeVolumeManager.Pop(nodeVolume.ID)
+	return err
+}
+
+// getBindMountPaths returns mount points sharing the device of the mount, e.g., bind mounts
+func getBindMountPaths(mountPath string) ([]string, error) {
+	mountInfo, err := mounter.FindMountInfo(mountPath)
+	if err != nil {
+		return nil, err
+	}
+
+	if mountInfo == nil {
+		return []string{}, nil
+	}
+
+	mountInfos, err := mounter.ListMountInfo()
+	if err != nil {
+		return nil, err
+	}
+
+	bindPaths := []string{}
+	for _, other := range mountInfos {
+		if other.Major == mountInfo.Major && other.Minor == mountInfo.Minor && other.MountPoint != mountInfo.MountPoint {
+			bindPaths = append(bindPaths, other.MountPoint)
+		}
+	}
+	return bindPaths, nil
+}
@@ -27,13 +27,19 @@ package driver
 import (
 	"context"
 	"net"
+	"net/http"
+	"sync/atomic"
 
 	"google.golang.org/grpc"
 	"k8s.io/klog"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/cyverse/irods-csi-driver/pkg/client"
+	"github.com/cyverse/irods-csi-driver/pkg/client/irods"
 	"github.com/cyverse/irods-csi-driver/pkg/common"
+	"github.com/cyverse/irods-csi-driver/pkg/events"
 	"github.com/cyverse/irods-csi-driver/pkg/mounter"
+	"github.com/cyverse/irods-csi-driver/pkg/tokenexchange"
 	"github.com/cyverse/irods-csi-driver/pkg/volumeinfo"
 )
 
@@ -52,12 +58,24 @@ var (
 type Driver struct {
 	config *common.Config
 
-	server  *grpc.Server
-	mounter mounter.Mounter
-	secrets map[string]string
+	server      *grpc.Server
+	adminServer *http.Server
+	mounter     mounter.Mounter
+	secrets     map[string]string
+
+	draining atomic.Bool
+
+	eventRecorder       events.Recorder
+	volumeLocks         *VolumeLocks
+	mountLimiter        *client.MountLimiter
+	mountFlagPolicy     *MountFlagPolicy
+	clientUserMapper    *ClientUserMapper
+	tokenExchangeConfig *tokenexchange.Config
+	tokenExchanger      tokenexchange.Exchanger
 
 	controllerVolumeManager *volumeinfo.ControllerVolumeManager
 	nodeVolumeManager       *volumeinfo.NodeVolumeManager
+	overlayFSSyncManager    *volumeinfo.OverlayFSSyncManager
 }
 
 // NewDriver returns new driver
@@ -67,6 +85,8 @@ func NewDriver(conf *common.Config) (*Driver, error) {
 		mounter: mounter.NewNodeMounter(),
 		secrets: make(map[string]string),
 
+		volumeLocks: NewVolumeLocks(),
+
 		controllerVolumeManager: nil,
 		nodeVolumeManager:       nil,
 	}
@@ -82,6 +102,53 @@ func NewDriver(conf *common.Config) (*Driver, error) {
 		}
 	}
 
+	retryPolicy, err := irods.NewRetryPolicyFromConfigs(driver.secrets)
+	if err != nil {
+		return nil, err
+	}
+
+	irods.SetRetryPolicy(retryPolicy)
+
+	driver.eventRecorder = events.NewRecorder(eventComponentName, conf.NodeID)
+	irods.SetOverlayFSSyncFailureHandler(driver.recordOverlayFSSyncFailure)
+
+	mountLimiter, err := client.NewMountLimiterFromConfigs(driver.secrets)
+	if err != nil {
+		return nil, err
+	}
+
+	driver.mountLimiter = mountLimiter
+
+	mountFlagPolicy, err := NewMountFlagPolicyFromSecrets(driver.secrets)
+	if err != nil {
+		return nil, err
+	}
+
+	driver.mountFlagPolicy = mountFlagPolicy
+
+	clientUserMapper, err := NewClientUserMapperFromSecrets(driver.secrets)
+	if err != nil {
+		return nil, err
+	}
+
+	driver.clientUserMapper = clientUserMapper
+
+	// token exchange is only configured via driver secrets, so volume attributes can't redirect tokens
+	tokenExchangeConfig, err := tokenexchange.GetConfig(driver.secrets)
+	if err != nil {
+		return nil, err
+	}
+
+	if tokenExchangeConfig != nil {
+		tokenExchanger, err := tokenexchange.NewExchanger(tokenExchangeConfig)
+		if err != nil {
+			return nil, err
+		}
+
+		driver.tokenExchangeConfig = tokenExchangeConfig
+		driver.tokenExchanger = tokenExchanger
+	}
+
 	volumeEncryptKey := "irodscsidriver_volume_2ce02bee-74ea-4b18-a440-472d9771f778"
 	for k, v := range driver.secrets {
 		if common.NormalizeConfigKey(k) == common.NormalizeConfigKey("volume_encrypt_key") {
@@ -100,11 +167,29 @@ func NewDriver(conf *common.Config) (*Driver, error) {
 		return nil, err
 	}
 
+	overlayFSSyncManager, err := volumeinfo.NewOverlayFSSyncManager(volumeEncryptKey, conf.StoragePath)
+	if err != nil {
+		return nil, err
+	}
+
 	// we need to recover crashed volumes if available
 	// but current csi driver does not bind-mount the path to the container
 
 	driver.controllerVolumeManager = controllerVolumeManager
 	driver.nodeVolumeManager = nodeVolumeManager
+	driver.overlayFSSyncManager = overlayFSSyncManager
+
+	irods.SetOverlayFSSyncJournal(overlayFSSyncManager)
+
+	if conf.IsNodeServiceEnabled() {
+		if conf.SweepOnStartup {
+			// clean up mounts and data left by crashes before serving requests
+			driver.sweepStaleVolumes()
+		}
+
+		// resume overlayfs syncs interrupted by restarts, uppers are kept by sweep
+		irods.ResumeOverlayFSSyncs()
+	}
 
 	return driver, nil
 }
@@ -139,6 +224,13 @@ func (driver *Driver) Run() error {
 	csi.RegisterControllerServer(driver.server, driver)
 	csi.RegisterNodeServer(driver.server, driver)
 
+	if len(driver.config.AdminEndpoint) > 0 {
+		err = driver.runAdminServer()
+		if err != nil {
+			return err
+		}
+	}
+
 	klog.V(3).Infof("Listening for connections on address: %#v", listener.Addr())
 	return driver.server.Serve(listener)
 }
@@ -146,5 +238,11 @@ func (driver *Driver) Run() error {
 // Stop stops the driver service
 func (driver *Driver) Stop() {
 	klog.V(3).Infof("Stopping server")
-	driver.server.Stop()
+	if driver.adminServer != nil {
+		driver.adminServer.Shutdown(context.TODO())
+	}
+
+	if driver.server != nil {
+		driver.server.Stop()
+	}
 }
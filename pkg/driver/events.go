@@ -0,0 +1,148 @@
+package driver
+
+import (
+	"fmt"
+
+	"github.com/cyverse/irods-csi-driver/pkg/common"
+	"github.com/cyverse/irods-csi-driver/pkg/events"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	eventComponentName string = "irods-csi-driver"
+
+	eventReasonMountFailed          string = "MountFailed"
+	eventReasonOverlayFSSyncFailed  string = "OverlayFSSyncFailed"
+	eventReasonProvisioningFailed   string = "ProvisioningFailed"
+	eventReasonVolumeDeletionFailed string = "VolumeDeletionFailed"
+)
+
+// getPodReference returns the pod using the volume, configs must have pod info
+func getPodReference(configs map[string]string) *events.ObjectReference {
+	name := configs[common.NormalizeConfigKey(podInfoNameKey)]
+	namespace := configs[common.NormalizeConfigKey(podInfoNamespaceKey)]
+	if len(name) == 0 || len(namespace) == 0 {
+		return nil
+	}
+
+	return &events.ObjectReference{
+		Kind:      events.PodKind,
+		Namespace: namespace,
+		Name:      name,
+	}
+}
+
+// getPVCReference returns the pvc of the volume, configs must have pvc info
+func getPVCReference(configs map[string]string) *events.ObjectReference {
+	name := configs[common.NormalizeConfigKey(pvcNameKey)]
+	namespace := configs[common.NormalizeConfigKey(pvcNamespaceKey)]
+	if len(name) == 0 || len(namespace) == 0 {
+		return nil
+	}
+
+	return &events.ObjectReference{
+		Kind:      events.PersistentVolumeClaimKind,
+		Namespace: namespace,
+		Name:      name,
+	}
+}
+
+// getPVReference returns the pv of the volume, configs must have pv info
+func getPVReference(configs map[string]string) *events.ObjectReference {
+	name := configs[common.NormalizeConfigKey(pvNameKey)]
+	if len(name) == 0 {
+		return nil
+	}
+
+	return &events.ObjectReference{
+		Kind: events.PersistentVolumeKind,
+		Name: name,
+	}
+}
+
+// getEventReference returns the first available object to record events on
+func getEventReference(refs ...*events.ObjectReference) *events.ObjectReference {
+	for _, ref := range refs {
+		if ref != nil {
+			return ref
+		}
+	}
+	return nil
+}
+
+// getActionableHint returns a hint to resolve the error for users
+func (driver *Driver) getActionableHint(err error) string {
+	switch status.Code(err) {
+	case codes.InvalidArgument:
+		return "Check the volume attributes and secrets, e.g., host, port, zone, user, password and path"
+	case codes.PermissionDenied:
+		return "Check that the iRODS user has access to the path"
+	case codes.NotFound:
+		return "Check that the path exists in iRODS"
+	case codes.Unavailable:
+		return "The iRODS server may be down or unreachable from the node, check the server and the network, retries will follow"
+	case codes.DeadlineExceeded, codes.Canceled:
+		return "The operation timed out, the iRODS server may be slow or the node may have too many mounts in progress"
+	default:
+		return fmt.Sprintf("See logs of the iRODS CSI driver on node %q for details", driver.config.NodeID)
+	}
+}
+
+// getErrorMessage returns the message of the grpc status error
+func getErrorMessage(err error) string {
+	if st, ok := status.FromError(err); ok {
+		return st.Message()
+	}
+	return err.Error()
+}
+
+// recordMountFailure records a mount failure on the pod, or on the pvc/pv if the pod is unknown (e.g., staging)
+func (driver *Driver) recordMountFailure(volID string, configs map[string]string, err error) {
+	if status.Code(err) == codes.Aborted {
+		// another operation is in progress, not a failure
+		return
+	}
+
+	ref := getEventReference(getPodReference(configs), getPVCReference(configs), getPVReference(configs))
+	if ref == nil {
+		return
+	}
+
+	msg := fmt.Sprintf("Failed to mount iRODS volume %q on node %q: %s. %s", volID, driver.config.NodeID, getErrorMessage(err), driver.getActionableHint(err))
+	driver.eventRecorder.Event(ref, events.EventTypeWarning, eventReasonMountFailed, msg)
+}
+
+// recordOverlayFSSyncFailure records a failure of syncing overlayfs upper data to iRODS on the pvc/pv
+// the pod is usually gone when the sync runs after unmount
+func (driver *Driver) recordOverlayFSSyncFailure(volID string, configs map[string]string, upperPath string, err error) {
+	ref := getEventReference(getPVCReference(configs), getPVReference(configs), getPodReference(configs))
+	if ref == nil {
+		return
+	}
+
+	msg := fmt.Sprintf("Failed to sync changes of iRODS volume %q to iRODS: %s. Unsynced changes are kept on node %q at %q, check that the iRODS server is available and the user can write to the path, then copy the changes manually", volID, getErrorMessage(err), driver.config.NodeID, upperPath)
+	driver.eventRecorder.Event(ref, events.EventTypeWarning, eventReasonOverlayFSSyncFailed, msg)
+}
+
+// recordProvisioningFailure records a failure of volume creation on the pvc
+func (driver *Driver) recordProvisioningFailure(volPath string, configs map[string]string, err error) {
+	ref := getPVCReference(configs)
+	if ref == nil {
+		return
+	}
+
+	msg := fmt.Sprintf("Failed to create iRODS volume dir %q: %s. %s", volPath, getErrorMessage(err), driver.getActionableHint(err))
+	driver.eventRecorder.Event(ref, events.EventTypeWarning, eventReasonProvisioningFailed, msg)
+}
+
+// recordVolumeDeletionFailure records a failure of volume deletion on the pv
+func (driver *Driver) recordVolumeDeletionFailure(pvName string, volPath string, err error) {
+	ref := &events.ObjectReference{
+		Kind: events.PersistentVolumeKind,
+		Name: pvName,
+	}
+
+	msg := fmt.Sprintf("Failed to delete iRODS volume dir %q: %s. %s, or delete the dir manually", volPath, getErrorMessage(err), driver.getActionableHint(err))
+	driver.eventRecorder.Event(ref, events.EventTypeWarning, eventReasonVolumeDeletionFailed, msg)
+}
@@ -0,0 +1,127 @@
+package driver
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/cyverse/irods-csi-driver/pkg/common"
+	"github.com/cyverse/irods-csi-driver/pkg/events"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func newTestEventDriver() (*Driver, *events.FakeRecorder) {
+	recorder := events.NewFakeRecorder()
+	return &Driver{
+		config: &common.Config{
+			NodeID: "node1",
+		},
+		eventRecorder: recorder,
+	}, recorder
+}
+
+func TestRecordMountFailure(t *testing.T) {
+	podConfigs := map[string]string{
+		common.NormalizeConfigKey(podInfoNameKey):      "pod1",
+		common.NormalizeConfigKey(podInfoNamespaceKey): "ns",
+		common.NormalizeConfigKey(pvcNameKey):          "pvc1",
+		common.NormalizeConfigKey(pvcNamespaceKey):     "ns",
+		common.NormalizeConfigKey(pvNameKey):           "pv1",
+	}
+
+	pvConfigs := map[string]string{
+		common.NormalizeConfigKey(pvNameKey): "pv1",
+	}
+
+	testCases := []struct {
+		name     string
+		configs  map[string]string
+		err      error
+		expected *events.ObjectReference
+		hint     string
+	}{
+		{
+			name:     "pod",
+			configs:  podConfigs,
+			err:      status.Error(codes.PermissionDenied, "access denied"),
+			expected: &events.ObjectReference{Kind: events.PodKind, Namespace: "ns", Name: "pod1"},
+			hint:     "Check that the iRODS user has access to the path",
+		},
+		{
+			name:     "pv without pod and pvc",
+			configs:  pvConfigs,
+			err:      status.Error(codes.Internal, "unknown error"),
+			expected: &events.ObjectReference{Kind: events.PersistentVolumeKind, Name: "pv1"},
+			hint:     `See logs of the iRODS CSI driver on node "node1"`,
+		},
+		{
+			name:    "aborted",
+			configs: podConfigs,
+			err:     status.Error(codes.Aborted, "operation in progress"),
+		},
+		{
+			name:    "no object",
+			configs: map[string]string{},
+			err:     status.Error(codes.Internal, "unknown error"),
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			driver, recorder := newTestEventDriver()
+			driver.recordMountFailure("vol1", testCase.configs, testCase.err)
+
+			recorded := recorder.GetEvents()
+			if testCase.expected == nil {
+				if len(recorded) != 0 {
+					t.Errorf("expected no event, got %+v", recorded)
+				}
+				return
+			}
+
+			if len(recorded) != 1 {
+				t.Fatalf("expected 1 event, got %d", len(recorded))
+			}
+
+			event := recorded[0]
+			if event.Object != *testCase.expected {
+				t.Errorf("expected event on %s, got %s", testCase.expected, &event.Object)
+			}
+
+			if event.Type != events.EventTypeWarning || event.Reason != eventReasonMountFailed {
+				t.Errorf("unexpected event %+v", event)
+			}
+
+			if !strings.Contains(event.Message, getErrorMessage(testCase.err)) || !strings.Contains(event.Message, testCase.hint) {
+				t.Errorf("unexpected event message %q", event.Message)
+			}
+		})
+	}
+}
+
+func TestRecordOverlayFSSyncFailure(t *testing.T) {
+	configs := map[string]string{
+		common.NormalizeConfigKey(podInfoNameKey):      "pod1",
+		common.NormalizeConfigKey(podInfoNamespaceKey): "ns",
+		common.NormalizeConfigKey(pvcNameKey):          "pvc1",
+		common.NormalizeConfigKey(pvcNamespaceKey):     "ns",
+	}
+
+	driver, recorder := newTestEventDriver()
+	driver.recordOverlayFSSyncFailure("vol1", configs, "/upper", status.Error(codes.Unavailable, "server down"))
+
+	recorded := recorder.GetEvents()
+	if len(recorded) != 1 {
+		t.Fatalf("expected 1 event, got %d", len(recorded))
+	}
+
+	// the pvc is preferred as the pod is usually gone
+	expected := events.ObjectReference{Kind: events.PersistentVolumeClaimKind, Namespace: "ns", Name: "pvc1"}
+	if recorded[0].Object != expected {
+		t.Errorf("expected event on %s, got %s", &expected, &recorded[0].Object)
+	}
+
+	if recorded[0].Reason != eventReasonOverlayFSSyncFailed || !strings.Contains(recorded[0].Message, `"/upper"`) {
+		t.Errorf("unexpected event %+v", recorded[0])
+	}
+}
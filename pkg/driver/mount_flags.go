@@ -0,0 +1,196 @@
+package driver
+
+import (
+	"strconv"
+	"strings"
+
+	client_common "github.com/cyverse/irods-csi-driver/pkg/client/common"
+	"github.com/cyverse/irods-csi-driver/pkg/common"
+	"golang.org/x/xerrors"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// mount flag value types used in allowed mount flag specs
+const (
+	mountFlagValueNone   string = ""
+	mountFlagValueUint   string = "uint"
+	mountFlagValueOctal  string = "octal"
+	mountFlagValueString string = "string"
+)
+
+var (
+	// mount flags allowed for all client types
+	defaultCommonMountFlags = []string{
+		"ro", "rw", "noexec", "nosuid", "nodev", "noatime", "nodiratime", "relatime",
+	}
+
+	// mount flags allowed per client type by default, given in "name" or "name=type" or "name=val1|val2" form
+	defaultMountFlags = map[client_common.ClientType][]string{
+		client_common.IrodsFuseClientType: {},
+		client_common.WebdavClientType: {
+			"uid=uint", "gid=uint", "file_mode=octal", "dir_mode=octal", "_netdev",
+		},
+		client_common.NfsClientType: {
+			"vers=3|4|4.0|4.1|4.2", "nfsvers=3|4|4.0|4.1|4.2", "proto=tcp|udp",
+			"rsize=uint", "wsize=uint", "timeo=uint", "retrans=uint", "actimeo=uint",
+			"hard", "soft", "nolock", "noac", "_netdev",
+		},
+	}
+
+	// mount flags set by the driver, users can't override them even if they are allowed by admin
+	reservedMountFlags = map[client_common.ClientType][]string{
+		client_common.IrodsFuseClientType: {"config", "mounttimeout", "allow_other"},
+		client_common.WebdavClientType:    {"conf", "username"},
+		client_common.NfsClientType:       {"port"},
+	}
+)
+
+// MountFlagPolicy validates mount flags given by users via PV or SC
+type MountFlagPolicy struct {
+	// client type -> flag name -> value specs, a value is valid if any spec accepts it
+	allowedFlags map[client_common.ClientType]map[string][]string
+}
+
+// NewMountFlagPolicy creates a new MountFlagPolicy
+// allowedFlags are allowed in addition to the default allowed mount flags of the client type
+// a value spec in allowedFlags widens the values allowed for a default flag
+func NewMountFlagPolicy(allowedFlags map[client_common.ClientType][]string) (*MountFlagPolicy, error) {
+	policy := &MountFlagPolicy{
+		allowedFlags: map[client_common.ClientType]map[string][]string{},
+	}
+
+	for clientType, defaultFlags := range defaultMountFlags {
+		flags := []string{}
+		flags = append(flags, defaultCommonMountFlags...)
+		flags = append(flags, defaultFlags...)
+		flags = append(flags, allowedFlags[clientType]...)
+
+		specs := map[string][]string{}
+		for _, flag := range flags {
+			name, valueSpec := splitMountFlag(flag)
+			if len(name) == 0 {
+				continue
+			}
+
+			if isReservedMountFlag(clientType, name) {
+				return nil, xerrors.Errorf("mount flag %q is reserved for client type %q", name, clientType)
+			}
+
+			specs[name] = append(specs[name], valueSpec)
+		}
+
+		policy.allowedFlags[clientType] = specs
+	}
+
+	return policy, nil
+}
+
+// NewMountFlagPolicyFromSecrets creates a new MountFlagPolicy from driver secrets
+// allowed flags are given in comma-separated list via "<client_type>_allowed_mount_flags"
+func NewMountFlagPolicyFromSecrets(secrets map[string]string) (*MountFlagPolicy, error) {
+	allowedFlags := map[client_common.ClientType][]string{}
+
+	for k, v := range secrets {
+		for clientType := range defaultMountFlags {
+			if common.NormalizeConfigKey(k) == common.NormalizeConfigKey(string(clientType)+"_allowed_mount_flags") {
+				flags := []string{}
+				for _, flag := range strings.Split(v, ",") {
+					flag = strings.TrimSpace(flag)
+					if len(flag) > 0 {
+						flags = append(flags, flag)
+					}
+				}
+				allowedFlags[clientType] = flags
+			}
+		}
+	}
+
+	return NewMountFlagPolicy(allowedFlags)
+}
+
+// Validate checks if all given mount flags are allowed for the client type
+func (policy *MountFlagPolicy) Validate(clientType client_common.ClientType, mountFlags []string) error {
+	specs, ok := policy.allowedFlags[clientType]
+	if !ok {
+		return status.Errorf(codes.InvalidArgument, "Unknown client type %q", clientType)
+	}
+
+	for _, mountFlag := range mountFlags {
+		// a mount flag may have multiple options separated by comma
+		for _, option := range strings.Split(mountFlag, ",") {
+			option = strings.TrimSpace(option)
+			if len(option) == 0 {
+				continue
+			}
+
+			name, value := splitMountFlag(option)
+			if isReservedMountFlag(clientType, name) {
+				return status.Errorf(codes.InvalidArgument, "Mount flag %q is reserved for client type %q", name, clientType)
+			}
+
+			valueSpecs, ok := specs[name]
+			if !ok {
+				return status.Errorf(codes.InvalidArgument, "Mount flag %q is not allowed for client type %q", name, clientType)
+			}
+
+			if !isValidMountFlagValue(valueSpecs, option, value) {
+				return status.Errorf(codes.InvalidArgument, "Mount flag %q has an invalid value, expected %q", option, strings.Join(valueSpecs, " or "))
+			}
+		}
+	}
+
+	return nil
+}
+
+func splitMountFlag(flag string) (string, string) {
+	name, value, _ := strings.Cut(strings.TrimSpace(flag), "=")
+	return strings.TrimSpace(name), strings.TrimSpace(value)
+}
+
+func isReservedMountFlag(clientType client_common.ClientType, name string) bool {
+	for _, reserved := range reservedMountFlags[clientType] {
+		if reserved == name {
+			return true
+		}
+	}
+	return false
+}
+
+func isValidMountFlagValue(valueSpecs []string, option string, value string) bool {
+	for _, valueSpec := range valueSpecs {
+		if isValidMountFlagValueSpec(valueSpec, option, value) {
+			return true
+		}
+	}
+	return false
+}
+
+func isValidMountFlagValueSpec(valueSpec string, option string, value string) bool {
+	hasValue := strings.Contains(option, "=")
+
+	switch valueSpec {
+	case mountFlagValueNone:
+		return !hasValue
+	case mountFlagValueUint:
+		_, err := strconv.ParseUint(value, 10, 32)
+		return hasValue && err == nil
+	case mountFlagValueOctal:
+		_, err := strconv.ParseUint(value, 8, 32)
+		return hasValue && err == nil
+	case mountFlagValueString:
+		return hasValue && len(value) > 0
+	default:
+		// enumeration of allowed values
+		if !hasValue {
+			return false
+		}
+
+		for _, allowed := range strings.Split(valueSpec, "|") {
+			if allowed == value {
+				return true
+			}
+		}
+		return false
+	}
+}
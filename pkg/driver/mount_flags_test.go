@@ -0,0 +1,93 @@
+package driver
+
+import (
+	"testing"
+
+	client_common "github.com/cyverse/irods-csi-driver/pkg/client/common"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestMountFlagPolicyDefault(t *testing.T) {
+	policy, err := NewMountFlagPolicyFromSecrets(map[string]string{})
+	if err != nil {
+		t.Fatalf("failed to create mount flag policy: %v", err)
+	}
+
+	testCases := []struct {
+		name       string
+		clientType client_common.ClientType
+		mountFlags []string
+		valid      bool
+	}{
+		{name: "common flags", clientType: client_common.IrodsFuseClientType, mountFlags: []string{"ro", "noatime,nosuid"}, valid: true},
+		{name: "unknown flag", clientType: client_common.IrodsFuseClientType, mountFlags: []string{"foo"}},
+		{name: "unknown flag with value", clientType: client_common.IrodsFuseClientType, mountFlags: []string{"foo=bar"}},
+		{name: "unknown flag among known flags", clientType: client_common.NfsClientType, mountFlags: []string{"hard,foo"}},
+		{name: "reserved flag", clientType: client_common.IrodsFuseClientType, mountFlags: []string{"allow_other"}},
+		{name: "flag of another client type", clientType: client_common.IrodsFuseClientType, mountFlags: []string{"uid=1000"}},
+		{name: "uint value", clientType: client_common.WebdavClientType, mountFlags: []string{"uid=1000", "gid=1000"}, valid: true},
+		{name: "invalid uint value", clientType: client_common.WebdavClientType, mountFlags: []string{"uid=root"}},
+		{name: "octal value", clientType: client_common.WebdavClientType, mountFlags: []string{"file_mode=0644"}, valid: true},
+		{name: "invalid octal value", clientType: client_common.WebdavClientType, mountFlags: []string{"file_mode=0999"}},
+		{name: "enumerated value", clientType: client_common.NfsClientType, mountFlags: []string{"vers=4.1"}, valid: true},
+		{name: "value not enumerated", clientType: client_common.NfsClientType, mountFlags: []string{"vers=2"}},
+		{name: "missing value", clientType: client_common.NfsClientType, mountFlags: []string{"rsize"}},
+		{name: "unexpected value", clientType: client_common.NfsClientType, mountFlags: []string{"hard=1"}},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			err := policy.Validate(testCase.clientType, testCase.mountFlags)
+			if testCase.valid {
+				if err != nil {
+					t.Errorf("expected %q to be allowed, got %v", testCase.mountFlags, err)
+				}
+				return
+			}
+
+			if status.Code(err) != codes.InvalidArgument {
+				t.Errorf("expected %s for %q, got %v", codes.InvalidArgument, testCase.mountFlags, err)
+			}
+		})
+	}
+}
+
+func TestMountFlagPolicyAdminFlags(t *testing.T) {
+	policy, err := NewMountFlagPolicyFromSecrets(map[string]string{
+		"nfsAllowedMountFlags": "lookupcache=all|none, vers=3.0",
+	})
+	if err != nil {
+		t.Fatalf("failed to create mount flag policy: %v", err)
+	}
+
+	// admin flags widen the default allowlist
+	for _, mountFlag := range []string{"lookupcache=none", "vers=3.0", "vers=4.2", "hard", "ro"} {
+		err = policy.Validate(client_common.NfsClientType, []string{mountFlag})
+		if err != nil {
+			t.Errorf("expected %q to be allowed, got %v", mountFlag, err)
+		}
+	}
+
+	for _, mountFlag := range []string{"lookupcache=positive", "foo"} {
+		err = policy.Validate(client_common.NfsClientType, []string{mountFlag})
+		if status.Code(err) != codes.InvalidArgument {
+			t.Errorf("expected %s for %q, got %v", codes.InvalidArgument, mountFlag, err)
+		}
+	}
+
+	// other client types keep the default allowlist
+	err = policy.Validate(client_common.WebdavClientType, []string{"lookupcache=none"})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("expected %s, got %v", codes.InvalidArgument, err)
+	}
+}
+
+func TestMountFlagPolicyReservedAdminFlags(t *testing.T) {
+	_, err := NewMountFlagPolicyFromSecrets(map[string]string{
+		"irodsfuse_allowed_mount_flags": "allow_other",
+	})
+	if err == nil {
+		t.Errorf("expected an error for allowing a reserved flag")
+	}
+}
@@ -29,6 +29,7 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"slices"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
 	"github.com/cyverse/irods-csi-driver/pkg/client"
@@ -45,6 +46,7 @@ import (
 var (
 	nodeCaps = []csi.NodeServiceCapability_RPC_Type{
 		csi.NodeServiceCapability_RPC_STAGE_UNSTAGE_VOLUME,
+		csi.NodeServiceCapability_RPC_VOLUME_MOUNT_GROUP,
 	}
 )
 
@@ -58,9 +60,23 @@ func (driver *Driver) NodeStageVolume(ctx context.Context, req *csi.NodeStageVol
 
 	klog.V(4).Infof("NodeStageVolume: volumeId (%#v)", volID)
 
-	if !isDynamicVolumeProvisioningMode(req.GetVolumeContext()) {
-		// if it is static volume provisioning, just return quick.
-		// nothing to do.
+	if driver.isDraining() {
+		metrics.IncreaseCounterForVolumeMountFailures()
+		return nil, status.Error(codes.Unavailable, "Node is draining, not accepting new mounts")
+	}
+
+	if !driver.volumeLocks.TryAcquire(volID) {
+		return nil, status.Errorf(codes.Aborted, volumeOperationAlreadyExistsFmt, volID)
+	}
+	defer driver.volumeLocks.Release(volID)
+
+	// merge params
+	configs := common.MergeConfig(driver.config, driver.secrets, req.GetSecrets(), req.GetVolumeContext())
+	dynamicVolumeProvisioning := isDynamicVolumeProvisioningMode(req.GetVolumeContext())
+
+	if !dynamicVolumeProvisioning && driver.isPublishMountRequired(configs) {
+		// if it is static volume provisioning and shared mount is not possible,
+		// just return quick. the volume will be mounted at NodePublishVolume.
 		nodeVolume := &volumeinfo.NodeVolume{
 			ID:                        volID,
 			StagingMountPath:          "",
@@ -81,7 +97,7 @@ func (driver *Driver) NodeStageVolume(ctx context.Context, req *csi.NodeStageVol
 		return &csi.NodeStageVolumeResponse{}, nil
 	}
 
-	// only for dynamic volume provisioning mode
+	// mount once at staging path and share the mount via bind mounts
 	targetPath := req.GetStagingTargetPath()
 	if len(targetPath) == 0 {
 		metrics.IncreaseCounterForVolumeMountFailures()
@@ -99,7 +115,18 @@ func (driver *Driver) NodeStageVolume(ctx context.Context, req *csi.NodeStageVol
 		return nil, status.Error(codes.InvalidArgument, "Volume capability not supported")
 	}
 
+	err := driver.mountFlagPolicy.Validate(client_common.GetClientType(configs), volCap.GetMount().GetMountFlags())
+	if err != nil {
+		metrics.IncreaseCounterForVolumeMountFailures()
+		return nil, err
+	}
+
 	mountOptions := mounter.GetMountOptions(volCap.GetMount(), volCap.GetAccessMode())
+	mountOptions, err = applyVolumeMountGroup(volCap, configs, mountOptions)
+	if err != nil {
+		metrics.IncreaseCounterForVolumeMountFailures()
+		return nil, err
+	}
 
 	pathExist, pathExistErr := mounter.PathExists(targetPath)
 	if pathExistErr != nil {
@@ -126,12 +153,11 @@ func (driver *Driver) NodeStageVolume(ctx context.Context, req *csi.NodeStageVol
 		return nil, status.Errorf(codes.Internal, "Staging target path %q is already mounted", targetPath)
 	}
 
-	// merge params
-	configs := common.MergeConfig(driver.config, driver.secrets, req.GetSecrets(), req.GetVolumeContext())
 	klog.V(5).Infof("NodeStageVolume: mounting %q", targetPath)
 
 	// mount
-	err = client.MountClient(driver.mounter, volID, configs, mountOptions, targetPath)
+	// write access is checked when pods publish the volume writable, pods may mount it read-only
+	err = driver.mountClient(ctx, volID, configs, mountOptions, targetPath, true)
 	if err != nil {
 		return nil, err
 	}
@@ -144,9 +170,10 @@ func (driver *Driver) NodeStageVolume(ctx context.Context, req *csi.NodeStageVol
 		MountPath:                 "",
 		StagingMountOptions:       mountOptions,
 		MountOptions:              []string{},
+		VolumeMountGroup:          volCap.GetMount().GetVolumeMountGroup(),
 		ClientType:                string(client_common.GetClientType(configs)),
 		ClientConfig:              configs,
-		DynamicVolumeProvisioning: true,
+		DynamicVolumeProvisioning: dynamicVolumeProvisioning,
 		StageVolume:               true,
 	}
 
@@ -169,6 +196,16 @@ func (driver *Driver) NodePublishVolume(ctx context.Context, req *csi.NodePublis
 
 	klog.V(4).Infof("NodePublishVolume: volumeId (%#v)", volID)
 
+	if driver.isDraining() {
+		metrics.IncreaseCounterForVolumeMountFailures()
+		return nil, status.Error(codes.Unavailable, "Node is draining, not accepting new mounts")
+	}
+
+	if !driver.volumeLocks.TryAcquire(volID) {
+		return nil, status.Errorf(codes.Aborted, volumeOperationAlreadyExistsFmt, volID)
+	}
+	defer driver.volumeLocks.Release(volID)
+
 	targetPath := req.GetTargetPath()
 	if len(targetPath) == 0 {
 		metrics.IncreaseCounterForVolumeMountFailures()
@@ -186,6 +223,15 @@ func (driver *Driver) NodePublishVolume(ctx context.Context, req *csi.NodePublis
 		return nil, status.Error(codes.InvalidArgument, "Volume capability not supported")
 	}
 
+	// merge params
+	configs := common.MergeConfig(driver.config, driver.secrets, req.GetSecrets(), req.GetVolumeContext())
+
+	err := driver.mountFlagPolicy.Validate(client_common.GetClientType(configs), volCap.GetMount().GetMountFlags())
+	if err != nil {
+		metrics.IncreaseCounterForVolumeMountFailures()
+		return nil, err
+	}
+
 	mountOptions := mounter.GetMountOptions(volCap.GetMount(), volCap.GetAccessMode())
 	if req.GetReadonly() {
 		mountOptions = append(mountOptions, "ro")
@@ -216,8 +262,62 @@ func (driver *Driver) NodePublishVolume(ctx context.Context, req *csi.NodePublis
 		return nil, status.Errorf(codes.Internal, "Staging target path %q is already mounted", targetPath)
 	}
 
-	if isDynamicVolumeProvisioningMode(req.GetVolumeContext()) {
-		// dynamic volume provisioning
+	stagedVolume := driver.nodeVolumeManager.Get(volID)
+	if isEphemeralVolume(req.GetVolumeContext()) {
+		// ephemeral inline volume
+		// inline volume attributes and node publish secrets are given
+		err = driver.applyClientUserMapping(req.GetVolumeContext(), configs)
+		if err != nil {
+			metrics.IncreaseCounterForVolumeMountFailures()
+			return nil, err
+		}
+
+		err = driver.applyTokenExchange(ctx, volID, req.GetVolumeContext(), configs)
+		if err != nil {
+			metrics.IncreaseCounterForVolumeMountFailures()
+			return nil, err
+		}
+
+		mountOptions, err = applyVolumeMountGroup(volCap, configs, mountOptions)
+		if err != nil {
+			metrics.IncreaseCounterForVolumeMountFailures()
+			return nil, err
+		}
+
+		irodsClientType := client_common.GetClientType(configs)
+		if irodsClientType != client_common.IrodsFuseClientType {
+			metrics.IncreaseCounterForVolumeMountFailures()
+			return nil, status.Errorf(codes.InvalidArgument, "unsupported driver type for ephemeral volume - %v", irodsClientType)
+		}
+
+		// mount
+		klog.V(5).Infof("NodePublishVolume: mounting ephemeral volume %q", targetPath)
+		err = driver.mountClient(ctx, volID, configs, mountOptions, targetPath, false)
+		if err != nil {
+			return nil, err
+		}
+
+		nodeVolume := &volumeinfo.NodeVolume{
+			ID:                        volID,
+			StagingMountPath:          "",
+			MountPath:                 targetPath,
+			PublishPaths:              []string{targetPath},
+			StagingMountOptions:       []string{},
+			MountOptions:              mountOptions,
+			ClientType:                string(irodsClientType),
+			ClientConfig:              configs,
+			DynamicVolumeProvisioning: false,
+			StageVolume:               false,
+			Ephemeral:                 true,
+		}
+
+		err = driver.nodeVolumeManager.Put(nodeVolume)
+		if err != nil {
+			metrics.IncreaseCounterForVolumeMountFailures()
+			return nil, err
+		}
+	} else if isDynamicVolumeProvisioningMode(req.GetVolumeContext()) || (stagedVolume != nil && stagedVolume.HasStagingMount()) {
+		// dynamic volume provisioning or static volume provisioning with shared mount
 		// bind mount
 		stagingTargetPath := req.GetStagingTargetPath()
 		if len(stagingTargetPath) == 0 {
@@ -225,43 +325,71 @@ func (driver *Driver) NodePublishVolume(ctx context.Context, req *csi.NodePublis
 			return nil, status.Error(codes.InvalidArgument, "Staging target path not provided")
 		}
 
+		// the volume mount group is applied to the staging mount, bind mounts can't change it
+		if stagedVolume != nil && volCap.GetMount().GetVolumeMountGroup() != stagedVolume.VolumeMountGroup {
+			metrics.IncreaseCounterForVolumeMountFailures()
+			return nil, status.Errorf(codes.InvalidArgument, "Volume mount group %q does not match %q of the shared mount of volume %q, pods sharing the mount must use the same fsGroup", volCap.GetMount().GetVolumeMountGroup(), stagedVolume.VolumeMountGroup, volID)
+		}
+
+		// the staging mount is checked for read access only, check write access for writable publishes
+		if stagedVolume != nil && !req.GetReadonly() && !slices.Contains(stagedVolume.StagingMountOptions, "ro") {
+			err = client.CheckClientPermissions(ctx, stagedVolume.ClientConfig, false)
+			if err != nil {
+				metrics.IncreaseCounterForVolumeMountFailures()
+				driver.recordMountFailure(volID, configs, err)
+				return nil, err
+			}
+		}
+
 		klog.V(5).Infof("NodePublishVolume: bind mounting %q", targetPath)
-		if err := mounter.MountBind(driver.mounter, stagingTargetPath, mountOptions, targetPath); err != nil {
+		if err := mounter.MountBind(ctx, driver.mounter, stagingTargetPath, mountOptions, targetPath); err != nil {
 			os.Remove(targetPath)
 			metrics.IncreaseCounterForVolumeMountFailures()
+			driver.recordMountFailure(volID, configs, err)
 			return nil, err
 		}
 
-		// update node volume info
-		nodeVolume, err := driver.nodeVolumeManager.Pop(volID)
+		// update node volume info, all bind mounts are tracked to unmount them before the staging mount
+		found, err := driver.nodeVolumeManager.Update(volID, func(nodeVolume *volumeinfo.NodeVolume) {
+			nodeVolume.MountPath = targetPath
+			nodeVolume.MountOptions = mountOptions
+			nodeVolume.AddPublishPath(targetPath)
+		})
 		if err != nil {
 			metrics.IncreaseCounterForVolumeMountFailures()
 			return nil, err
 		}
 
-		if nodeVolume == nil {
+		if !found {
 			metrics.IncreaseCounterForVolumeMountFailures()
 			return nil, status.Errorf(codes.InvalidArgument, "Unable to find node volume %q", volID)
 		}
 
-		nodeVolume.MountPath = targetPath
-		nodeVolume.MountOptions = mountOptions
-		err = driver.nodeVolumeManager.Put(nodeVolume)
+		metrics.IncreaseCounterForVolumeMount()
+		metrics.IncreaseCounterForActiveVolumeMount()
+	} else {
+		// static volume provisioning without shared mount
+		err = driver.applyClientUserMapping(req.GetVolumeContext(), configs)
 		if err != nil {
 			metrics.IncreaseCounterForVolumeMountFailures()
 			return nil, err
 		}
 
-		metrics.IncreaseCounterForVolumeMount()
-		metrics.IncreaseCounterForActiveVolumeMount()
-	} else {
-		// static volume provisioning
-		// merge params
-		configs := common.MergeConfig(driver.config, driver.secrets, req.GetSecrets(), req.GetVolumeContext())
+		err = driver.applyTokenExchange(ctx, volID, req.GetVolumeContext(), configs)
+		if err != nil {
+			metrics.IncreaseCounterForVolumeMountFailures()
+			return nil, err
+		}
+
+		mountOptions, err = applyVolumeMountGroup(volCap, configs, mountOptions)
+		if err != nil {
+			metrics.IncreaseCounterForVolumeMountFailures()
+			return nil, err
+		}
 
 		// mount
 		klog.V(5).Infof("NodePublishVolume: mounting %q", targetPath)
-		err = client.MountClient(driver.mounter, volID, configs, mountOptions, targetPath)
+		err = driver.mountClient(ctx, volID, configs, mountOptions, targetPath, false)
 		if err != nil {
 			return nil, err
 		}
@@ -278,6 +406,7 @@ func (driver *Driver) NodePublishVolume(ctx context.Context, req *csi.NodePublis
 				ID:                        volID,
 				StagingMountPath:          "",
 				MountPath:                 targetPath,
+				PublishPaths:              []string{targetPath},
 				StagingMountOptions:       []string{},
 				MountOptions:              mountOptions,
 				ClientType:                string(client_common.GetClientType(configs)),
@@ -287,6 +416,7 @@ func (driver *Driver) NodePublishVolume(ctx context.Context, req *csi.NodePublis
 			}
 		} else {
 			nodeVolume.MountPath = targetPath
+			nodeVolume.PublishPaths = []string{targetPath}
 			nodeVolume.MountOptions = mountOptions
 			nodeVolume.ClientType = string(client_common.GetClientType(configs))
 			nodeVolume.ClientConfig = configs
@@ -313,6 +443,11 @@ func (driver *Driver) NodeUnpublishVolume(ctx context.Context, req *csi.NodeUnpu
 
 	klog.V(4).Infof("NodeUnpublishVolume: volumeId (%#v)", volID)
 
+	if !driver.volumeLocks.TryAcquire(volID) {
+		return nil, status.Errorf(codes.Aborted, volumeOperationAlreadyExistsFmt, volID)
+	}
+	defer driver.volumeLocks.Release(volID)
+
 	nodeVolume := driver.nodeVolumeManager.Get(volID)
 	if nodeVolume == nil {
 		klog.Errorf("Unable to find node volume %q in the node volume manager, but we continue anyway", volID)
@@ -332,10 +467,19 @@ func (driver *Driver) NodeUnpublishVolume(ctx context.Context, req *csi.NodeUnpu
 		return nil, status.Error(codes.InvalidArgument, "Target path not provided")
 	}
 
-	// Check if target directory is a mount point. GetDeviceNameFromMount
-	// given a mnt point, finds the device from /proc/mounts
-	// returns the device name, reference count, and error code
-	_, refCount, err := driver.mounter.GetDeviceName(targetPath)
+	if nodeVolume != nil && nodeVolume.StageVolume {
+		_, err := driver.nodeVolumeManager.Update(volID, func(nodeVolume *volumeinfo.NodeVolume) {
+			nodeVolume.RemovePublishPath(targetPath)
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Check if target directory is a mount point.
+	// FindMountInfo reads /proc/self/mountinfo only without accessing the target,
+	// so corrupted or hung mounts are found as mounted too
+	mountInfo, err := mounter.FindMountInfo(targetPath)
 	if err != nil {
 		metrics.IncreaseCounterForVolumeUnmountFailures()
 		msg := fmt.Sprintf("failed to check if volume is mounted: %v", err)
@@ -345,8 +489,16 @@ func (driver *Driver) NodeUnpublishVolume(ctx context.Context, req *csi.NodeUnpu
 	// From the spec: If the volume corresponding to the volume_id
 	// is not staged to the staging_target_path, the Plugin MUST
 	// reply 0 OK.
-	if refCount == 0 {
+	if mountInfo == nil {
 		klog.V(5).Infof("NodeUnpublishVolume: %q target not mounted", targetPath)
+
+		if nodeVolume != nil && !nodeVolume.DynamicVolumeProvisioning && !nodeVolume.HasStagingMount() {
+			// the mount is gone, but leftover data may remain
+			err = client.CleanupClient(volID, client_common.GetValidClientType(nodeVolume.ClientType), nodeVolume.ClientConfig)
+			if err != nil {
+				klog.Errorf("Error cleaning up leftover data of volume %q, %s, ignoring", volID, err)
+			}
+		}
 		return &csi.NodeUnpublishVolumeResponse{}, nil
 	}
 
@@ -354,7 +506,7 @@ func (driver *Driver) NodeUnpublishVolume(ctx context.Context, req *csi.NodeUnpu
 	if nodeVolume == nil {
 		// unknown, lost record
 		klog.V(5).Infof("NodeUnpublishVolume: unmounting unknown volume %q", targetPath)
-		err = driver.mounter.Unmount(targetPath)
+		err = driver.mounter.UnmountWithAbort(targetPath)
 		if err != nil {
 			metrics.IncreaseCounterForVolumeUnmountFailures()
 			return nil, status.Errorf(codes.Internal, "failed to unmount %q: %v", targetPath, err)
@@ -362,10 +514,10 @@ func (driver *Driver) NodeUnpublishVolume(ctx context.Context, req *csi.NodeUnpu
 
 		metrics.IncreaseCounterForVolumeUnmount()
 		metrics.DecreaseCounterForActiveVolumeMount()
-	} else if nodeVolume.DynamicVolumeProvisioning {
+	} else if nodeVolume.DynamicVolumeProvisioning || nodeVolume.HasStagingMount() {
 		// unmount bind
 		klog.V(5).Infof("NodeUnpublishVolume: bind unmounting %q", targetPath)
-		err = driver.mounter.Unmount(targetPath)
+		err = driver.mounter.UnmountWithAbort(targetPath)
 		if err != nil {
 			metrics.IncreaseCounterForVolumeUnmountFailures()
 			return nil, status.Errorf(codes.Internal, "failed to unmount %q: %v", targetPath, err)
@@ -374,7 +526,7 @@ func (driver *Driver) NodeUnpublishVolume(ctx context.Context, req *csi.NodeUnpu
 		metrics.IncreaseCounterForVolumeUnmount()
 		metrics.DecreaseCounterForActiveVolumeMount()
 	} else {
-		// unmountClient
+		// unmountClient, ephemeral volumes and static volumes without shared mount
 		klog.V(5).Infof("NodeUnpublishVolume: unmounting %q", targetPath)
 		err = client.UnmountClient(driver.mounter, volID, client_common.GetValidClientType(nodeVolume.ClientType), nodeVolume.ClientConfig, targetPath)
 		if err != nil {
@@ -402,6 +554,11 @@ func (driver *Driver) NodeUnstageVolume(ctx context.Context, req *csi.NodeUnstag
 
 	klog.V(4).Infof("NodeUnstageVolume: volumeId (%#v)", volID)
 
+	if !driver.volumeLocks.TryAcquire(volID) {
+		return nil, status.Errorf(codes.Aborted, volumeOperationAlreadyExistsFmt, volID)
+	}
+	defer driver.volumeLocks.Release(volID)
+
 	nodeVolume := driver.nodeVolumeManager.Get(volID)
 	if nodeVolume == nil {
 		klog.Errorf("Unable to find node volume %q in the node volume manager, but we continue anyway", volID)
@@ -412,8 +569,8 @@ func (driver *Driver) NodeUnstageVolume(ctx context.Context, req *csi.NodeUnstag
 			return nil, err
 		}
 
-		if !nodeVolume.DynamicVolumeProvisioning {
-			// nothing to do for StaticCVolumeProvisioning
+		if !nodeVolume.DynamicVolumeProvisioning && !nodeVolume.HasStagingMount() {
+			// nothing to do for static volume provisioning without shared mount
 			return &csi.NodeUnstageVolumeResponse{}, nil
 		}
 	}
@@ -424,10 +581,10 @@ func (driver *Driver) NodeUnstageVolume(ctx context.Context, req *csi.NodeUnstag
 		return nil, status.Error(codes.InvalidArgument, "Staging target path not provided")
 	}
 
-	// Check if target directory is a mount point. GetDeviceNameFromMount
-	// given a mnt point, finds the device from /proc/mounts
-	// returns the device name, reference count, and error code
-	_, refCount, err := driver.mounter.GetDeviceName(targetPath)
+	// Check if target directory is a mount point.
+	// FindMountInfo reads /proc/self/mountinfo only without accessing the target,
+	// so corrupted or hung mounts are found as mounted too
+	mountInfo, err := mounter.FindMountInfo(targetPath)
 	if err != nil {
 		metrics.IncreaseCounterForVolumeUnmountFailures()
 		msg := fmt.Sprintf("failed to check if volume is mounted: %v", err)
@@ -437,14 +594,22 @@ func (driver *Driver) NodeUnstageVolume(ctx context.Context, req *csi.NodeUnstag
 	// From the spec: If the volume corresponding to the volume_id
 	// is not staged to the staging_target_path, the Plugin MUST
 	// reply 0 OK.
-	if refCount == 0 {
+	if mountInfo == nil {
 		klog.V(5).Infof("NodeUnstageVolume: %q target not mounted", targetPath)
+
+		if nodeVolume != nil {
+			// the mount is gone, but leftover data may remain
+			err = client.CleanupClient(volID, client_common.GetValidClientType(nodeVolume.ClientType), nodeVolume.ClientConfig)
+			if err != nil {
+				klog.Errorf("Error cleaning up leftover data of volume %q, %s, ignoring", volID, err)
+			}
+		}
 		return &csi.NodeUnstageVolumeResponse{}, nil
 	}
 
 	if nodeVolume == nil {
 		klog.V(5).Infof("NodeUnstageVolume: unmounting unknown volume %q", targetPath)
-		err = driver.mounter.Unmount(targetPath)
+		err = driver.mounter.UnmountWithAbort(targetPath)
 		if err != nil {
 			metrics.IncreaseCounterForVolumeUnmountFailures()
 			return nil, status.Errorf(codes.Internal, "failed to unmount %q: %v", targetPath, err)
@@ -500,3 +665,22 @@ func (driver *Driver) NodeGetInfo(ctx context.Context, req *csi.NodeGetInfoReque
 		NodeId: driver.config.NodeID,
 	}, nil
 }
+
+// mountClient mounts a fs client, waiting for concurrency limits of the node and the server
+// shared is true for staging mounts shared by pods via bind mounts
+func (driver *Driver) mountClient(ctx context.Context, volID string, configs map[string]string, mountOptions []string, targetPath string, shared bool) error {
+	release, err := driver.mountLimiter.Acquire(ctx, client.GetServerKey(configs))
+	if err != nil {
+		metrics.IncreaseCounterForVolumeMountFailures()
+		driver.recordMountFailure(volID, configs, err)
+		return err
+	}
+	defer release()
+
+	err = client.MountClient(ctx, driver.mounter, volID, configs, mountOptions, targetPath, shared)
+	if err != nil {
+		driver.recordMountFailure(volID, configs, err)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,240 @@
+package driver
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	client_common "github.com/cyverse/irods-csi-driver/pkg/client/common"
+	"github.com/cyverse/irods-csi-driver/pkg/mounter"
+	"k8s.io/klog"
+)
+
+const (
+	overlayFSLowerSuffix   string = "-overlayfs-lower"
+	overlayFSUpperSuffix   string = "-overlayfs-upper"
+	overlayFSWorkDirSuffix string = "-overlayfs-workdir"
+)
+
+var (
+	// path fragments of kubelet directories where CSI volumes are published or staged
+	kubeletCSIMountPathFragments = []string{
+		"/volumes/kubernetes.io~csi/",
+		"/plugins/kubernetes.io/csi/",
+	}
+)
+
+// SweepReport is a result of startup sweep
+type SweepReport struct {
+	UnmountedPaths []string
+	DeletedPaths   []string
+	// overlayfs uppers having unsynced data, kept for manual recovery
+	UnsyncedUpperPaths []string
+	FailedPaths        []string
+}
+
+// sweepStaleVolumes unmounts stale mounts and deletes leftover data under storage path not owned by tracked volumes
+func (driver *Driver) sweepStaleVolumes() *SweepReport {
+	report := &SweepReport{}
+
+	storagePath := driver.config.StoragePath
+	if len(storagePath) == 0 {
+		return report
+	}
+
+	ownedVolumeIDs := map[string]bool{}
+	ownedMountPaths := map[string]bool{}
+	for _, nodeVolume := range driver.nodeVolumeManager.List() {
+		ownedVolumeIDs[nodeVolume.ID] = true
+		// every pod has its own publish target, bind mounts of the staging mount for shared volumes
+		for _, publishPath := range nodeVolume.GetPublishPaths() {
+			ownedMountPaths[filepath.Clean(publishPath)] = true
+		}
+		if len(nodeVolume.StagingMountPath) > 0 {
+			ownedMountPaths[filepath.Clean(nodeVolume.StagingMountPath)] = true
+		}
+	}
+
+	// uppers of syncs interrupted by restarts are resumed after sweep
+	syncingVolumeIDs := map[string]bool{}
+	for _, record := range driver.overlayFSSyncManager.List() {
+		syncingVolumeIDs[record.VolumeID] = true
+	}
+
+	driver.sweepStaleMounts(storagePath, ownedVolumeIDs, ownedMountPaths, report)
+	driver.sweepLeftoverData(storagePath, ownedVolumeIDs, syncingVolumeIDs, report)
+
+	klog.Infof("Sweep done: %d unmounted, %d deleted, %d unsynced uppers kept, %d failed", len(report.UnmountedPaths), len(report.DeletedPaths), len(report.UnsyncedUpperPaths), len(report.FailedPaths))
+	for _, upperPath := range report.UnsyncedUpperPaths {
+		klog.Warningf("Overlayfs upper %q has unsynced data, kept for recovery", upperPath)
+	}
+
+	return report
+}
+
+func (driver *Driver) sweepStaleMounts(storagePath string, ownedVolumeIDs map[string]bool, ownedMountPaths map[string]bool, report *SweepReport) {
+	mountInfos, err := mounter.ListMountInfo()
+	if err != nil {
+		klog.Errorf("Failed to list mounts for sweep, %s, skipping", err)
+		return
+	}
+
+	// bind mounts have the device of their source, mounts sharing a device with an owned mount are owned
+	// it keeps bind mounts of owned staging mounts not recorded, e.g., recorded before publish paths were tracked
+	ownedDevices := map[string]bool{}
+	for _, mountInfo := range mountInfos {
+		if ownedMountPaths[filepath.Clean(mountInfo.MountPoint)] {
+			ownedDevices[getMountDevice(&mountInfo)] = true
+		}
+	}
+
+	// unmount in reverse order, so mounts stacked on top (e.g., overlay on lower) are unmounted first
+	for idx := len(mountInfos) - 1; idx >= 0; idx-- {
+		mountInfo := mountInfos[idx]
+		mountPath := filepath.Clean(mountInfo.MountPoint)
+
+		if ownedMountPaths[mountPath] || ownedDevices[getMountDevice(&mountInfo)] {
+			continue
+		}
+
+		if isSubDir(storagePath, mountPath) {
+			// lower mounts of overlayfs
+			volID := getVolumeIDFromDataPath(mountPath)
+			if ownedVolumeIDs[volID] {
+				continue
+			}
+		} else if !isKubeletCSIMountPath(mountPath) || !isDriverMount(&mountInfo, storagePath) {
+			continue
+		}
+
+		klog.V(3).Infof("Unmounting stale mount %q (%q)", mountPath, mountInfo.FsType)
+		err = driver.mounter.UnmountWithAbort(mountPath)
+		if err != nil {
+			klog.Errorf("Failed to unmount stale mount %q, %s", mountPath, err)
+			report.FailedPaths = append(report.FailedPaths, mountPath)
+			continue
+		}
+
+		report.UnmountedPaths = append(report.UnmountedPaths, mountPath)
+	}
+}
+
+func (driver *Driver) sweepLeftoverData(storagePath string, ownedVolumeIDs map[string]bool, syncingVolumeIDs map[string]bool, report *SweepReport) {
+	clientTypes := []client_common.ClientType{
+		client_common.IrodsFuseClientType,
+		client_common.WebdavClientType,
+		client_common.NfsClientType,
+	}
+
+	for _, clientType := range clientTypes {
+		clientDataPath := filepath.Join(storagePath, string(clientType))
+		entries, err := os.ReadDir(clientDataPath)
+		if err != nil {
+			if !os.IsNotExist(err) {
+				klog.Errorf("Failed to read %q for sweep, %s, skipping", clientDataPath, err)
+			}
+			continue
+		}
+
+		for _, entry := range entries {
+			if !entry.IsDir() {
+				continue
+			}
+
+			dataPath := filepath.Join(clientDataPath, entry.Name())
+			volID := getVolumeIDFromDataPath(dataPath)
+			if ownedVolumeIDs[volID] {
+				continue
+			}
+
+			// never delete mounted paths, it would delete data in iRODS
+			mountInfo, err := mounter.FindMountInfo(dataPath)
+			if err != nil || mountInfo != nil {
+				klog.Errorf("Leftover data path %q is still mounted, skipping", dataPath)
+				report.FailedPaths = append(report.FailedPaths, dataPath)
+				continue
+			}
+
+			if strings.HasSuffix(entry.Name(), overlayFSUpperSuffix) && syncingVolumeIDs[volID] {
+				// sync will be resumed
+				continue
+			}
+
+			if strings.HasSuffix(entry.Name(), overlayFSUpperSuffix) && !isEmptyDir(dataPath) {
+				report.UnsyncedUpperPaths = append(report.UnsyncedUpperPaths, dataPath)
+				continue
+			}
+
+			klog.V(3).Infof("Deleting leftover data %q", dataPath)
+			err = os.RemoveAll(dataPath)
+			if err != nil {
+				klog.Errorf("Failed to delete leftover data %q, %s", dataPath, err)
+				report.FailedPaths = append(report.FailedPaths, dataPath)
+				continue
+			}
+
+			report.DeletedPaths = append(report.DeletedPaths, dataPath)
+		}
+	}
+}
+
+// getVolumeIDFromDataPath returns volume id from data root or overlayfs paths under storage path
+func getVolumeIDFromDataPath(dataPath string) string {
+	name := filepath.Base(dataPath)
+	for _, suffix := range []string{overlayFSLowerSuffix, overlayFSUpperSuffix, overlayFSWorkDirSuffix} {
+		if strings.HasSuffix(name, suffix) {
+			return strings.TrimSuffix(name, suffix)
+		}
+	}
+	return name
+}
+
+func getMountDevice(mountInfo *mounter.MountInfo) string {
+	return fmt.Sprintf("%d:%d", mountInfo.Major, mountInfo.Minor)
+}
+
+func isKubeletCSIMountPath(mountPath string) bool {
+	for _, fragment := range kubeletCSIMountPathFragments {
+		if strings.Contains(mountPath, fragment) {
+			return true
+		}
+	}
+	return false
+}
+
+// isDriverMount checks if the mount is made by the driver, other CSI drivers may mount in kubelet directories
+// nfs mounts are not checked as they can't be distinguished from those of other drivers
+func isDriverMount(mountInfo *mounter.MountInfo, storagePath string) bool {
+	switch mountInfo.FsType {
+	case "irodsfs", "fuse.irodsfs", "fuse.fuse-overlayfs", "davfs", "fuse.davfs":
+		return true
+	case "overlay":
+		// kernel overlayfs is also used by container runtimes, check if lower is ours
+		for _, option := range mountInfo.SuperOptions {
+			if strings.HasPrefix(option, "lowerdir=") && isSubDir(storagePath, strings.TrimPrefix(option, "lowerdir=")) {
+				return true
+			}
+		}
+		return false
+	default:
+		return false
+	}
+}
+
+func isSubDir(parent string, sub string) bool {
+	rel, err := filepath.Rel(parent, sub)
+	if err != nil {
+		return false
+	}
+	return rel != "." && !strings.HasPrefix(rel, "..")
+}
+
+func isEmptyDir(dirPath string) bool {
+	entries, err := os.ReadDir(dirPath)
+	if err != nil {
+		// treat unreadable as non-empty to keep data
+		return false
+	}
+	return len(entries) == 0
+}
@@ -0,0 +1,67 @@
+package driver
+
+import (
+	"context"
+
+	"github.com/cyverse/irods-csi-driver/pkg/common"
+	"github.com/cyverse/irods-csi-driver/pkg/tokenexchange"
+	"k8s.io/klog"
+)
+
+// isTokenExchangeEnabled checks if service account tokens are exchanged for iRODS credentials
+func (driver *Driver) isTokenExchangeEnabled() bool {
+	return driver.tokenExchanger != nil
+}
+
+// applyTokenExchange exchanges the service account token in volume context for an iRODS credential and puts it in configs
+func (driver *Driver) applyTokenExchange(ctx context.Context, volID string, volContext map[string]string, configs map[string]string) error {
+	if !driver.isTokenExchangeEnabled() {
+		return nil
+	}
+
+	token, err := tokenexchange.GetServiceAccountToken(volContext[podInfoServiceAccountTokensKey], driver.tokenExchangeConfig.Audience)
+	if err != nil {
+		return err
+	}
+
+	request := &tokenexchange.ExchangeRequest{
+		Token:          token,
+		Audience:       driver.tokenExchangeConfig.Audience,
+		VolumeID:       volID,
+		PodNamespace:   volContext[podInfoNamespaceKey],
+		PodName:        volContext[podInfoNameKey],
+		ServiceAccount: volContext[podInfoServiceAccountKey],
+	}
+
+	credential, err := driver.tokenExchanger.Exchange(ctx, request)
+	if err != nil {
+		if ctxErr := common.GetContextStatusError(ctx, "Exchanging service account token was interrupted"); ctxErr != nil {
+			return ctxErr
+		}
+		return err
+	}
+
+	klog.V(5).Infof("Exchanged service account token for pod %s/%s", request.PodNamespace, request.PodName)
+
+	// remove all aliases so the volume attributes can't override the issued credential
+	if len(credential.Username) > 0 {
+		delete(configs, common.NormalizeConfigKey("irods_user_name"))
+		delete(configs, common.NormalizeConfigKey("user_name"))
+		configs[common.NormalizeConfigKey("user")] = credential.Username
+	}
+
+	delete(configs, common.NormalizeConfigKey("irods_user_password"))
+	delete(configs, common.NormalizeConfigKey("user_password"))
+	delete(configs, common.NormalizeConfigKey("password"))
+	if len(credential.Password) > 0 {
+		configs[common.NormalizeConfigKey("password")] = credential.Password
+	}
+
+	delete(configs, common.NormalizeConfigKey("irods_ticket"))
+	delete(configs, common.NormalizeConfigKey("ticket"))
+	if len(credential.Ticket) > 0 {
+		configs[common.NormalizeConfigKey("ticket")] = credential.Ticket
+	}
+
+	return nil
+}
@@ -0,0 +1,44 @@
+package driver
+
+import (
+	"sync"
+)
+
+const (
+	volumeOperationAlreadyExistsFmt string = "An operation with the given volume %q is already in progress"
+)
+
+// VolumeLocks tracks volumes that have operations in flight
+type VolumeLocks struct {
+	locks map[string]bool
+	mutex sync.Mutex
+}
+
+// NewVolumeLocks creates a new VolumeLocks
+func NewVolumeLocks() *VolumeLocks {
+	return &VolumeLocks{
+		locks: map[string]bool{},
+		mutex: sync.Mutex{},
+	}
+}
+
+// TryAcquire tries to acquire the lock for the given volume, returns false if an operation is already in flight
+func (locks *VolumeLocks) TryAcquire(volID string) bool {
+	locks.mutex.Lock()
+	defer locks.mutex.Unlock()
+
+	if _, ok := locks.locks[volID]; ok {
+		return false
+	}
+
+	locks.locks[volID] = true
+	return true
+}
+
+// Release releases the lock for the given volume
+func (locks *VolumeLocks) Release(volID string) {
+	locks.mutex.Lock()
+	defer locks.mutex.Unlock()
+
+	delete(locks.locks, volID)
+}
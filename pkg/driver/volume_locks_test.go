@@ -0,0 +1,145 @@
+package driver
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestVolumeLocksConcurrentAcquire(t *testing.T) {
+	locks := NewVolumeLocks()
+
+	var acquired atomic.Int32
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if locks.TryAcquire("vol1") {
+				acquired.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if count := acquired.Load(); count != 1 {
+		t.Errorf("expected one operation to acquire the lock, got %d", count)
+	}
+
+	// other volumes are not blocked
+	if !locks.TryAcquire("vol2") {
+		t.Errorf("expected the lock of another volume to be acquired")
+	}
+
+	locks.Release("vol1")
+	if !locks.TryAcquire("vol1") {
+		t.Errorf("expected the lock to be acquired after release")
+	}
+}
+
+func TestVolumeLocksAbortOverlappingOperations(t *testing.T) {
+	driver := &Driver{
+		volumeLocks: NewVolumeLocks(),
+	}
+
+	// an operation is in flight on the volume
+	if !driver.volumeLocks.TryAcquire("vol1") {
+		t.Fatalf("failed to acquire the lock")
+	}
+
+	testCases := []struct {
+		name      string
+		operation func() error
+	}{
+		{
+			name: "NodePublishVolume",
+			operation: func() error {
+				_, err := driver.NodePublishVolume(context.Background(), &csi.NodePublishVolumeRequest{VolumeId: "vol1", TargetPath: "/target"})
+				return err
+			},
+		},
+		{
+			name: "NodeUnpublishVolume",
+			operation: func() error {
+				_, err := driver.NodeUnpublishVolume(context.Background(), &csi.NodeUnpublishVolumeRequest{VolumeId: "vol1", TargetPath: "/target"})
+				return err
+			},
+		},
+		{
+			name: "NodeUnstageVolume",
+			operation: func() error {
+				_, err := driver.NodeUnstageVolume(context.Background(), &csi.NodeUnstageVolumeRequest{VolumeId: "vol1", StagingTargetPath: "/staging"})
+				return err
+			},
+		},
+		{
+			name: "DeleteVolume",
+			operation: func() error {
+				_, err := driver.DeleteVolume(context.Background(), &csi.DeleteVolumeRequest{VolumeId: "vol1"})
+				return err
+			},
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			err := testCase.operation()
+			if status.Code(err) != codes.Aborted {
+				t.Errorf("expected %s, got %v", codes.Aborted, err)
+			}
+		})
+	}
+
+	// aborted operations do not release the lock of the operation in flight
+	if driver.volumeLocks.TryAcquire("vol1") {
+		t.Errorf("expected the lock to be kept by the operation in flight")
+	}
+}
+
+func TestVolumeLocksReleasedOnError(t *testing.T) {
+	driver := &Driver{
+		volumeLocks: NewVolumeLocks(),
+	}
+
+	testCases := []struct {
+		name      string
+		volumeID  string
+		operation func() error
+	}{
+		{
+			name:     "NodePublishVolume without target path",
+			volumeID: "vol1",
+			operation: func() error {
+				_, err := driver.NodePublishVolume(context.Background(), &csi.NodePublishVolumeRequest{VolumeId: "vol1"})
+				return err
+			},
+		},
+		{
+			name:     "CreateVolume without capabilities",
+			volumeID: "pvc-1",
+			operation: func() error {
+				_, err := driver.CreateVolume(context.Background(), &csi.CreateVolumeRequest{Name: "pvc-1"})
+				return err
+			},
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			err := testCase.operation()
+			if status.Code(err) != codes.InvalidArgument {
+				t.Fatalf("expected %s, got %v", codes.InvalidArgument, err)
+			}
+
+			if !driver.volumeLocks.TryAcquire(testCase.volumeID) {
+				t.Errorf("expected the lock to be released after the error")
+			}
+			driver.volumeLocks.Release(testCase.volumeID)
+		})
+	}
+}
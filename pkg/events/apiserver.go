@@ -0,0 +1,117 @@
+package events
+
+import (
+	"context"
+	"time"
+
+	"golang.org/x/xerrors"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/klog"
+)
+
+const (
+	apiRequestTimeout time.Duration = 10 * time.Second
+)
+
+// APIRecorder records events via the Kubernetes API server using the service account of the pod
+// events are sent and aggregated in background by the client-go event broadcaster
+type APIRecorder struct {
+	client      kubernetes.Interface
+	broadcaster record.EventBroadcaster
+	recorder    record.EventRecorder
+}
+
+// NewAPIRecorder creates a new APIRecorder with in-cluster configuration
+func NewAPIRecorder(component string, host string) (*APIRecorder, error) {
+	config, err := rest.InClusterConfig()
+	if err != nil {
+		return nil, xerrors.Errorf("failed to get in-cluster configuration: %w", err)
+	}
+
+	config.Timeout = apiRequestTimeout
+
+	client, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, xerrors.Errorf("failed to create Kubernetes client: %w", err)
+	}
+
+	return newAPIRecorder(component, host, client), nil
+}
+
+// newAPIRecorder creates a new APIRecorder using the client
+func newAPIRecorder(component string, host string, client kubernetes.Interface) *APIRecorder {
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{
+		Interface: client.CoreV1().Events(""),
+	})
+
+	return &APIRecorder{
+		client:      client,
+		broadcaster: broadcaster,
+		recorder: broadcaster.NewRecorder(scheme.Scheme, corev1.EventSource{
+			Component: component,
+			Host:      host,
+		}),
+	}
+}
+
+// Event records the event asynchronously
+// events on cluster-scoped objects are recorded in the default namespace
+func (recorder *APIRecorder) Event(ref *ObjectReference, eventType string, reason string, message string) {
+	if ref == nil || len(ref.Name) == 0 {
+		return
+	}
+
+	refCopy := *ref
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), apiRequestTimeout)
+		defer cancel()
+
+		recorder.record(ctx, &refCopy, eventType, reason, truncateMessage(message))
+	}()
+}
+
+func (recorder *APIRecorder) record(ctx context.Context, ref *ObjectReference, eventType string, reason string, message string) {
+	// events without uid are not shown in "kubectl describe"
+	uid, err := recorder.getObjectUID(ctx, ref)
+	if err != nil {
+		klog.V(5).Infof("Failed to get uid of %s, recording event without uid - %v", ref, err)
+	}
+
+	recorder.recorder.Event(&corev1.ObjectReference{
+		APIVersion: "v1",
+		Kind:       string(ref.Kind),
+		Namespace:  ref.Namespace,
+		Name:       ref.Name,
+		UID:        uid,
+	}, eventType, reason, message)
+}
+
+func (recorder *APIRecorder) getObjectUID(ctx context.Context, ref *ObjectReference) (types.UID, error) {
+	var object metav1.Object
+	var err error
+
+	switch ref.Kind {
+	case PodKind:
+		object, err = recorder.client.CoreV1().Pods(ref.Namespace).Get(ctx, ref.Name, metav1.GetOptions{})
+	case PersistentVolumeClaimKind:
+		object, err = recorder.client.CoreV1().PersistentVolumeClaims(ref.Namespace).Get(ctx, ref.Name, metav1.GetOptions{})
+	case PersistentVolumeKind:
+		object, err = recorder.client.CoreV1().PersistentVolumes().Get(ctx, ref.Name, metav1.GetOptions{})
+	default:
+		return "", xerrors.Errorf("unknown object kind %q", ref.Kind)
+	}
+
+	if err != nil {
+		return "", xerrors.Errorf("failed to get %s: %w", ref, err)
+	}
+
+	return object.GetUID(), nil
+}
@@ -0,0 +1,141 @@
+package events
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func newTestAPIRecorder(t *testing.T, objects ...*corev1.Pod) (*APIRecorder, *fake.Clientset) {
+	client := fake.NewSimpleClientset()
+	for _, object := range objects {
+		_, err := client.CoreV1().Pods(object.Namespace).Create(context.Background(), object, metav1.CreateOptions{})
+		if err != nil {
+			t.Fatalf("failed to create pod: %v", err)
+		}
+	}
+
+	recorder := newAPIRecorder("test-component", "node1", client)
+	t.Cleanup(recorder.broadcaster.Shutdown)
+	return recorder, client
+}
+
+// waitForEvents waits until events in the namespace are sent by the broadcaster and satisfy the condition
+func waitForEvents(t *testing.T, client *fake.Clientset, namespace string, condition func(events []corev1.Event) bool) []corev1.Event {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		eventList, err := client.CoreV1().Events(namespace).List(context.Background(), metav1.ListOptions{})
+		if err != nil {
+			t.Fatalf("failed to list events: %v", err)
+		}
+
+		if condition(eventList.Items) {
+			return eventList.Items
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for events, got %+v", eventList.Items)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func hasEvents(count int) func(events []corev1.Event) bool {
+	return func(events []corev1.Event) bool {
+		return len(events) == count
+	}
+}
+
+func TestAPIRecorderCreateEvent(t *testing.T) {
+	recorder, client := newTestAPIRecorder(t, &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "pod1", UID: "pod-uid"},
+	})
+
+	ref := &ObjectReference{Kind: PodKind, Namespace: "ns", Name: "pod1"}
+	recorder.record(context.Background(), ref, EventTypeWarning, "MountFailed", "failed to mount")
+
+	event := waitForEvents(t, client, "ns", hasEvents(1))[0]
+	if !strings.HasPrefix(event.Name, "pod1.") {
+		t.Errorf("unexpected event name %q", event.Name)
+	}
+
+	expectedObject := corev1.ObjectReference{APIVersion: "v1", Kind: "Pod", Namespace: "ns", Name: "pod1", UID: "pod-uid"}
+	if event.InvolvedObject != expectedObject {
+		t.Errorf("unexpected involved object %+v", event.InvolvedObject)
+	}
+
+	if event.Type != EventTypeWarning || event.Reason != "MountFailed" || event.Message != "failed to mount" || event.Count != 1 {
+		t.Errorf("unexpected event %+v", event)
+	}
+
+	if event.Source.Component != "test-component" || event.Source.Host != "node1" {
+		t.Errorf("unexpected event source %+v", event.Source)
+	}
+}
+
+func TestAPIRecorderClusterScopedObject(t *testing.T) {
+	recorder, client := newTestAPIRecorder(t)
+	_, err := client.CoreV1().PersistentVolumes().Create(context.Background(), &corev1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{Name: "pv1", UID: "pv-uid"},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		t.Fatalf("failed to create pv: %v", err)
+	}
+
+	ref := &ObjectReference{Kind: PersistentVolumeKind, Name: "pv1"}
+	recorder.record(context.Background(), ref, EventTypeWarning, "VolumeDeletionFailed", "failed to delete")
+
+	// events on cluster-scoped objects are recorded in the default namespace
+	event := waitForEvents(t, client, metav1.NamespaceDefault, hasEvents(1))[0]
+	if event.InvolvedObject.Namespace != "" || event.InvolvedObject.UID != "pv-uid" {
+		t.Errorf("unexpected involved object %+v", event.InvolvedObject)
+	}
+}
+
+func TestAPIRecorderUnknownUID(t *testing.T) {
+	recorder, client := newTestAPIRecorder(t)
+
+	// the pod is not found, the event is recorded without uid
+	ref := &ObjectReference{Kind: PodKind, Namespace: "ns", Name: "pod1"}
+	recorder.record(context.Background(), ref, EventTypeWarning, "MountFailed", "failed to mount")
+
+	event := waitForEvents(t, client, "ns", hasEvents(1))[0]
+	if event.InvolvedObject.UID != "" {
+		t.Errorf("expected no uid, got %q", event.InvolvedObject.UID)
+	}
+}
+
+func TestAPIRecorderAggregateEvents(t *testing.T) {
+	recorder, client := newTestAPIRecorder(t)
+
+	ref := &ObjectReference{Kind: PodKind, Namespace: "ns", Name: "pod1"}
+	for i := 0; i < 3; i++ {
+		recorder.record(context.Background(), ref, EventTypeWarning, "MountFailed", "failed to mount")
+	}
+
+	waitForEvents(t, client, "ns", func(events []corev1.Event) bool {
+		return len(events) == 1 && events[0].Count == 3
+	})
+
+	// a different message is a different event
+	recorder.record(context.Background(), ref, EventTypeWarning, "MountFailed", "another failure")
+
+	waitForEvents(t, client, "ns", hasEvents(2))
+}
+
+func TestAPIRecorderEventTruncatesMessage(t *testing.T) {
+	recorder, client := newTestAPIRecorder(t)
+
+	ref := &ObjectReference{Kind: PodKind, Namespace: "ns", Name: "pod1"}
+	recorder.Event(ref, EventTypeWarning, "MountFailed", strings.Repeat("x", eventMessageLengthMax*2))
+
+	event := waitForEvents(t, client, "ns", hasEvents(1))[0]
+	if len(event.Message) != eventMessageLengthMax || !strings.HasSuffix(event.Message, "...") {
+		t.Errorf("expected message truncated to %d, got %d", eventMessageLengthMax, len(event.Message))
+	}
+}
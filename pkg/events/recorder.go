@@ -0,0 +1,122 @@
+package events
+
+import (
+	"fmt"
+	"sync"
+
+	"k8s.io/klog"
+)
+
+const (
+	// EventTypeNormal is for informational events
+	EventTypeNormal string = "Normal"
+	// EventTypeWarning is for failures users need to act on
+	EventTypeWarning string = "Warning"
+
+	// event message is truncated to this length
+	eventMessageLengthMax int = 1024
+)
+
+// ObjectKind is a kind of Kubernetes object that events are recorded on
+type ObjectKind string
+
+const (
+	// PodKind is for pods
+	PodKind ObjectKind = "Pod"
+	// PersistentVolumeKind is for persistent volumes, cluster-scoped
+	PersistentVolumeKind ObjectKind = "PersistentVolume"
+	// PersistentVolumeClaimKind is for persistent volume claims
+	PersistentVolumeClaimKind ObjectKind = "PersistentVolumeClaim"
+)
+
+// ObjectReference identifies a Kubernetes object
+type ObjectReference struct {
+	Kind      ObjectKind
+	Namespace string // empty for cluster-scoped objects
+	Name      string
+}
+
+// String returns a readable form of the reference
+func (ref *ObjectReference) String() string {
+	if len(ref.Namespace) > 0 {
+		return fmt.Sprintf("%s %s/%s", ref.Kind, ref.Namespace, ref.Name)
+	}
+	return fmt.Sprintf("%s %s", ref.Kind, ref.Name)
+}
+
+// Event is an event recorded on a Kubernetes object
+type Event struct {
+	Object  ObjectReference
+	Type    string
+	Reason  string
+	Message string
+}
+
+// Recorder records events on Kubernetes objects
+// recording must not block the caller and failures are only logged
+type Recorder interface {
+	Event(ref *ObjectReference, eventType string, reason string, message string)
+}
+
+// NoopRecorder discards events, used when the Kubernetes API is not available
+type NoopRecorder struct{}
+
+// Event discards the event
+func (recorder *NoopRecorder) Event(ref *ObjectReference, eventType string, reason string, message string) {
+	klog.V(5).Infof("Discarding event %s on %s - %s", reason, ref, message)
+}
+
+// FakeRecorder keeps events in memory, used in tests
+type FakeRecorder struct {
+	events []Event
+	mutex  sync.Mutex
+}
+
+// NewFakeRecorder creates a new FakeRecorder
+func NewFakeRecorder() *FakeRecorder {
+	return &FakeRecorder{
+		events: []Event{},
+	}
+}
+
+// Event keeps the event
+func (recorder *FakeRecorder) Event(ref *ObjectReference, eventType string, reason string, message string) {
+	recorder.mutex.Lock()
+	defer recorder.mutex.Unlock()
+
+	recorder.events = append(recorder.events, Event{
+		Object:  *ref,
+		Type:    eventType,
+		Reason:  reason,
+		Message: truncateMessage(message),
+	})
+}
+
+// GetEvents returns events recorded so far
+func (recorder *FakeRecorder) GetEvents() []Event {
+	recorder.mutex.Lock()
+	defer recorder.mutex.Unlock()
+
+	events := make([]Event, len(recorder.events))
+	copy(events, recorder.events)
+	return events
+}
+
+// NewRecorder creates a new Recorder for the component running on the host
+// returns NoopRecorder if not running in a Kubernetes cluster
+func NewRecorder(component string, host string) Recorder {
+	recorder, err := NewAPIRecorder(component, host)
+	if err != nil {
+		klog.Warningf("Kubernetes events are disabled, %v", err)
+		return &NoopRecorder{}
+	}
+
+	return recorder
+}
+
+func truncateMessage(message string) string {
+	if len(message) > eventMessageLengthMax {
+		return message[:eventMessageLengthMax-3] + "..."
+	}
+	return message
+}
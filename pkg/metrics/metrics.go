@@ -26,6 +26,31 @@ var (
 		Name: "irods_csi_driver_volume_unmount_failures_total",
 		Help: "The total number of volume unmount failures",
 	})
+	promGaugeForWaitingVolumeMount = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "irods_csi_driver_volume_mount_waiting",
+		Help: "The number of volume mounts waiting for concurrency limits",
+	})
+	promHistogramForVolumeMountWaitTime = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "irods_csi_driver_volume_mount_wait_seconds",
+		Help:    "The time volume mounts waited for concurrency limits",
+		Buckets: prometheus.ExponentialBuckets(0.01, 2, 16),
+	})
+	promCounterForOverlayFSSyncEntries = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "irods_csi_driver_overlayfs_sync_entries_total",
+		Help: "The total number of overlayfs upper entries processed by syncs, by outcome",
+	}, []string{"volume_id", "outcome"})
+	promCounterForOverlayFSSyncBytes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "irods_csi_driver_overlayfs_sync_bytes_total",
+		Help: "The total bytes of overlayfs upper files uploaded to iRODS",
+	}, []string{"volume_id"})
+	promCounterForOverlayFSSyncFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "irods_csi_driver_overlayfs_sync_failures_total",
+		Help: "The total number of overlayfs upper syncs failed",
+	}, []string{"volume_id"})
+	promGaugeForPendingOverlayFSSync = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "irods_csi_driver_overlayfs_sync_pending",
+		Help: "1 if the overlayfs upper of the volume is unmounted but not synced to iRODS yet, syncing or failed",
+	}, []string{"volume_id"})
 )
 
 // IncreaseCounterForVolumeMount increases the counter for volume mount
@@ -57,3 +82,44 @@ func IncreaseCounterForVolumeMountFailures() {
 func IncreaseCounterForVolumeUnmountFailures() {
 	promCounterForVolumeUnmountFailures.Inc()
 }
+
+// IncreaseCounterForWaitingVolumeMount increases the counter for volume mounts waiting for concurrency limits
+func IncreaseCounterForWaitingVolumeMount() {
+	promGaugeForWaitingVolumeMount.Inc()
+}
+
+// DecreaseCounterForWaitingVolumeMount decreases the counter for volume mounts waiting for concurrency limits
+func DecreaseCounterForWaitingVolumeMount() {
+	promGaugeForWaitingVolumeMount.Dec()
+}
+
+// ObserveVolumeMountWaitTime records the time a volume mount waited for concurrency limits
+func ObserveVolumeMountWaitTime(seconds float64) {
+	promHistogramForVolumeMountWaitTime.Observe(seconds)
+}
+
+// IncreaseCounterForOverlayFSSyncEntries increases the counter for overlayfs upper entries synced with the outcome
+func IncreaseCounterForOverlayFSSyncEntries(volID string, outcome string) {
+	promCounterForOverlayFSSyncEntries.WithLabelValues(volID, outcome).Inc()
+}
+
+// AddCounterForOverlayFSSyncBytes adds bytes uploaded by overlayfs upper syncs
+func AddCounterForOverlayFSSyncBytes(volID string, bytes int64) {
+	promCounterForOverlayFSSyncBytes.WithLabelValues(volID).Add(float64(bytes))
+}
+
+// IncreaseCounterForOverlayFSSyncFailures increases the counter for overlayfs upper sync failures
+func IncreaseCounterForOverlayFSSyncFailures(volID string) {
+	promCounterForOverlayFSSyncFailures.WithLabelValues(volID).Inc()
+}
+
+// SetGaugeForPendingOverlayFSSync sets if the overlayfs upper of the volume is pending to sync
+// the series of the volume is deleted when not pending, not to keep series of all volumes
+func SetGaugeForPendingOverlayFSSync(volID string, pending bool) {
+	if pending {
+		promGaugeForPendingOverlayFSSync.WithLabelValues(volID).Set(1)
+		return
+	}
+
+	promGaugeForPendingOverlayFSSync.DeleteLabelValues(volID)
+}
@@ -1,12 +1,15 @@
 package mounter
 
 import (
+	"context"
+
+	"github.com/cyverse/irods-csi-driver/pkg/common"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 	"k8s.io/klog/v2"
 )
 
-func MountBind(mounter Mounter, sourcePath string, mntOptions []string, targetPath string) error {
+func MountBind(ctx context.Context, mounter Mounter, sourcePath string, mntOptions []string, targetPath string) error {
 	fsType := ""
 	mountOptions := []string{}
 	mountSensitiveOptions := []string{}
@@ -16,7 +19,14 @@ func MountBind(mounter Mounter, sourcePath string, mntOptions []string, targetPa
 	mountOptions = append(mountOptions, "bind")
 
 	klog.V(5).Infof("Mounting %q at %q with options %v", sourcePath, targetPath, mountOptions)
-	if err := mounter.MountSensitive2(sourcePath, sourcePath, targetPath, fsType, mountOptions, mountSensitiveOptions, stdinArgs); err != nil {
+	if err := mounter.MountSensitive2(ctx, sourcePath, sourcePath, targetPath, fsType, mountOptions, mountSensitiveOptions, stdinArgs); err != nil {
+		// umount the volume to ensure no leftovers
+		mounter.Unmount(targetPath)
+
+		if ctxErr := common.GetContextStatusError(ctx, "Bind mounting %q at %q was interrupted", sourcePath, targetPath); ctxErr != nil {
+			return ctxErr
+		}
+
 		return status.Errorf(codes.Internal, "Could not mount %q (%q) at %q: %v", sourcePath, fsType, targetPath, err)
 	}
 
@@ -0,0 +1,244 @@
+package mounter
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/xerrors"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// Location of FUSE connections in fusectl filesystem
+	fuseConnectionsPath = "/sys/fs/fuse/connections"
+	// Time to wait for stat on a mount point before giving up checking it
+	mountPointCheckTimeout = 10 * time.Second
+	// Time to wait for unmount of a mount point before unmounting it lazily
+	unmountTimeout = 30 * time.Second
+)
+
+// ListMountInfo returns mount info of all mounts in the mount namespace of the driver
+func ListMountInfo() ([]MountInfo, error) {
+	infos, err := ParseMountInfo(procMountInfoPath)
+	if err != nil {
+		return nil, xerrors.Errorf("failed to parse mount info: %w", err)
+	}
+	return infos, nil
+}
+
+// FindMountInfo returns mount info of the mount point, returns nil if the path is not a mount point
+// this does not access the path, so it is safe to call on corrupted or hung mounts
+func FindMountInfo(mountPath string) (*MountInfo, error) {
+	infos, err := ListMountInfo()
+	if err != nil {
+		return nil, err
+	}
+
+	cleanPath := filepath.Clean(mountPath)
+
+	// the last one is the top-most mount if multiple mounts are stacked on the path
+	var found *MountInfo
+	for idx := range infos {
+		if infos[idx].MountPoint == cleanPath {
+			found = &infos[idx]
+		}
+	}
+	return found, nil
+}
+
+// mountPointStat is a stat on a mount point, it may never return if the mount is hung
+type mountPointStat struct {
+	done chan struct{}
+	err  error
+}
+
+var (
+	// stats in progress by mount point, at most one per mount point runs, so hung mounts do not pile up goroutines
+	mountPointStats      = map[string]*mountPointStat{}
+	mountPointStatsMutex sync.Mutex
+)
+
+// statMountPoint starts a stat on the mount point, or returns the one in progress
+func statMountPoint(mountPath string) *mountPointStat {
+	mountPointStatsMutex.Lock()
+	defer mountPointStatsMutex.Unlock()
+
+	if stat, ok := mountPointStats[mountPath]; ok {
+		return stat
+	}
+
+	stat := &mountPointStat{
+		done: make(chan struct{}),
+	}
+	mountPointStats[mountPath] = stat
+
+	go func() {
+		_, stat.err = os.Stat(mountPath)
+
+		mountPointStatsMutex.Lock()
+		delete(mountPointStats, mountPath)
+		mountPointStatsMutex.Unlock()
+
+		close(stat.done)
+	}()
+
+	return stat
+}
+
+// IsCorruptedMountPoint checks if the mount point is corrupted, e.g., FUSE daemon is dead
+// it returns an error if stat fails otherwise or does not return in time, e.g., the mount is hung
+func IsCorruptedMountPoint(mountPath string) (bool, error) {
+	stat := statMountPoint(mountPath)
+
+	select {
+	case <-stat.done:
+		if stat.err == nil || os.IsNotExist(stat.err) {
+			return false, nil
+		}
+
+		if IsCorruptedMount(stat.err) {
+			return true, nil
+		}
+		return false, xerrors.Errorf("failed to stat %q: %w", mountPath, stat.err)
+	case <-time.After(mountPointCheckTimeout):
+		return false, xerrors.Errorf("stat on %q did not return in %v", mountPath, mountPointCheckTimeout)
+	}
+}
+
+// isFuseConnectionShared checks if other mounts, e.g., bind mounts, use the FUSE connection of the mount
+func isFuseConnectionShared(info *MountInfo) (bool, error) {
+	infos, err := ListMountInfo()
+	if err != nil {
+		return false, err
+	}
+
+	for _, other := range infos {
+		if other.Major == info.Major && other.Minor == info.Minor && other.MountPoint != info.MountPoint {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// AbortFuseConnection aborts FUSE connection of the mount point, so pending and future requests fail immediately
+// it does nothing if the path is not a FUSE mount
+func AbortFuseConnection(mountPath string) error {
+	info, err := FindMountInfo(mountPath)
+	if err != nil {
+		return err
+	}
+
+	if info == nil || !isFuseFsType(info.FsType) {
+		return nil
+	}
+
+	// FUSE connection id is the minor device number of the mount
+	abortPath := filepath.Join(fuseConnectionsPath, strconv.Itoa(info.Minor), "abort")
+
+	klog.V(5).Infof("Aborting FUSE connection of %q via %q", mountPath, abortPath)
+	err = os.WriteFile(abortPath, []byte("1"), 0200)
+	if err != nil {
+		return xerrors.Errorf("failed to abort FUSE connection %q: %w", abortPath, err)
+	}
+	return nil
+}
+
+// ForceUnmount aborts FUSE connection of the target and unmounts it lazily
+func (mounter *NodeMounter) ForceUnmount(target string) error {
+	err := AbortFuseConnection(target)
+	if err != nil {
+		klog.Errorf("Error aborting FUSE connection of %q, %s, ignoring", target, err)
+	}
+
+	return mounter.UnmountLazy(target, true)
+}
+
+// UnmountWithAbort unmounts the target, it does nothing if the target is not a mount point
+// healthy mounts are unmounted normally, so busy mounts fail to unmount
+// corrupted or hung mounts, and mounts failed to unmount in time, are unmounted lazily after aborting their FUSE connections
+// the connection is not aborted if other mounts share it, e.g., bind mounts of a staging mount used by other pods
+func (mounter *NodeMounter) UnmountWithAbort(target string) error {
+	info, err := FindMountInfo(target)
+	if err != nil {
+		return err
+	}
+
+	if info == nil {
+		klog.V(5).Infof("%q is not mounted, skip unmounting", target)
+		return nil
+	}
+
+	corrupted, err := IsCorruptedMountPoint(target)
+	if err != nil {
+		klog.Warningf("Mount %q is not responding, %v", target, err)
+		return mounter.abortAndUnmountLazy(target, info)
+	}
+
+	if corrupted {
+		klog.Warningf("Mount %q is corrupted", target)
+		return mounter.abortAndUnmountLazy(target, info)
+	}
+
+	err = unmountWithTimeout(target, unmountTimeout)
+	if err != nil {
+		if errors.Is(err, context.DeadlineExceeded) {
+			klog.Warningf("Unmounting %q did not finish in %v", target, unmountTimeout)
+			return mounter.abortAndUnmountLazy(target, info)
+		}
+		return err
+	}
+	return nil
+}
+
+// abortAndUnmountLazy aborts FUSE connection of the mount if not shared, and unmounts it lazily
+// lazy unmount does not access the mount, so hung mounts are detached too
+func (mounter *NodeMounter) abortAndUnmountLazy(target string, info *MountInfo) error {
+	if isFuseFsType(info.FsType) {
+		shared, err := isFuseConnectionShared(info)
+		if err != nil {
+			return err
+		}
+
+		if shared {
+			klog.Warningf("FUSE connection of %q is used by other mounts, unmounting without abort", target)
+		} else {
+			klog.Warningf("Aborting FUSE connection of %q", target)
+			err = AbortFuseConnection(target)
+			if err != nil {
+				klog.Errorf("Error aborting FUSE connection of %q, %s, ignoring", target, err)
+			}
+		}
+	}
+
+	return mounter.UnmountLazy(target, true)
+}
+
+// unmountWithTimeout unmounts the target normally, returns context.DeadlineExceeded if it does not finish in time
+func unmountWithTimeout(target string, timeout time.Duration) error {
+	klog.V(5).Infof("Unmounting %q", target)
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	command := exec.CommandContext(ctx, "umount", target)
+	command.WaitDelay = mountCommandWaitDelay
+	output, err := command.CombinedOutput()
+	if err != nil {
+		if ctx.Err() != nil {
+			return xerrors.Errorf("umount failed, Unmounting arguments %q: %w", target, ctx.Err())
+		}
+		return xerrors.Errorf("umount failed, Unmounting arguments %q, Output %q: %w", target, string(output), err)
+	}
+	return nil
+}
+
+func isFuseFsType(fsType string) bool {
+	return fsType == "fuse" || fsType == "fuseblk" || strings.HasPrefix(fsType, "fuse.")
+}
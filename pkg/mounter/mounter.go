@@ -38,6 +38,8 @@ limitations under the License.
 package mounter
 
 import (
+	"context"
+	"errors"
 	"io"
 	"os"
 	"os/exec"
@@ -45,6 +47,7 @@ import (
 	"strconv"
 	"strings"
 	"syscall"
+	"time"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
 	"golang.org/x/xerrors"
@@ -64,14 +67,18 @@ const (
 	procMountsPath = "/proc/mounts"
 	// Location of the mountinfo file
 	procMountInfoPath = "/proc/self/mountinfo"
+	// Time to wait for I/O of the mount command after it is killed by context cancellation
+	mountCommandWaitDelay = 5 * time.Second
 )
 
 type Mounter interface {
 	mount.Interface
 	GetDeviceName(mountPath string) (string, int, error)
-	MountSensitive2(source string, sourceMasked string, target string, fstype string, options []string, sensitiveOptions []string, stdinValues []string) error
+	MountSensitive2(ctx context.Context, source string, sourceMasked string, target string, fstype string, options []string, sensitiveOptions []string, stdinValues []string) error
 	UnmountLazy(target string, lazy bool) error
 	FuseUnmount(target string, lazy bool) error
+	ForceUnmount(target string) error
+	UnmountWithAbort(target string) error
 }
 
 type NodeMounter struct {
@@ -107,29 +114,32 @@ func (mounter *NodeMounter) MountSensitive(source string, target string, fstype
 	// All Linux distros are expected to be shipped with a mount utility that a support bind mounts.
 	bind, bindOpts, bindRemountOpts, bindRemountOptsSensitive := mount.MakeBindOptsSensitive(options, sensitiveOptions)
 	if bind {
-		err := mounter.doMount(defaultMountCommand, source, source, target, fstype, bindOpts, bindRemountOptsSensitive, nil)
+		err := mounter.doMount(context.Background(), defaultMountCommand, source, source, target, fstype, bindOpts, bindRemountOptsSensitive, nil)
 		if err != nil {
 			return err
 		}
-		return mounter.doMount(defaultMountCommand, source, source, target, fstype, bindRemountOpts, bindRemountOptsSensitive, nil)
+		return mounter.doMount(context.Background(), defaultMountCommand, source, source, target, fstype, bindRemountOpts, bindRemountOptsSensitive, nil)
 	}
 
-	return mounter.doMount(defaultMountCommand, source, source, target, fstype, options, sensitiveOptions, nil)
+	return mounter.doMount(context.Background(), defaultMountCommand, source, source, target, fstype, options, sensitiveOptions, nil)
 }
 
-func (mounter *NodeMounter) MountSensitive2(source string, sourceMasked string, target string, fstype string, options []string, sensitiveOptions []string, stdinValues []string) error {
+// MountSensitive2 is the same as MountSensitive() but this method allows
+// masked source and stdin values to be passed. The mount command is killed
+// when the given context is canceled or expires.
+func (mounter *NodeMounter) MountSensitive2(ctx context.Context, source string, sourceMasked string, target string, fstype string, options []string, sensitiveOptions []string, stdinValues []string) error {
 	// Path to mounter binary if containerized mounter is needed. Otherwise, it is set to empty.
 	// All Linux distros are expected to be shipped with a mount utility that a support bind mounts.
 	bind, bindOpts, bindRemountOpts, bindRemountOptsSensitive := mount.MakeBindOptsSensitive(options, sensitiveOptions)
 	if bind {
-		err := mounter.doMount(defaultMountCommand, source, sourceMasked, target, fstype, bindOpts, bindRemountOptsSensitive, stdinValues)
+		err := mounter.doMount(ctx, defaultMountCommand, source, sourceMasked, target, fstype, bindOpts, bindRemountOptsSensitive, stdinValues)
 		if err != nil {
 			return err
 		}
-		return mounter.doMount(defaultMountCommand, source, sourceMasked, target, fstype, bindRemountOpts, bindRemountOptsSensitive, stdinValues)
+		return mounter.doMount(ctx, defaultMountCommand, source, sourceMasked, target, fstype, bindRemountOpts, bindRemountOptsSensitive, stdinValues)
 	}
 
-	return mounter.doMount(defaultMountCommand, source, sourceMasked, target, fstype, options, sensitiveOptions, stdinValues)
+	return mounter.doMount(ctx, defaultMountCommand, source, sourceMasked, target, fstype, options, sensitiveOptions, stdinValues)
 }
 
 func (mounter *NodeMounter) ensureMtab() error {
@@ -153,7 +163,8 @@ func (mounter *NodeMounter) ensureMtab() error {
 
 // doMount runs the mount command. mounterPath is the path to mounter binary if containerized mounter is used.
 // sensitiveOptions is an extension of options except they will not be logged (because they may contain sensitive material)
-func (mounter *NodeMounter) doMount(mountCmd string, source string, sourceMasked string, target string, fstype string, options []string, sensitiveOptions []string, stdinValues []string) error {
+// the mount command is killed when ctx is canceled or expires
+func (mounter *NodeMounter) doMount(ctx context.Context, mountCmd string, source string, sourceMasked string, target string, fstype string, options []string, sensitiveOptions []string, stdinValues []string) error {
 	mountArgs, mountArgsLogStr := MakeMountArgsSensitive(source, sourceMasked, target, fstype, options, sensitiveOptions)
 
 	// Ensure /etc/mtab, this is requred by file system client
@@ -165,7 +176,9 @@ func (mounter *NodeMounter) doMount(mountCmd string, source string, sourceMasked
 
 	// Logging with sensitive mount options removed.
 	klog.V(4).Infof("Mounting cmd (%q) with arguments (%q)", mountCmd, mountArgsLogStr)
-	command := exec.Command(mountCmd, mountArgs...)
+	command := exec.CommandContext(ctx, mountCmd, mountArgs...)
+	// mount helpers may leave daemons holding the output pipe, do not wait for them forever
+	command.WaitDelay = mountCommandWaitDelay
 
 	if stdinValues != nil {
 		stdin, err := command.StdinPipe()
@@ -182,6 +195,17 @@ func (mounter *NodeMounter) doMount(mountCmd string, source string, sourceMasked
 	}
 
 	output, err := command.CombinedOutput()
+	if errors.Is(err, exec.ErrWaitDelay) && command.ProcessState != nil && command.ProcessState.Success() {
+		// the mount command succeeded, but a daemon it started still holds the output pipe
+		klog.V(4).Infof("Mount command (%q) succeeded, output is still held by its children, ignoring", mountCmd)
+		err = nil
+	}
+
+	if err != nil && ctx.Err() != nil {
+		klog.Errorf("Mount interrupted: %v\nMounting command: %q\nMounting arguments: %q", ctx.Err(), mountCmd, mountArgsLogStr)
+		return xerrors.Errorf("mount interrupted, Mounting command %q, Mounting arguments %q: %w", mountCmd, mountArgsLogStr, ctx.Err())
+	}
+
 	if err != nil {
 		klog.Errorf("Mount failed: %v\nMounting command: %q\nMounting arguments: %q\nOutput: %q", err, mountCmd, mountArgsLogStr, string(output))
 		return xerrors.Errorf("mount failed, Mounting command %q, Mounting arguments %q, Output %q: %w", mountCmd, mountArgsLogStr, string(output), err)
@@ -0,0 +1,108 @@
+package tokenexchange
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+
+	"golang.org/x/xerrors"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/klog"
+)
+
+const (
+	brokerResponseSizeMax int64 = 64 * 1024
+)
+
+// BrokerExchanger exchanges tokens via a credential broker
+// the broker accepts a POST of ExchangeRequest json and returns Credential json
+type BrokerExchanger struct {
+	endpoint   string
+	httpClient *http.Client
+}
+
+// NewBrokerExchanger creates a new BrokerExchanger
+func NewBrokerExchanger(config *Config) (*BrokerExchanger, error) {
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: config.InsecureSkipVerifyTLS,
+	}
+
+	if len(config.CACertificateFile) > 0 {
+		caCert, err := os.ReadFile(config.CACertificateFile)
+		if err != nil {
+			return nil, xerrors.Errorf("failed to read CA certificate file %q: %w", config.CACertificateFile, err)
+		}
+
+		caCertPool := x509.NewCertPool()
+		if !caCertPool.AppendCertsFromPEM(caCert) {
+			return nil, xerrors.Errorf("failed to parse CA certificate file %q", config.CACertificateFile)
+		}
+		tlsConfig.RootCAs = caCertPool
+	}
+
+	return &BrokerExchanger{
+		endpoint: config.Endpoint,
+		httpClient: &http.Client{
+			Timeout: config.Timeout,
+			Transport: &http.Transport{
+				Proxy:           http.ProxyFromEnvironment,
+				TLSClientConfig: tlsConfig,
+			},
+		},
+	}, nil
+}
+
+// Exchange exchanges a service account token for an iRODS credential
+func (exchanger *BrokerExchanger) Exchange(ctx context.Context, request *ExchangeRequest) (*Credential, error) {
+	requestBytes, err := json.Marshal(request)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "Could not serialize token exchange request: %v", err)
+	}
+
+	httpRequest, err := http.NewRequestWithContext(ctx, http.MethodPost, exchanger.endpoint, bytes.NewReader(requestBytes))
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "Could not create token exchange request: %v", err)
+	}
+
+	httpRequest.Header.Set("Content-Type", "application/json")
+	httpRequest.Header.Set("Authorization", fmt.Sprintf("Bearer %s", request.Token))
+
+	klog.V(5).Infof("Exchanging service account token for pod %s/%s at %q", request.PodNamespace, request.PodName, exchanger.endpoint)
+
+	httpResponse, err := exchanger.httpClient.Do(httpRequest)
+	if err != nil {
+		return nil, status.Errorf(codes.Unavailable, "Could not reach token exchange broker %q: %v", exchanger.endpoint, err)
+	}
+	defer httpResponse.Body.Close()
+
+	responseBytes, err := io.ReadAll(io.LimitReader(httpResponse.Body, brokerResponseSizeMax))
+	if err != nil {
+		return nil, status.Errorf(codes.Unavailable, "Could not read token exchange response: %v", err)
+	}
+
+	switch {
+	case httpResponse.StatusCode == http.StatusUnauthorized || httpResponse.StatusCode == http.StatusForbidden:
+		return nil, status.Errorf(codes.PermissionDenied, "Token exchange broker denied the service account token - %s", httpResponse.Status)
+	case httpResponse.StatusCode != http.StatusOK:
+		return nil, status.Errorf(codes.Unavailable, "Token exchange broker returned an error - %s", httpResponse.Status)
+	}
+
+	credential := Credential{}
+	err = json.Unmarshal(responseBytes, &credential)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "Token exchange response must be a valid json string - %v", err)
+	}
+
+	if credential.IsEmpty() {
+		return nil, status.Error(codes.PermissionDenied, "Token exchange broker returned an empty credential")
+	}
+
+	return &credential, nil
+}
@@ -0,0 +1,121 @@
+package tokenexchange
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func newTestBrokerExchanger(t *testing.T, handler http.HandlerFunc) *BrokerExchanger {
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	exchanger, err := NewBrokerExchanger(&Config{
+		Type:     BrokerExchangerType,
+		Endpoint: server.URL,
+		Timeout:  defaultExchangeTimeout,
+	})
+	if err != nil {
+		t.Fatalf("failed to create broker exchanger: %v", err)
+	}
+	return exchanger
+}
+
+func TestBrokerExchangerSuccess(t *testing.T) {
+	request := &ExchangeRequest{
+		Token:        "sa-token",
+		Audience:     "irods",
+		VolumeID:     "vol1",
+		PodNamespace: "ns",
+		PodName:      "pod",
+	}
+
+	exchanger := newTestBrokerExchanger(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			t.Errorf("unexpected method %q", r.Method)
+		}
+
+		if auth := r.Header.Get("Authorization"); auth != "Bearer sa-token" {
+			t.Errorf("unexpected authorization header %q", auth)
+		}
+
+		received := ExchangeRequest{}
+		err := json.NewDecoder(r.Body).Decode(&received)
+		if err != nil {
+			t.Errorf("failed to decode request: %v", err)
+		}
+
+		if received != *request {
+			t.Errorf("unexpected request %+v", received)
+		}
+
+		json.NewEncoder(w).Encode(&Credential{
+			Username: "team_a_user",
+			Password: "secret",
+		})
+	})
+
+	credential, err := exchanger.Exchange(context.Background(), request)
+	if err != nil {
+		t.Fatalf("failed to exchange token: %v", err)
+	}
+
+	if credential.Username != "team_a_user" || credential.Password != "secret" {
+		t.Errorf("unexpected credential %+v", credential)
+	}
+}
+
+func TestBrokerExchangerErrors(t *testing.T) {
+	testCases := []struct {
+		name       string
+		statusCode int
+		body       string
+		code       codes.Code
+	}{
+		{name: "unauthorized", statusCode: http.StatusUnauthorized, code: codes.PermissionDenied},
+		{name: "forbidden", statusCode: http.StatusForbidden, code: codes.PermissionDenied},
+		{name: "internal server error", statusCode: http.StatusInternalServerError, code: codes.Unavailable},
+		{name: "service unavailable", statusCode: http.StatusServiceUnavailable, code: codes.Unavailable},
+		{name: "empty credential", statusCode: http.StatusOK, body: "{}", code: codes.PermissionDenied},
+		{name: "invalid json", statusCode: http.StatusOK, body: "not json", code: codes.Internal},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			exchanger := newTestBrokerExchanger(t, func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(testCase.statusCode)
+				w.Write([]byte(testCase.body))
+			})
+
+			_, err := exchanger.Exchange(context.Background(), &ExchangeRequest{Token: "sa-token"})
+			if status.Code(err) != testCase.code {
+				t.Errorf("expected %s, got %v", testCase.code, err)
+			}
+		})
+	}
+}
+
+func TestBrokerExchangerUnreachable(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	endpoint := server.URL
+	server.Close()
+
+	exchanger, err := NewBrokerExchanger(&Config{
+		Type:     BrokerExchangerType,
+		Endpoint: endpoint,
+		Timeout:  defaultExchangeTimeout,
+	})
+	if err != nil {
+		t.Fatalf("failed to create broker exchanger: %v", err)
+	}
+
+	_, err = exchanger.Exchange(context.Background(), &ExchangeRequest{Token: "sa-token"})
+	if status.Code(err) != codes.Unavailable {
+		t.Errorf("expected %s, got %v", codes.Unavailable, err)
+	}
+}
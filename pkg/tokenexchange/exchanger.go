@@ -0,0 +1,158 @@
+package tokenexchange
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cyverse/irods-csi-driver/pkg/common"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ExchangerType is a token exchanger type
+type ExchangerType string
+
+// token exchanger types
+const (
+	// BrokerExchangerType is for a credential broker accessed via HTTP
+	BrokerExchangerType ExchangerType = "broker"
+
+	defaultExchangeTimeout time.Duration = 30 * time.Second
+)
+
+// Credential is an iRODS credential issued for a service account token
+// either a ticket or a username and password pair is given
+type Credential struct {
+	Username string `json:"user,omitempty"`
+	Password string `json:"password,omitempty"`
+	Ticket   string `json:"ticket,omitempty"`
+}
+
+// IsEmpty checks if the credential has nothing to authenticate with
+func (cred *Credential) IsEmpty() bool {
+	return len(cred.Password) == 0 && len(cred.Ticket) == 0
+}
+
+// ExchangeRequest is a request to exchange a service account token for an iRODS credential
+type ExchangeRequest struct {
+	Token          string `json:"token"`
+	Audience       string `json:"audience,omitempty"`
+	VolumeID       string `json:"volume_id,omitempty"`
+	PodNamespace   string `json:"pod_namespace,omitempty"`
+	PodName        string `json:"pod_name,omitempty"`
+	ServiceAccount string `json:"service_account,omitempty"`
+}
+
+// Exchanger exchanges a service account token for an iRODS credential
+type Exchanger interface {
+	Exchange(ctx context.Context, request *ExchangeRequest) (*Credential, error)
+}
+
+// Config is a token exchange config
+type Config struct {
+	Type                  ExchangerType
+	Endpoint              string
+	Audience              string
+	Timeout               time.Duration
+	CACertificateFile     string
+	InsecureSkipVerifyTLS bool
+}
+
+// GetConfig extracts token exchange config from param map, returns nil if token exchange is not configured
+func GetConfig(params map[string]string) (*Config, error) {
+	config := Config{
+		Type:    BrokerExchangerType,
+		Timeout: defaultExchangeTimeout,
+	}
+
+	for k, v := range params {
+		switch common.NormalizeConfigKey(k) {
+		case common.NormalizeConfigKey("token_exchange_type"):
+			config.Type = ExchangerType(strings.ToLower(v))
+		case common.NormalizeConfigKey("token_exchange_endpoint"):
+			config.Endpoint = v
+		case common.NormalizeConfigKey("token_exchange_audience"):
+			config.Audience = v
+		case common.NormalizeConfigKey("token_exchange_timeout"):
+			t, err := strconv.Atoi(v)
+			if err != nil {
+				return nil, status.Errorf(codes.InvalidArgument, "Argument %q must be a valid number - %v", k, err)
+			}
+			config.Timeout = time.Duration(t) * time.Second
+		case common.NormalizeConfigKey("token_exchange_ca_certificate_file"):
+			config.CACertificateFile = v
+		case common.NormalizeConfigKey("token_exchange_insecure_skip_verify"):
+			skip, err := strconv.ParseBool(v)
+			if err != nil {
+				return nil, status.Errorf(codes.InvalidArgument, "Argument %q must be a valid boolean string - %v", k, err)
+			}
+			config.InsecureSkipVerifyTLS = skip
+		default:
+			// ignore
+		}
+	}
+
+	if len(config.Endpoint) == 0 {
+		// not configured
+		return nil, nil
+	}
+
+	if config.Timeout <= 0 {
+		config.Timeout = defaultExchangeTimeout
+	}
+
+	return &config, nil
+}
+
+// NewExchanger creates a new Exchanger for the config type
+func NewExchanger(config *Config) (Exchanger, error) {
+	switch config.Type {
+	case BrokerExchangerType:
+		return NewBrokerExchanger(config)
+	default:
+		return nil, status.Errorf(codes.InvalidArgument, "unknown token exchanger type %q", config.Type)
+	}
+}
+
+// serviceAccountToken is a token passed via "csi.storage.k8s.io/serviceAccount.tokens"
+type serviceAccountToken struct {
+	Token               string `json:"token"`
+	ExpirationTimestamp string `json:"expirationTimestamp"`
+}
+
+// GetServiceAccountToken returns the service account token for the audience from the tokens json given by kubelet
+// if audience is empty, the only token given is returned
+func GetServiceAccountToken(tokensJSON string, audience string) (string, error) {
+	if len(tokensJSON) == 0 {
+		return "", status.Error(codes.InvalidArgument, "Service account token not provided, tokenRequests must be configured in CSIDriver")
+	}
+
+	tokens := map[string]serviceAccountToken{}
+	err := json.Unmarshal([]byte(tokensJSON), &tokens)
+	if err != nil {
+		return "", status.Errorf(codes.InvalidArgument, "Service account tokens must be a valid json string - %v", err)
+	}
+
+	if len(audience) > 0 {
+		token, ok := tokens[audience]
+		if !ok || len(token.Token) == 0 {
+			return "", status.Errorf(codes.InvalidArgument, "Service account token for audience %q not provided", audience)
+		}
+		return token.Token, nil
+	}
+
+	if len(tokens) != 1 {
+		return "", status.Errorf(codes.InvalidArgument, "Expected a single service account token but %d tokens are given, audience must be configured", len(tokens))
+	}
+
+	for _, token := range tokens {
+		if len(token.Token) > 0 {
+			return token.Token, nil
+		}
+	}
+
+	return "", status.Error(codes.InvalidArgument, "Service account token is empty")
+}
@@ -24,12 +24,63 @@ type NodeVolume struct {
 	ID                        string            `yaml:"id" json:"id"`
 	StagingMountPath          string            `yaml:"staging_mount_path" json:"staging_mount_path"`
 	MountPath                 string            `yaml:"mount_path" json:"mount_path"`
+	PublishPaths              []string          `yaml:"publish_paths" json:"publish_paths"`
 	StagingMountOptions       []string          `yaml:"staging_mount_options" json:"staging_mount_options"`
 	MountOptions              []string          `yaml:"mount_options" json:"mount_options"`
+	VolumeMountGroup          string            `yaml:"volume_mount_group,omitempty" json:"volume_mount_group,omitempty"`
 	ClientType                string            `yaml:"client_type" json:"client_type"`
 	ClientConfig              map[string]string `yaml:"client_config" json:"client_config"`
 	DynamicVolumeProvisioning bool              `yaml:"dynamic_volume_provisioning" json:"dynamic_volume_provisioning"`
 	StageVolume               bool              `yaml:"stage_volume" json:"stage_volume"`
+	Ephemeral                 bool              `yaml:"ephemeral" json:"ephemeral"`
+}
+
+// HasStagingMount checks if the volume is mounted at staging path and shared via bind mounts
+func (volume *NodeVolume) HasStagingMount() bool {
+	return volume.StageVolume && len(volume.StagingMountPath) > 0
+}
+
+// GetPublishPaths returns all publish target paths
+func (volume *NodeVolume) GetPublishPaths() []string {
+	if len(volume.PublishPaths) > 0 {
+		return volume.PublishPaths
+	}
+
+	// saved before publish paths were tracked
+	if len(volume.MountPath) > 0 {
+		return []string{volume.MountPath}
+	}
+	return []string{}
+}
+
+// AddPublishPath records the publish target path
+func (volume *NodeVolume) AddPublishPath(publishPath string) {
+	for _, existingPath := range volume.GetPublishPaths() {
+		if existingPath == publishPath {
+			return
+		}
+	}
+
+	volume.PublishPaths = append(volume.GetPublishPaths(), publishPath)
+}
+
+// RemovePublishPath removes the publish target path
+func (volume *NodeVolume) RemovePublishPath(publishPath string) {
+	publishPaths := []string{}
+	for _, existingPath := range volume.GetPublishPaths() {
+		if existingPath != publishPath {
+			publishPaths = append(publishPaths, existingPath)
+		}
+	}
+
+	volume.PublishPaths = publishPaths
+	if volume.MountPath == publishPath {
+		if len(publishPaths) > 0 {
+			volume.MountPath = publishPaths[len(publishPaths)-1]
+		} else {
+			volume.MountPath = ""
+		}
+	}
 }
 
 // NodeVolumeManager manages node volumes
@@ -173,6 +224,20 @@ func (manager *NodeVolumeManager) Put(volume *NodeVolume) error {
 	return manager.save()
 }
 
+// Update updates the volume with given id, returns false if not exist
+func (manager *NodeVolumeManager) Update(id string, update func(volume *NodeVolume)) (bool, error) {
+	manager.mutex.Lock()
+	defer manager.mutex.Unlock()
+
+	vol, ok := manager.volumes[id]
+	if !ok {
+		return false, nil
+	}
+
+	update(vol)
+	return true, manager.save()
+}
+
 // Pop returns NodeVolume with given id and delete
 func (manager *NodeVolumeManager) Pop(id string) (*NodeVolume, error) {
 	manager.mutex.Lock()
@@ -187,6 +252,18 @@ func (manager *NodeVolumeManager) Pop(id string) (*NodeVolume, error) {
 	return nil, nil
 }
 
+// List returns all NodeVolumes
+func (manager *NodeVolumeManager) List() []*NodeVolume {
+	manager.mutex.Lock()
+	defer manager.mutex.Unlock()
+
+	volumes := make([]*NodeVolume, 0, len(manager.volumes))
+	for _, vol := range manager.volumes {
+		volumes = append(volumes, vol)
+	}
+	return volumes
+}
+
 // Check returns presence of NodeVolume with given id
 func (manager *NodeVolumeManager) Check(id string) bool {
 	manager.mutex.Lock()
@@ -0,0 +1,293 @@
+package volumeinfo
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cyverse/irods-csi-driver/pkg/client/irods"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/klog"
+)
+
+const (
+	overlayFSSyncSaveFileName    string = "overlayfs_syncs.json"
+	overlayFSSyncProgressDirName string = "overlayfs_syncs"
+)
+
+// OverlayFSSyncManager persists overlayfs syncs in progress, used by node to resume syncs after restarts
+// sync records have configs to connect to iRODS, so they are encrypted
+// entries synced are appended to a progress log per volume, which has no secrets
+type OverlayFSSyncManager struct {
+	encryptKey      string
+	savefilePath    string
+	progressDirPath string
+	syncs           map[string]*irods.OverlayFSSyncRecord
+	progressFiles   map[string]*os.File
+	mutex           sync.Mutex
+}
+
+// NewOverlayFSSyncManager creates OverlayFSSyncManager
+func NewOverlayFSSyncManager(encryptKey string, saveDirPath string) (*OverlayFSSyncManager, error) {
+	if saveDirPath == "" {
+		saveDirPath = "/"
+	}
+
+	manager := &OverlayFSSyncManager{
+		encryptKey:      encryptKey,
+		savefilePath:    path.Join(saveDirPath, overlayFSSyncSaveFileName),
+		progressDirPath: path.Join(saveDirPath, overlayFSSyncProgressDirName),
+		syncs:           map[string]*irods.OverlayFSSyncRecord{},
+		progressFiles:   map[string]*os.File{},
+		mutex:           sync.Mutex{},
+	}
+
+	err := manager.load()
+	if err != nil {
+		klog.Errorf("failed to access overlayfs sync file %q, %s. ignoring...", manager.savefilePath, err)
+		return manager, nil
+	}
+
+	return manager, nil
+}
+
+func (manager *OverlayFSSyncManager) save() error {
+	jsonBytes, err := json.Marshal(manager.syncs)
+	if err != nil {
+		return status.Errorf(codes.Internal, "json marshal error: %s", err.Error())
+	}
+
+	dataBytes := jsonBytes
+	if len(manager.encryptKey) > 0 {
+		dataBytes, err = encrypt(jsonBytes, []byte(manager.encryptKey))
+		if err != nil {
+			return status.Errorf(codes.Internal, "encrypt error: %s", err.Error())
+		}
+	}
+
+	// write to a temp file and rename, not to lose records on crash
+	tempPath := manager.savefilePath + ".tmp"
+	err = os.WriteFile(tempPath, dataBytes, 0600)
+	if err != nil {
+		return status.Errorf(codes.Internal, "write file %q error: %s", tempPath, err.Error())
+	}
+
+	err = os.Rename(tempPath, manager.savefilePath)
+	if err != nil {
+		return status.Errorf(codes.Internal, "rename file %q error: %s", tempPath, err.Error())
+	}
+
+	return nil
+}
+
+func (manager *OverlayFSSyncManager) load() error {
+	dataBytes, err := os.ReadFile(manager.savefilePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			// file not exist
+			return nil
+		}
+
+		return status.Errorf(codes.Internal, "read file %q error: %s", manager.savefilePath, err.Error())
+	}
+
+	if len(dataBytes) == 0 {
+		// empty file
+		return nil
+	}
+
+	if len(manager.encryptKey) > 0 {
+		dataBytes, err = decrypt(dataBytes, []byte(manager.encryptKey))
+		if err != nil {
+			return status.Errorf(codes.Internal, "decrypt error: %s", err.Error())
+		}
+	}
+
+	if !json.Valid(dataBytes) {
+		return status.Errorf(codes.Internal, "invalid json data")
+	}
+
+	err = json.Unmarshal(dataBytes, &manager.syncs)
+	if err != nil {
+		return status.Errorf(codes.Internal, "json unmarshal error: %s", err.Error())
+	}
+
+	return nil
+}
+
+func (manager *OverlayFSSyncManager) getProgressFilePath(volID string) string {
+	return path.Join(manager.progressDirPath, volID+".log")
+}
+
+// Start records a sync of the volume, progress of a previous sync of the volume is kept to resume
+func (manager *OverlayFSSyncManager) Start(record *irods.OverlayFSSyncRecord) error {
+	manager.mutex.Lock()
+	defer manager.mutex.Unlock()
+
+	err := os.MkdirAll(manager.progressDirPath, 0700)
+	if err != nil {
+		return status.Errorf(codes.Internal, "mkdir %q error: %s", manager.progressDirPath, err.Error())
+	}
+
+	manager.syncs[record.VolumeID] = record
+	return manager.save()
+}
+
+// MarkDone records an entry of the upper as synced, with its size and modify time to detect changes on resume
+func (manager *OverlayFSSyncManager) MarkDone(volID string, entryPath string, entry irods.OverlayFSSyncDoneEntry) error {
+	manager.mutex.Lock()
+	defer manager.mutex.Unlock()
+
+	progressFile, ok := manager.progressFiles[volID]
+	if !ok {
+		progressFilePath := manager.getProgressFilePath(volID)
+		f, err := os.OpenFile(progressFilePath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+		if err != nil {
+			return status.Errorf(codes.Internal, "open file %q error: %s", progressFilePath, err.Error())
+		}
+
+		progressFile = f
+		manager.progressFiles[volID] = f
+	}
+
+	// quote as file names may have new lines
+	line := fmt.Sprintf("%s %d %d\n", strconv.Quote(entryPath), entry.Size, entry.ModTime.UnixNano())
+	_, err := progressFile.WriteString(line)
+	if err != nil {
+		return status.Errorf(codes.Internal, "write progress of %q error: %s", volID, err.Error())
+	}
+
+	return nil
+}
+
+// GetDone returns entries of the upper synced so far
+func (manager *OverlayFSSyncManager) GetDone(volID string) (map[string]irods.OverlayFSSyncDoneEntry, error) {
+	manager.mutex.Lock()
+	defer manager.mutex.Unlock()
+
+	done := map[string]irods.OverlayFSSyncDoneEntry{}
+
+	progressFilePath := manager.getProgressFilePath(volID)
+	f, err := os.Open(progressFilePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return done, nil
+		}
+		return nil, status.Errorf(codes.Internal, "open file %q error: %s", progressFilePath, err.Error())
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		entryPath, entry, ok := parseProgressLine(scanner.Text())
+		if !ok {
+			// partially written on crash, or written by old versions without states
+			continue
+		}
+
+		done[entryPath] = entry
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, status.Errorf(codes.Internal, "read file %q error: %s", progressFilePath, err.Error())
+	}
+
+	return done, nil
+}
+
+// parseProgressLine parses a line of the progress log, a quoted entry path, size and modify time in unix nanoseconds
+func parseProgressLine(line string) (string, irods.OverlayFSSyncDoneEntry, bool) {
+	entry := irods.OverlayFSSyncDoneEntry{}
+
+	quotedPath, err := strconv.QuotedPrefix(line)
+	if err != nil {
+		return "", entry, false
+	}
+
+	entryPath, err := strconv.Unquote(quotedPath)
+	if err != nil {
+		return "", entry, false
+	}
+
+	fields := strings.Fields(line[len(quotedPath):])
+	if len(fields) != 2 {
+		return "", entry, false
+	}
+
+	size, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil {
+		return "", entry, false
+	}
+
+	modTime, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil {
+		return "", entry, false
+	}
+
+	entry.Size = size
+	entry.ModTime = time.Unix(0, modTime)
+	return entryPath, entry, true
+}
+
+// Finish deletes the sync record and the progress of the volume
+func (manager *OverlayFSSyncManager) Finish(volID string) error {
+	manager.mutex.Lock()
+	defer manager.mutex.Unlock()
+
+	if progressFile, ok := manager.progressFiles[volID]; ok {
+		progressFile.Close()
+		delete(manager.progressFiles, volID)
+	}
+
+	progressFilePath := manager.getProgressFilePath(volID)
+	err := os.Remove(progressFilePath)
+	if err != nil && !os.IsNotExist(err) {
+		return status.Errorf(codes.Internal, "delete file %q error: %s", progressFilePath, err.Error())
+	}
+
+	if _, ok := manager.syncs[volID]; !ok {
+		return nil
+	}
+
+	delete(manager.syncs, volID)
+	return manager.save()
+}
+
+// Suspend closes the progress of the volume, keeping the sync record to resume later
+func (manager *OverlayFSSyncManager) Suspend(volID string) error {
+	manager.mutex.Lock()
+	defer manager.mutex.Unlock()
+
+	progressFile, ok := manager.progressFiles[volID]
+	if !ok {
+		return nil
+	}
+
+	delete(manager.progressFiles, volID)
+
+	err := progressFile.Sync()
+	progressFile.Close()
+	if err != nil {
+		return status.Errorf(codes.Internal, "sync progress of %q error: %s", volID, err.Error())
+	}
+	return nil
+}
+
+// List returns all syncs not finished
+func (manager *OverlayFSSyncManager) List() []*irods.OverlayFSSyncRecord {
+	manager.mutex.Lock()
+	defer manager.mutex.Unlock()
+
+	records := make([]*irods.OverlayFSSyncRecord, 0, len(manager.syncs))
+	for _, record := range manager.syncs {
+		records = append(records, record)
+	}
+	return records
+}